@@ -0,0 +1,196 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/gameserver"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/api/webhook"
+	"libdb.so/scouts-server/internal/context"
+	"libdb.so/scouts-server/internal/unmarshal"
+	"libdb.so/scouts-server/scouts"
+)
+
+// AdminServices defines the services that the admin handler needs to
+// function.
+type AdminServices struct {
+	*gameserver.GameManager
+	user.SessionAdminStorage
+	*webhook.Dispatcher
+}
+
+var errAdminUnauthorized = hrt.NewHTTPError(http.StatusUnauthorized, "invalid admin token")
+
+// AdminHandler is the handler of the admin API, which lets operators inspect
+// and manage the games and sessions of the server.
+type AdminHandler struct {
+	*chi.Mux
+	service AdminServices
+	token   string
+}
+
+// NewAdminHandler creates a new admin API handler. Requests are logged to
+// logger, and must carry the given token as a bearer token in their
+// Authorization header. The token must not be empty.
+func NewAdminHandler(service AdminServices, logger *slog.Logger, token string) *AdminHandler {
+	if token == "" {
+		panic("api: empty admin token")
+	}
+
+	h := &AdminHandler{
+		service: service,
+		token:   token,
+	}
+
+	h.Mux = chi.NewRouter()
+	h.Use(
+		traceRequests,
+		logRequests(logger),
+		middleware.CleanPath,
+		middleware.RealIP,
+		middleware.Recoverer,
+		h.authorize,
+		hrt.Use(hrt.Opts{
+			Encoder:     hrt.DefaultEncoder,
+			ErrorWriter: errorWriter,
+		}),
+	)
+
+	h.Route("/games", func(r chi.Router) {
+		r.Get("/", hrt.Wrap(h.listGames))
+		r.Route("/{id}", func(r chi.Router) {
+			r.Use(h.parseGameID)
+			r.Get("/", hrt.Wrap(h.dumpGame))
+			r.Delete("/", hrt.Wrap(h.evictGame))
+			r.Post("/end", hrt.Wrap(h.endGame))
+		})
+	})
+	h.Route("/sessions", func(r chi.Router) {
+		r.Get("/", hrt.Wrap(h.listSessions))
+		r.Post("/revoke", hrt.Wrap(h.revokeSession))
+	})
+	h.Route("/webhooks", func(r chi.Router) {
+		r.Get("/", hrt.Wrap(h.listWebhooks))
+		r.Post("/", hrt.Wrap(h.registerWebhook))
+		r.Get("/dead-letters", hrt.Wrap(h.listDeadLetters))
+		r.With(h.parseWebhookID).Delete("/{id}", hrt.Wrap(h.deleteWebhook))
+	})
+	h.Mount("/debug", middleware.Profiler())
+
+	return h
+}
+
+func (h *AdminHandler) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			errorWriter.WriteError(w, errAdminUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *AdminHandler) parseGameID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idstr := chi.URLParam(r, "id")
+		if idstr == "" {
+			err := errors.New("missing game ID")
+			errorWriter.WriteError(w, hrt.WrapHTTPError(http.StatusBadRequest, err))
+			return
+		}
+
+		id, err := unmarshal.Text[*gameserver.GameID](idstr)
+		if err != nil {
+			errorWriter.WriteError(w, hrt.WrapHTTPError(http.StatusBadRequest, err))
+			return
+		}
+
+		ctx := context.With(r.Context(), *id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *AdminHandler) parseWebhookID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := unmarshal.Text[*webhook.ID](chi.URLParam(r, "id"))
+		if err != nil {
+			errorWriter.WriteError(w, hrt.WrapHTTPError(http.StatusBadRequest, err))
+			return
+		}
+
+		ctx := context.With(r.Context(), *id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *AdminHandler) listGames(ctx context.Context, _ hrt.None) ([]gameserver.GameDump, error) {
+	return h.service.DumpGames(), nil
+}
+
+func (h *AdminHandler) dumpGame(ctx context.Context, _ hrt.None) (gameserver.GameDump, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	return h.service.DumpGame(gameID)
+}
+
+func (h *AdminHandler) evictGame(ctx context.Context, _ hrt.None) (hrt.None, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	return hrt.Empty, h.service.EvictGame(gameID)
+}
+
+type endGameRequest struct {
+	// Winner is the side that is adjudicated to have won the game, or 0 for
+	// a draw.
+	Winner scouts.Player `json:"winner"`
+}
+
+func (h *AdminHandler) endGame(ctx context.Context, req endGameRequest) (hrt.None, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	return hrt.Empty, h.service.AdjudicateGame(gameID, req.Winner)
+}
+
+func (h *AdminHandler) listSessions(ctx context.Context, _ hrt.None) ([]user.Session, error) {
+	return h.service.ListSessions()
+}
+
+type revokeSessionRequest struct {
+	// Handle is the handle of the session, as listed by listSessions.
+	Handle user.SessionHandle `json:"handle"`
+}
+
+func (h *AdminHandler) revokeSession(ctx context.Context, req revokeSessionRequest) (hrt.None, error) {
+	return hrt.Empty, h.service.RevokeSession(req.Handle)
+}
+
+func (h *AdminHandler) listWebhooks(ctx context.Context, _ hrt.None) ([]webhook.Webhook, error) {
+	return h.service.ListWebhooks(), nil
+}
+
+type registerWebhookRequest struct {
+	// URL is where events are POSTed to.
+	URL string `json:"url"`
+	// Events are the types of events to be notified of, or all of them if
+	// empty.
+	Events []webhook.EventType `json:"events"`
+}
+
+func (h *AdminHandler) registerWebhook(ctx context.Context, req registerWebhookRequest) (webhook.Webhook, error) {
+	return h.service.RegisterWebhook(req.URL, req.Events)
+}
+
+func (h *AdminHandler) deleteWebhook(ctx context.Context, _ hrt.None) (hrt.None, error) {
+	webhookID := context.From[webhook.ID](ctx)
+	return hrt.Empty, h.service.DeleteWebhook(webhookID)
+}
+
+func (h *AdminHandler) listDeadLetters(ctx context.Context, _ hrt.None) ([]webhook.DeadLetter, error) {
+	return h.service.ListDeadLetters()
+}
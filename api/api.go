@@ -1,22 +1,29 @@
 package api
 
 import (
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"libdb.so/hrt"
 	"libdb.so/scouts-server/api/gameserver"
 	"libdb.so/scouts-server/api/user"
 	"libdb.so/scouts-server/internal/context"
+	"libdb.so/scouts-server/internal/tracing"
 	"libdb.so/scouts-server/internal/unmarshal"
 )
 
 // Services defines the services that the API handler needs to function.
 type Services struct {
 	*gameserver.GameManager
+	*gameserver.Matchmaker
+	*gameserver.Lobby
 	user.SessionStorage
 }
 
@@ -28,14 +35,20 @@ type Handler struct {
 	service Services
 }
 
-// NewHandler creates a new API handler.
-func NewHandler(service Services) *Handler {
+// NewHandler creates a new API handler. Requests are logged to logger. If
+// metrics is not nil, then the metrics of the handler are registered to it.
+func NewHandler(service Services, logger *slog.Logger, metrics prometheus.Registerer) *Handler {
 	h := &Handler{service: service}
 
 	h.Mux = chi.NewRouter()
 
+	if metrics != nil {
+		h.Use(h.registerMetrics(metrics))
+	}
+
 	h.Use(
-		middleware.Logger,
+		traceRequests,
+		logRequests(logger),
 		cors.Handler(cors.Options{
 			AllowedOrigins:   []string{"https://*", "http://*"},
 			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
@@ -45,68 +58,119 @@ func NewHandler(service Services) *Handler {
 		middleware.CleanPath,
 		middleware.RealIP,
 		middleware.Recoverer,
-		h.authorize,
 		hrt.Use(hrt.Opts{
 			Encoder:     hrt.DefaultEncoder,
 			ErrorWriter: hrt.JSONErrorWriter("error"),
 		}),
 	)
 
-	mountGameHandler(h.Mux, gameServices{
-		GameManager: service.GameManager,
+	// The server time doesn't need a session, so don't create one for clients
+	// that are only syncing their clocks.
+	h.Get("/time", hrt.Wrap(serverTime))
+
+	h.Group(func(r chi.Router) {
+		r.Use(h.authorize)
+
+		mountGameHandler(r, gameServices{
+			GameManager: service.GameManager,
+		})
+		mountUserHandler(r, userServices{
+			GameManager: service.GameManager,
+		})
+		mountMatchmakingHandler(r, matchmakingServices{
+			Matchmaker: service.Matchmaker,
+		})
+		mountLobbyHandler(r, lobbyServices{
+			Lobby: service.Lobby,
+		})
 	})
 
 	return h
 }
 
+type serverTimeResponse struct {
+	ServerTime time.Time `json:"server_time"`
+}
+
+// serverTime returns the current server time. Clients can use it to estimate
+// the offset between their clock and the server's, which is needed to make
+// sense of the server times and deadlines within game events.
+func serverTime(ctx context.Context, _ hrt.None) (serverTimeResponse, error) {
+	return serverTimeResponse{ServerTime: time.Now()}, nil
+}
+
 func (h *Handler) authorize(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var token user.SessionToken
-
-		if cookie, err := r.Cookie("session"); err == nil {
-			// session cookie is set
-			t, err := unmarshal.Text[*user.SessionToken](cookie.Value)
-			if err != nil {
-				errorWriter.WriteError(w, err)
-				return
-			}
-			token = *t
-		} else {
-			// session cookie is not set, so we create one
-			t, err := h.service.CreateSession()
-			if err != nil {
-				errorWriter.WriteError(w, err)
-				return
-			}
-			tokenBytes, err := t.MarshalText()
-			if err != nil {
-				errorWriter.WriteError(w, err)
-				return
-			}
-			http.SetCookie(w, &http.Cookie{
-				Name:     "session",
-				Value:    string(tokenBytes),
-				MaxAge:   int(user.SessionTTL / time.Second),
-				SameSite: http.SameSiteNoneMode,
-				Secure:   true,
-			})
-			token = t
-		}
-
-		userID, err := h.service.QuerySession(token)
+		authorization, err := h.authorizeRequest(w, r)
 		if err != nil {
 			errorWriter.WriteError(w, err)
 			return
 		}
 
-		var authorization user.Authorization
-		if userID != nil {
-			authorization = user.NewAuthorized(token, *userID)
-		} else {
-			authorization = user.NewAnonymous(token)
-		}
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			tracing.UserKey.String(authorization.String()))
 
 		ctx := context.With(r.Context(), authorization)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// authorizeRequest returns the authorization of the session of the request.
+// If the request has no session, then a new one is created and set as a
+// cookie.
+func (h *Handler) authorizeRequest(w http.ResponseWriter, r *http.Request) (_ user.Authorization, err error) {
+	ctx, span := tracer.Start(r.Context(), "Handler.authorize")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	var token user.SessionToken
+
+	if cookie, err := r.Cookie("session"); err == nil {
+		// session cookie is set
+		t, err := unmarshal.Text[*user.SessionToken](cookie.Value)
+		if err != nil {
+			return user.Authorization{}, err
+		}
+		token = *t
+	} else {
+		// session cookie is not set, so we create one
+		t, err := h.service.CreateSession()
+		if err != nil {
+			return user.Authorization{}, err
+		}
+		tokenBytes, err := t.MarshalText()
+		if err != nil {
+			return user.Authorization{}, err
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     "session",
+			Value:    string(tokenBytes),
+			MaxAge:   int(user.SessionTTL / time.Second),
+			SameSite: http.SameSiteNoneMode,
+			Secure:   true,
+		})
+		token = t
+	}
+
+	_, querySpan := tracer.Start(ctx, "SessionStorage.QuerySession")
+	userID, err := h.service.QuerySession(token)
+	querySpan.End()
+	if err != nil {
+		return user.Authorization{}, err
+	}
+
+	var authorization user.Authorization
+	if userID != nil {
+		authorization = user.NewAuthorized(token, *userID)
+	} else {
+		authorization = user.NewAnonymous(token)
+	}
+
+	span.SetAttributes(tracing.UserKey.String(authorization.String()))
+	return authorization, nil
+}
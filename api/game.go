@@ -4,13 +4,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/trace"
 	"libdb.so/hrt"
 	"libdb.so/scouts-server/api/gameserver"
 	"libdb.so/scouts-server/api/user"
 	"libdb.so/scouts-server/internal/context"
+	"libdb.so/scouts-server/internal/tracing"
 	"libdb.so/scouts-server/internal/unmarshal"
 	"libdb.so/scouts-server/scouts"
 )
@@ -23,16 +28,29 @@ type gameHandler struct {
 	service gameServices
 }
 
-func mountGameHandler(r *chi.Mux, service gameServices) {
+func mountGameHandler(r chi.Router, service gameServices) {
 	h := &gameHandler{service: service}
 	r.Route("/game", func(r chi.Router) {
+		r.Get("/", hrt.Wrap(h.listGames))
 		r.Post("/", hrt.Wrap(h.createGame))
 	})
+	r.Get("/invite/{code}", hrt.Wrap(h.resolveInvite))
 	r.Route("/game/{id}", func(r chi.Router) {
 		r.Use(h.authorizeGame)
 		r.Get("/", hrt.Wrap(h.gameInfo))
+		r.Get("/moves", hrt.Wrap(h.legalMoves))
+		r.Get("/state", hrt.Wrap(h.gameStateAt))
 		r.Post("/join", hrt.Wrap(h.joinGame))
 		r.Post("/move", hrt.Wrap(h.makeMove))
+		r.Post("/turn", hrt.Wrap(h.makeTurn))
+		r.Post("/rematch", hrt.Wrap(h.rematch))
+		r.Post("/pong", hrt.Wrap(h.pong))
+		r.Post("/chat", hrt.Wrap(h.sendChat))
+		r.Route("/offers/{kind}", func(r chi.Router) {
+			r.Post("/", hrt.Wrap(h.makeOffer))
+			r.Post("/accept", hrt.Wrap(h.acceptOffer))
+			r.Post("/decline", hrt.Wrap(h.declineOffer))
+		})
 		r.Get("/subscribe", h.subscribeGame)
 	})
 }
@@ -52,6 +70,23 @@ func (h *gameHandler) authorizeGame(next http.Handler) http.Handler {
 			return
 		}
 
+		var code gameserver.InviteCode
+		if codestr := r.URL.Query().Get("invite_code"); codestr != "" {
+			if err := code.UnmarshalText([]byte(codestr)); err != nil {
+				errorWriter.WriteError(w, hrt.WrapHTTPError(http.StatusBadRequest, err))
+				return
+			}
+		}
+
+		authorization := context.From[user.Authorization](r.Context())
+		if err := h.service.AuthorizeGame(authorization, *id, code); err != nil {
+			errorWriter.WriteError(w, err)
+			return
+		}
+
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			tracing.GameIDKey.String(id.String()))
+
 		ctx := context.With(r.Context(), *id)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -60,24 +95,71 @@ func (h *gameHandler) authorizeGame(next http.Handler) http.Handler {
 type createGameRequest struct {
 	TimeLimit gameserver.Duration `json:"time_limit"`
 	Increment gameserver.Duration `json:"increment"`
+	Rated     bool                `json:"rated"`
+	// Side is the side that the creator would like to play.
+	Side gameserver.SidePreference `json:"side"`
+	// AutoJoin is true if the creator should join the game right away.
+	AutoJoin bool `json:"auto_join"`
+	// Private is true if the game should only be accessible with its invite
+	// code.
+	Private bool `json:"private"`
+	// MaxLagCompensation is the maximum total time that each player can be
+	// credited for their connection's latency.
+	MaxLagCompensation gameserver.Duration `json:"max_lag_compensation"`
 }
 
 type createGameResponse struct {
-	GameID gameserver.GameID `json:"game_id"`
+	GameID     gameserver.GameID     `json:"game_id"`
+	InviteCode gameserver.InviteCode `json:"invite_code,omitempty"`
 }
 
 func (h *gameHandler) createGame(ctx context.Context, req createGameRequest) (createGameResponse, error) {
 	authorization := context.From[user.Authorization](ctx)
 
 	id, err := h.service.CreateGame(authorization, gameserver.CreateGameOptions{
-		TimeLimit: req.TimeLimit,
-		Increment: req.Increment,
+		TimeLimit:          req.TimeLimit,
+		Increment:          req.Increment,
+		Rated:              req.Rated,
+		Side:               req.Side,
+		AutoJoin:           req.AutoJoin,
+		Private:            req.Private,
+		MaxLagCompensation: req.MaxLagCompensation,
 	})
 	if err != nil {
 		return createGameResponse{}, err
 	}
 
-	return createGameResponse{GameID: id}, nil
+	state, err := h.service.QueryGame(id)
+	if err != nil {
+		return createGameResponse{}, err
+	}
+
+	return createGameResponse{
+		GameID:     id,
+		InviteCode: state.InviteCode,
+	}, nil
+}
+
+func (h *gameHandler) listGames(ctx context.Context, _ hrt.None) ([]gameserver.GameState, error) {
+	return h.service.ListGames(), nil
+}
+
+type resolveInviteResponse struct {
+	GameID gameserver.GameID `json:"game_id"`
+}
+
+func (h *gameHandler) resolveInvite(ctx context.Context, _ hrt.None) (resolveInviteResponse, error) {
+	code, err := unmarshal.Text[*gameserver.InviteCode](chi.URLParamFromCtx(ctx, "code"))
+	if err != nil {
+		return resolveInviteResponse{}, hrt.WrapHTTPError(http.StatusBadRequest, err)
+	}
+
+	id, err := h.service.ResolveInviteCode(*code)
+	if err != nil {
+		return resolveInviteResponse{}, err
+	}
+
+	return resolveInviteResponse{GameID: id}, nil
 }
 
 func (h *gameHandler) gameInfo(ctx context.Context, _ hrt.None) (gameserver.GameState, error) {
@@ -85,14 +167,81 @@ func (h *gameHandler) gameInfo(ctx context.Context, _ hrt.None) (gameserver.Game
 	return h.service.QueryGame(gameID)
 }
 
-func (h *gameHandler) joinGame(ctx context.Context, _ hrt.None) (hrt.None, error) {
+type gameStateAtRequest struct {
+	// Ply is the number of moves to replay.
+	// If empty, then all moves are replayed.
+	Ply string `query:"ply"`
+}
+
+func (h *gameHandler) gameStateAt(ctx context.Context, req gameStateAtRequest) (gameserver.PlyState, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+
+	if req.Ply == "" {
+		return h.service.QueryGameAt(gameID, -1)
+	}
+
+	ply, err := strconv.Atoi(req.Ply)
+	if err != nil {
+		return gameserver.PlyState{}, hrt.WrapHTTPError(http.StatusBadRequest, err)
+	}
+
+	return h.service.QueryGameAt(gameID, ply)
+}
+
+func (h *gameHandler) legalMoves(ctx context.Context, _ hrt.None) (gameserver.LegalMoves, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	return h.service.LegalMoves(gameID)
+}
+
+type joinGameRequest struct {
+	// Side is the side that the player would like to play.
+	// If empty, then the player takes the side not reserved for the creator.
+	Side gameserver.SidePreference `json:"side"`
+}
+
+type joinGameResponse struct {
+	PlayerSide scouts.Player `json:"player_side"`
+}
+
+func (h *gameHandler) joinGame(ctx context.Context, _ hrt.None) (joinGameResponse, error) {
 	gameID := context.From[gameserver.GameID](ctx)
 	authorization := context.From[user.Authorization](ctx)
-	return hrt.Empty, h.service.JoinGame(authorization, gameID)
+
+	// The body is optional, since most players don't mind which side they
+	// play.
+	var req joinGameRequest
+	if err := decodeOptionalJSON(ctx, &req); err != nil {
+		return joinGameResponse{}, err
+	}
+
+	side, err := h.service.JoinGame(authorization, gameID, req.Side)
+	if err != nil {
+		return joinGameResponse{}, err
+	}
+
+	return joinGameResponse{PlayerSide: side}, nil
 }
 
 type makeMoveRequest struct {
 	Move string `json:"move"`
+	moveGuards
+}
+
+// moveGuards contains the optional guards of a move request.
+type moveGuards struct {
+	// MoveIndex is the number of moves that the client expects the game to
+	// have. If it doesn't match, then the request fails with 409 Conflict.
+	MoveIndex *int `json:"move_index"`
+	// IdempotencyKey is a client-chosen key. Repeating a request with the
+	// same key returns the original result instead of making the move again.
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+func (g moveGuards) options() gameserver.MoveOptions {
+	return gameserver.MoveOptions{
+		MoveIndex:      g.MoveIndex,
+		IdempotencyKey: g.IdempotencyKey,
+	}
 }
 
 func (h *gameHandler) makeMove(ctx context.Context, req makeMoveRequest) (hrt.None, error) {
@@ -104,11 +253,96 @@ func (h *gameHandler) makeMove(ctx context.Context, req makeMoveRequest) (hrt.No
 		return hrt.Empty, err
 	}
 
-	return hrt.Empty, h.service.MakeMove(authorization, gameID, move)
+	return hrt.Empty, h.service.MakeMove(ctx, authorization, gameID, move, req.options())
+}
+
+type makeTurnRequest struct {
+	Moves scouts.Moves `json:"moves"`
+	moveGuards
+}
+
+func (h *gameHandler) makeTurn(ctx context.Context, req makeTurnRequest) (hrt.None, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+	return hrt.Empty, h.service.MakeTurn(ctx, authorization, gameID, req.Moves, req.options())
+}
+
+func (h *gameHandler) makeOffer(ctx context.Context, _ hrt.None) (hrt.None, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+
+	kind, err := offerKindParam(ctx)
+	if err != nil {
+		return hrt.Empty, err
+	}
+
+	return hrt.Empty, h.service.MakeOffer(authorization, gameID, kind)
+}
+
+func (h *gameHandler) acceptOffer(ctx context.Context, _ hrt.None) (hrt.None, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+
+	kind, err := offerKindParam(ctx)
+	if err != nil {
+		return hrt.Empty, err
+	}
+
+	return hrt.Empty, h.service.AcceptOffer(authorization, gameID, kind)
+}
+
+func (h *gameHandler) declineOffer(ctx context.Context, _ hrt.None) (hrt.None, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+
+	kind, err := offerKindParam(ctx)
+	if err != nil {
+		return hrt.Empty, err
+	}
+
+	return hrt.Empty, h.service.DeclineOffer(authorization, gameID, kind)
+}
+
+func offerKindParam(ctx context.Context) (gameserver.OfferKind, error) {
+	kind, err := unmarshal.Text[*gameserver.OfferKind](chi.URLParamFromCtx(ctx, "kind"))
+	if err != nil {
+		return "", hrt.WrapHTTPError(http.StatusBadRequest, err)
+	}
+	return *kind, nil
+}
+
+func (h *gameHandler) rematch(ctx context.Context, _ hrt.None) (gameserver.RematchResult, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+	return h.service.Rematch(authorization, gameID)
+}
+
+type sendChatRequest struct {
+	Text string `json:"text"`
+}
+
+func (h *gameHandler) sendChat(ctx context.Context, req sendChatRequest) (hrt.None, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+	return hrt.Empty, h.service.SendChat(authorization, gameID, req.Text)
+}
+
+type pongRequest struct {
+	PingID int `json:"ping_id"`
+}
+
+func (h *gameHandler) pong(ctx context.Context, req pongRequest) (hrt.None, error) {
+	gameID := context.From[gameserver.GameID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+	return hrt.Empty, h.service.PongGame(authorization, gameID, req.PingID)
 }
 
 var errNoFlusher = hrt.NewHTTPError(400, "client does not support Server-Sent Events")
 
+// pingInterval is the interval at which players are pinged to estimate the
+// latency of their connection.
+const pingInterval = 5 * time.Second
+
 func (h *gameHandler) subscribeGame(w http.ResponseWriter, r *http.Request) {
 	if _, ok := w.(http.Flusher); !ok {
 		errorWriter.WriteError(w, errNoFlusher)
@@ -118,23 +352,57 @@ func (h *gameHandler) subscribeGame(w http.ResponseWriter, r *http.Request) {
 	gameID := context.From[gameserver.GameID](r.Context())
 	authorization := context.From[user.Authorization](r.Context())
 
-	events, stop, err := h.service.SubscribeGame(authorization, gameID)
+	sub, err := h.service.SubscribeGame(authorization, gameID)
 	if err != nil {
 		errorWriter.WriteError(w, err)
 		return
 	}
-	defer stop()
+	defer sub.Close()
 
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 
-	for event := range events {
-		writeGameEvent(w, event)
+	// Wait for events until it's time to ping again. Spectators aren't
+	// pinged, so pinging is stopped once a ping fails.
+	pinging := true
+	nextPing := time.Now().Add(pingInterval)
+
+	for {
+		ctx := r.Context()
+		cancel := func() {}
+		if pinging {
+			ctx, cancel = context.WithDeadline(ctx, nextPing)
+		}
+
+		event, err := sub.Next(ctx)
+		cancel()
+
+		switch {
+		case err == nil:
+			writeEvent(w, event)
+		case errors.Is(err, context.DeadlineExceeded) && r.Context().Err() == nil:
+			nextPing = time.Now().Add(pingInterval)
+			event, err := h.service.PingGame(authorization, gameID)
+			if err != nil {
+				pinging = false
+				continue
+			}
+			writeEvent(w, event)
+		default:
+			// The game has gone away, the subscriber fell too far behind or
+			// the client has disconnected.
+			return
+		}
 	}
 }
 
-// writeGameEvent writes a game event as a Server-Sent Event.
-func writeGameEvent(w http.ResponseWriter, event gameserver.GameEvent) {
+// sseEvent is an event that can be written as a Server-Sent Event.
+type sseEvent interface {
+	Type() string
+}
+
+// writeEvent writes an event as a Server-Sent Event.
+func writeEvent(w http.ResponseWriter, event sseEvent) {
 	eventJSON, err := json.Marshal(event)
 	if err != nil {
 		panic(err)
@@ -149,3 +417,13 @@ func writeGameEvent(w http.ResponseWriter, event gameserver.GameEvent) {
 	}
 	flusher.Flush()
 }
+
+// decodeOptionalJSON decodes the JSON body of the request into v. Unlike the
+// default decoder, it leaves v untouched if the body is empty.
+func decodeOptionalJSON(ctx context.Context, v any) error {
+	r := hrt.RequestFromContext(ctx)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return hrt.WrapHTTPError(http.StatusBadRequest, err)
+	}
+	return nil
+}
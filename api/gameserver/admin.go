@@ -0,0 +1,147 @@
+package gameserver
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/scouts"
+)
+
+// GameDump is the state of a game in memory as seen by operators. Unlike
+// GameState, it includes the internal state of the game.
+type GameDump struct {
+	GameState
+	// Stopped is true if the game has been stopped, in which case it can no
+	// longer be changed and is about to be evicted.
+	Stopped bool `json:"stopped"`
+	// Connected contains whether each side has a subscriber, with player A
+	// first.
+	Connected [2]bool `json:"connected"`
+	// Subscribers is the number of open subscriptions to the game, including
+	// those of spectators.
+	Subscribers int `json:"subscribers"`
+	// TimeRemaining is the time remaining for both sides.
+	TimeRemaining [2]Duration `json:"time_remaining"`
+	// RoundTripTimes is the estimated round-trip time of each side's
+	// connection, with player A first. It is zero until the first ping has
+	// been acknowledged.
+	RoundTripTimes [2]Duration `json:"round_trip_times"`
+	// Rematching is true while the rematch game is being created.
+	Rematching bool `json:"rematching"`
+	// EventLog is true if the game delivers its events through an event log
+	// instead of a queue per subscriber.
+	EventLog bool `json:"event_log"`
+}
+
+// Dump returns the internal state of the game.
+func (g *gameInstance) Dump() GameDump {
+	var d GameDump
+	g.view(func() {
+		d = GameDump{
+			GameState:      g.stateSnapshot(),
+			Connected:      [2]bool{g.playerAConnected, g.playerBConnected},
+			TimeRemaining:  [2]Duration{InfiniteDuration, InfiniteDuration},
+			RoundTripTimes: [2]Duration{g.lag[0].rtt, g.lag[1].rtt},
+			Rematching:     g.rematching,
+			EventLog:       g.eventLog != nil,
+		}
+		if g.timer != nil {
+			d.TimeRemaining = [2]Duration{
+				g.timer.RemainingAt(d.SnapshotAt, scouts.PlayerA),
+				g.timer.RemainingAt(d.SnapshotAt, scouts.PlayerB),
+			}
+		}
+	})
+
+	select {
+	case <-g.stopCh:
+		d.Stopped = true
+	default:
+	}
+	d.Subscribers = int(g.numSubscribers.Load())
+
+	return d
+}
+
+// Adjudicate ends the running game with the given winner, which is PlayerNone
+// for a draw, regardless of the state of the board.
+func (g *gameInstance) Adjudicate(winner scouts.Player) error {
+	if winner != scouts.PlayerNone {
+		if err := winner.Validate(); err != nil {
+			return hrt.WrapHTTPError(http.StatusBadRequest, err)
+		}
+	}
+
+	now := g.clock.Now()
+	return g.doErr(func() error {
+		switch {
+		case g.state.BeganAt == nil:
+			return fmt.Errorf("%w: game has not begun", ErrInvalidGameState)
+		case g.state.EndedAt != nil:
+			return fmt.Errorf("%w: game has already ended", ErrInvalidGameState)
+		}
+
+		g.timer.Elapse(now, g.game.CurrentTurn().Player)
+		g.endGame(now, winner)
+		g.sendEvent(GameEndEvent{
+			Winner:        winner,
+			TimeRemaining: g.timer.Remaining(),
+		})
+
+		g.logger.Info(
+			"game has been adjudicated",
+			"winner", winner)
+
+		return nil
+	})
+}
+
+// DumpGames returns the internal state of all games in memory, including
+// private and finished ones, newest first.
+func (m *GameManager) DumpGames() []GameDump {
+	var dumps []GameDump
+	m.games.Range(func(id GameID, game *gameInstance) bool {
+		dumps = append(dumps, game.Dump())
+		return true
+	})
+
+	slices.SortFunc(dumps, func(a, b GameDump) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return dumps
+}
+
+// DumpGame returns the internal state of the game with the given game ID.
+// Archived games cannot be dumped.
+func (m *GameManager) DumpGame(id GameID) (GameDump, error) {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return GameDump{}, ErrNotFound
+	}
+	return game.Dump(), nil
+}
+
+// AdjudicateGame ends the running game with the given game ID with the given
+// winner, which is PlayerNone for a draw.
+func (m *GameManager) AdjudicateGame(id GameID, winner scouts.Player) error {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return ErrNotFound
+	}
+	return game.Adjudicate(winner)
+}
+
+// EvictGame stops the game with the given game ID and removes it from memory
+// right away, instead of waiting for it to be garbage collected. Subscribers
+// are sent a GoingAwayEvent. Finished games are archived first.
+func (m *GameManager) EvictGame(id GameID) error {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return ErrNotFound
+	}
+	game.Stop(GoingAwayGameEvicted)
+	return m.evictGame(id, game)
+}
@@ -0,0 +1,79 @@
+package gameserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/scouts"
+)
+
+func TestGameManagerAdmin(t *testing.T) {
+	archive := newMemoryGameArchive()
+	games := NewGameManager(slogt.New(t), GameManagerOptions{GameArchive: archive})
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	id, err := games.CreateGame(user1, CreateGameOptions{AutoJoin: true, Side: SideA})
+	assert.NoError(t, err)
+
+	err = games.AdjudicateGame(id, scouts.PlayerA)
+	assert.IsError(t, err, ErrInvalidGameState)
+
+	_, err = games.JoinGame(user2, id, "")
+	assert.NoError(t, err)
+
+	events, err := games.SubscribeGame(user1, id)
+	assert.NoError(t, err)
+	spectator, err := games.SubscribeGame(user.NewAnonymous(user.GenerateSessionToken()), id)
+	assert.NoError(t, err)
+	spectator.Close()
+
+	dump, err := games.DumpGame(id)
+	assert.NoError(t, err)
+	assert.Equal(t, [2]bool{true, false}, dump.Connected)
+	assert.Equal(t, 1, dump.Subscribers)
+	assert.False(t, dump.Stopped)
+
+	dumps := games.DumpGames()
+	assert.Equal(t, 1, len(dumps))
+	assert.Equal(t, id, dumps[0].GameID)
+
+	err = games.AdjudicateGame(id, scouts.PlayerB)
+	assert.NoError(t, err)
+	err = games.AdjudicateGame(id, scouts.PlayerA)
+	assert.IsError(t, err, ErrInvalidGameState)
+
+	state, err := games.QueryGame(id)
+	assert.NoError(t, err)
+	assert.NotZero(t, state.EndedAt)
+	assert.Equal(t, scouts.PlayerB, state.Winner)
+
+	assert.NoError(t, games.EvictGame(id))
+	assert.IsError(t, games.EvictGame(id), ErrNotFound)
+
+	var ended, goingAway bool
+	for !goingAway {
+		ev, err := events.Next(context.Background())
+		assert.NoError(t, err)
+		switch ev := ev.(type) {
+		case GameEndEvent:
+			assert.Equal(t, scouts.PlayerB, ev.Winner)
+			ended = true
+		case GoingAwayEvent:
+			assert.Equal(t, GoingAwayGameEvicted, ev.Reason)
+			goingAway = true
+		}
+	}
+	assert.True(t, ended)
+	events.Close()
+
+	_, err = games.DumpGame(id)
+	assert.IsError(t, err, ErrNotFound)
+
+	_, err = archive.QueryArchivedGame(id)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,174 @@
+package gameserver
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/scouts"
+)
+
+// GameArchive is a service that persists finished games once they are evicted
+// from memory, so that they can still be viewed. Games that are still being
+// played when the server shuts down are archived as well.
+type GameArchive interface {
+	// ArchiveGame stores the game.
+	ArchiveGame(ArchivedGame) error
+	// QueryArchivedGame queries the archived game with the given game ID.
+	// ErrNotFound is returned if there is no such game.
+	QueryArchivedGame(GameID) (ArchivedGame, error)
+}
+
+// ArchivedGame is the record of a game that is no longer in memory. It only
+// contains what cannot be rebuilt by replaying the moves of the game.
+type ArchivedGame struct {
+	GameID     GameID              `json:"game_id"`
+	InviteCode InviteCode          `json:"invite_code,omitempty"`
+	BeganAt    *time.Time          `json:"began_at"`
+	PlayerA    *user.Authorization `json:"player_a"`
+	PlayerB    *user.Authorization `json:"player_b"`
+	// EndedAt is nil if the game was still being played when it was
+	// archived.
+	EndedAt *time.Time     `json:"ended_at"`
+	Winner  scouts.Player  `json:"winner"`
+	Moves   []MoveSnapshot `json:"moves"`
+	// Chat is the chat of both rooms of the game.
+	Chat []ChatMessage `json:"chat,omitempty"`
+	// Phase is the phase that the game was in, which can't be told from the
+	// moves alone if the game ended by other means, e.g. on time.
+	Phase     scouts.Phase      `json:"phase"`
+	RematchID *GameID           `json:"rematch_id"`
+	Ratings   *GameRatings      `json:"ratings"`
+	Metadata  CreateGameOptions `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+	// ArchivedAt is the time that the game was archived.
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// archiveGame returns the record of the given game.
+func archiveGame(state GameState, now time.Time) ArchivedGame {
+	return ArchivedGame{
+		GameID:     state.GameID,
+		InviteCode: state.InviteCode,
+		BeganAt:    state.BeganAt,
+		PlayerA:    state.PlayerA,
+		PlayerB:    state.PlayerB,
+		EndedAt:    state.EndedAt,
+		Winner:     state.Winner,
+		Moves:      state.Moves,
+		Chat:       state.Chat,
+		Phase:      state.Phase,
+		RematchID:  state.RematchID,
+		Ratings:    state.Ratings,
+		Metadata:   state.Metadata,
+		CreatedAt:  state.CreatedAt,
+		ArchivedAt: now,
+	}
+}
+
+// State returns the state of the archived game. The board is rebuilt by
+// replaying the moves of the game.
+func (a ArchivedGame) State() (GameState, error) {
+	state := GameState{
+		GameID:     a.GameID,
+		InviteCode: a.InviteCode,
+		BeganAt:    a.BeganAt,
+		PlayerA:    a.PlayerA,
+		PlayerB:    a.PlayerB,
+		EndedAt:    a.EndedAt,
+		Winner:     a.Winner,
+		Moves:      a.Moves,
+		Chat:       a.Chat,
+		Phase:      a.Phase,
+		RematchID:  a.RematchID,
+		Ratings:    a.Ratings,
+		Metadata:   a.Metadata,
+		CreatedAt:  a.CreatedAt,
+		SnapshotAt: a.ArchivedAt,
+	}
+
+	ply, err := plyState(state, len(state.Moves))
+	if err != nil {
+		return GameState{}, err
+	}
+	state.Board = ply.Board
+
+	return state, nil
+}
+
+// playerSide is like gameInstance.playerSide. The sessions of the players
+// are not archived, so only registered players are recognized.
+func (a ArchivedGame) playerSide(authorization user.Authorization) scouts.Player {
+	if authorization.UserID == nil {
+		return scouts.PlayerNone
+	}
+	switch {
+	case a.PlayerA != nil && a.PlayerA.UserID != nil && *a.PlayerA.UserID == *authorization.UserID:
+		return scouts.PlayerA
+	case a.PlayerB != nil && a.PlayerB.UserID != nil && *a.PlayerB.UserID == *authorization.UserID:
+		return scouts.PlayerB
+	default:
+		return scouts.PlayerNone
+	}
+}
+
+// subscribe returns a subscription that replays the archived game and the
+// chat of the user's room, then goes away since the game is no longer
+// served.
+func (a ArchivedGame) subscribe(authorization user.Authorization) (*GameSubscription, error) {
+	state, err := a.State()
+	if err != nil {
+		return nil, err
+	}
+
+	events := playbackGameEvents(state, a.ArchivedAt)
+	events = append(events, playbackChatEvents(state, chatRoomFor(a.playerSide(authorization)))...)
+	events = append(events, goingAwayEvent(GoingAwayGameEnded))
+
+	return &GameSubscription{
+		stream: &archiveStream{events: events},
+		close:  func() {},
+	}, nil
+}
+
+// archiveStream is the stream of events of an archived game.
+type archiveStream struct {
+	events []GameEvent
+}
+
+func (s *archiveStream) next(ctx context.Context) (GameEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.events) == 0 {
+		return nil, io.EOF
+	}
+	ev := s.events[0]
+	s.events = s.events[1:]
+	return ev, nil
+}
+
+func (s *archiveStream) cancel() {}
+
+func (s *archiveStream) release() {}
+
+// canAccess is like gameInstance.canAccess. The sessions of the players are
+// not archived, so only registered players are recognized.
+func (a ArchivedGame) canAccess(authorization user.Authorization, code InviteCode) bool {
+	if !a.Metadata.Private {
+		return true
+	}
+	if code != "" && code == a.InviteCode {
+		return true
+	}
+	if authorization.UserID == nil {
+		return false
+	}
+	for _, player := range [...]*user.Authorization{a.PlayerA, a.PlayerB} {
+		if player != nil && player.UserID != nil && *player.UserID == *authorization.UserID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/scouts"
+)
+
+func TestArchivedGame(t *testing.T) {
+	g := newTestingGameInstance(t, CreateGameOptions{
+		TimeLimit: Duration(time.Minute),
+		Private:   true,
+	})
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+	g.turn(t, g.User1, "place_scout 0,9")
+	g.turn(t, g.User2, "place_scout 0,0")
+	g.do(func() { g.endGame(g.clock.Now(), scouts.PlayerB) })
+
+	state := g.StateSnapshot()
+	archived := archiveGame(state, state.SnapshotAt)
+
+	b, err := json.Marshal(archived)
+	assert.NoError(t, err)
+
+	var unmarshaled ArchivedGame
+	assert.NoError(t, json.Unmarshal(b, &unmarshaled))
+
+	restored, err := unmarshaled.State()
+	assert.NoError(t, err)
+	assert.Equal(t, len(state.Moves), len(restored.Moves))
+	for i, move := range restored.Moves {
+		assert.Equal(t, state.Moves[i].Move, move.Move)
+		assert.True(t, state.Moves[i].Time.Equal(move.Time))
+	}
+	assert.Equal(t, state.Board, restored.Board, "board should be rebuilt from the moves")
+	assert.Equal(t, state.Phase, restored.Phase)
+	assert.Equal(t, scouts.PlayerB, restored.Winner)
+	assert.Equal(t, state.EndedAt.Unix(), restored.EndedAt.Unix())
+
+	assert.True(t, unmarshaled.canAccess(g.User2, ""), "players should be able to access")
+	assert.False(t, unmarshaled.canAccess(user.NewAnonymous(g.User2.Session()), ""), "anonymous players are not recognized")
+}
+
+func TestArchivedGameChat(t *testing.T) {
+	g := newTestingGameInstance(t, CreateGameOptions{})
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	spectator := user.NewAnonymous(user.GenerateSessionToken())
+	assert.NoError(t, g.SendChat(g.User1, "good luck"))
+	assert.NoError(t, g.SendChat(spectator, "who's winning?"))
+
+	g.turn(t, g.User1, "place_scout 0,9")
+	g.do(func() { g.endGame(g.clock.Now(), scouts.PlayerA) })
+
+	state := g.StateSnapshot()
+	b, err := json.Marshal(archiveGame(state, state.SnapshotAt))
+	assert.NoError(t, err)
+
+	var archived ArchivedGame
+	assert.NoError(t, json.Unmarshal(b, &archived))
+	assert.Equal(t, 2, len(archived.Chat), "chat of both rooms should be archived")
+
+	restored, err := archived.State()
+	assert.NoError(t, err)
+
+	for _, test := range []struct {
+		name string
+		user user.Authorization
+		room ChatRoom
+	}{
+		{"player", g.User2, ChatRoomPlayers},
+		{"spectator", spectator, ChatRoomSpectators},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sub, err := archived.subscribe(test.user)
+			assert.NoError(t, err)
+			defer sub.Close()
+
+			events := playbackGameEvents(restored, time.Time{})
+			events = append(events, playbackChatEvents(restored, test.room)...)
+			events = append(events, goingAwayEvent(GoingAwayGameEnded))
+			assert.Equal(t, 1, len(playbackChatEvents(restored, test.room)))
+
+			expectEvents(t, sub, events)
+			assertSubscriptionClosed(t, sub)
+		})
+	}
+}
+
+// memoryGameArchive is a GameArchive that keeps games in memory. Games are
+// stored as JSON, like the storage package does.
+type memoryGameArchive struct {
+	mu    sync.Mutex
+	games map[GameID][]byte
+}
+
+func newMemoryGameArchive() *memoryGameArchive {
+	return &memoryGameArchive{games: make(map[GameID][]byte)}
+}
+
+func (a *memoryGameArchive) ArchiveGame(game ArchivedGame) error {
+	b, err := json.Marshal(game)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.games[game.GameID] = b
+	return nil
+}
+
+func (a *memoryGameArchive) QueryArchivedGame(id GameID) (ArchivedGame, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	b, ok := a.games[id]
+	if !ok {
+		return ArchivedGame{}, ErrNotFound
+	}
+
+	var game ArchivedGame
+	err := json.Unmarshal(b, &game)
+	return game, err
+}
@@ -0,0 +1,194 @@
+package gameserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/scouts"
+)
+
+// ErrInvalidChatMessage is an error that is returned when a chat message is
+// empty or too long.
+var ErrInvalidChatMessage = hrt.NewHTTPError(400, "invalid chat message")
+
+// ErrChatRateLimited is an error that is returned when a session sends chat
+// messages too quickly.
+var ErrChatRateLimited = hrt.NewHTTPError(429, "sending chat messages too quickly")
+
+// MaxChatMessageLength is the maximum number of characters in a chat message.
+const MaxChatMessageLength = 280
+
+const (
+	// chatRateLimit is the number of chat messages that a session can send
+	// within chatRateWindow.
+	chatRateLimit  = 5
+	chatRateWindow = 10 * time.Second
+)
+
+// ChatRoom is a chat room within a game.
+type ChatRoom string
+
+const (
+	// ChatRoomPlayers is the chat room of the players. Spectators cannot see
+	// it.
+	ChatRoomPlayers ChatRoom = "players"
+	// ChatRoomSpectators is the chat room of the spectators. Players cannot
+	// see it, so that spectators cannot help them.
+	ChatRoomSpectators ChatRoom = "spectators"
+)
+
+// chatRoomFor returns the chat room of the given side.
+func chatRoomFor(player scouts.Player) ChatRoom {
+	if player == scouts.PlayerNone {
+		return ChatRoomSpectators
+	}
+	return ChatRoomPlayers
+}
+
+// ChatMessage is a chat message sent within a game.
+type ChatMessage struct {
+	// Room is the chat room that the message was sent to.
+	Room ChatRoom `json:"room"`
+	// PlayerSide is the side of the sender.
+	// If PlayerNone, then the sender is a spectator.
+	PlayerSide scouts.Player `json:"player_side"`
+	// UserID is the ID of the sender.
+	// If this is nil, then the sender is anonymous.
+	UserID *user.UserID `json:"user_id"`
+	// Text is the text of the message.
+	Text string `json:"text"`
+	// Time is the time that the message was sent.
+	Time time.Time `json:"time"`
+}
+
+// ChatFilter filters chat messages before they are sent, e.g. to censor
+// profanity or to drop spam.
+type ChatFilter interface {
+	// FilterChat returns the message to send in place of msg. If an error is
+	// returned, then the message is dropped and the error is returned to the
+	// sender.
+	FilterChat(msg ChatMessage) (ChatMessage, error)
+}
+
+// ChatFilterFunc is a function that implements ChatFilter.
+type ChatFilterFunc func(msg ChatMessage) (ChatMessage, error)
+
+// FilterChat implements ChatFilter.
+func (f ChatFilterFunc) FilterChat(msg ChatMessage) (ChatMessage, error) {
+	return f(msg)
+}
+
+// chatRateLimiter limits how often each session can send chat messages.
+type chatRateLimiter struct {
+	sent map[user.SessionToken][]time.Time
+}
+
+// allow records a message sent by the session at now. False is returned if
+// the session has sent too many messages recently, in which case the message
+// is not recorded.
+func (l *chatRateLimiter) allow(session user.SessionToken, now time.Time) bool {
+	if l.sent == nil {
+		l.sent = make(map[user.SessionToken][]time.Time)
+	}
+
+	// Forget the sessions that haven't sent anything within the window, so
+	// that every session that has ever chatted isn't remembered forever.
+	for s, sent := range l.sent {
+		if now.Sub(sent[len(sent)-1]) >= chatRateWindow {
+			delete(l.sent, s)
+		}
+	}
+
+	sent := l.sent[session]
+	for len(sent) > 0 && now.Sub(sent[0]) >= chatRateWindow {
+		sent = sent[1:]
+	}
+
+	if len(sent) >= chatRateLimit {
+		l.sent[session] = sent
+		return false
+	}
+
+	l.sent[session] = append(sent, now)
+	return true
+}
+
+// SendChat sends a chat message from the user. Players chat in the players'
+// room, while everyone else chats in the spectators' room.
+func (g *gameInstance) SendChat(authorization user.Authorization, text string) error {
+	now := g.clock.Now()
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("%w: message is empty", ErrInvalidChatMessage)
+	}
+	if utf8.RuneCountInString(text) > MaxChatMessageLength {
+		return fmt.Errorf("%w: message is longer than %d characters", ErrInvalidChatMessage, MaxChatMessageLength)
+	}
+
+	var player scouts.Player
+	if err := g.doErr(func() error {
+		player = g.playerSide(authorization)
+		if !g.chatLimiter.allow(authorization.Session(), now) {
+			return ErrChatRateLimited
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	msg := ChatMessage{
+		Room:       chatRoomFor(player),
+		PlayerSide: player,
+		UserID:     authorization.UserID,
+		Text:       text,
+		Time:       now,
+	}
+
+	// Filters may be slow, so don't hold up the game while filtering.
+	if g.chatFilter != nil {
+		var err error
+		msg, err = g.chatFilter.FilterChat(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return g.doErr(func() error {
+		g.state.Chat = append(g.state.Chat, msg)
+		g.sendEvent(chatMessageEvent(msg))
+		return nil
+	})
+}
+
+func chatMessageEvent(msg ChatMessage) ChatMessageEvent {
+	return ChatMessageEvent{
+		EventHeader: EventHeader{ServerTime: msg.Time},
+		ChatMessage: msg,
+	}
+}
+
+// playbackChatEvents returns the events that replay the chat of the given
+// room.
+func playbackChatEvents(state GameState, room ChatRoom) []GameEvent {
+	var events []GameEvent
+	for _, msg := range state.Chat {
+		if msg.Room == room {
+			events = append(events, chatMessageEvent(msg))
+		}
+	}
+	return events
+}
+
+// chatEventFilter returns a filter that drops chat messages of rooms other
+// than the given room.
+func chatEventFilter(room ChatRoom) func(GameEvent) bool {
+	return func(ev GameEvent) bool {
+		msg, ok := ev.(ChatMessageEvent)
+		return !ok || msg.Room == room
+	}
+}
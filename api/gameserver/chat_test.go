@@ -0,0 +1,133 @@
+package gameserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/scouts"
+)
+
+func TestGameInstanceChat(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	g := &testingGameInstance{
+		gameInstance: newGameInstance(CreateGameOptions{}, slogt.New(t), clk),
+		User1:        user.NewAuthorized(user.GenerateSessionToken(), 1),
+		User2:        user.NewAuthorized(user.GenerateSessionToken(), 2),
+	}
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	spectator := user.NewAnonymous(user.GenerateSessionToken())
+
+	playerEvents, _ := g.subscribe(t, g.User1)
+	playerPlayback := playbackEvents(g)
+
+	spectatorEvents, _ := g.subscribe(t, spectator)
+	spectatorPlayback := playbackEvents(g)
+
+	assert.NoError(t, g.SendChat(g.User2, "  good luck  "))
+	assert.NoError(t, g.SendChat(spectator, "who's winning?"))
+
+	playerMsg := ChatMessage{
+		Room:       ChatRoomPlayers,
+		PlayerSide: scouts.PlayerB,
+		UserID:     g.User2.UserID,
+		Text:       "good luck",
+		Time:       clk.Now(),
+	}
+	spectatorMsg := ChatMessage{
+		Room:       ChatRoomSpectators,
+		PlayerSide: scouts.PlayerNone,
+		Text:       "who's winning?",
+		Time:       clk.Now(),
+	}
+
+	expectEvents(t, playerEvents, append(playerPlayback,
+		ChatMessageEvent{ChatMessage: playerMsg},
+	))
+	expectEvents(t, spectatorEvents, append(spectatorPlayback,
+		ChatMessageEvent{ChatMessage: spectatorMsg},
+	))
+
+	assert.Equal(t, []ChatMessage{playerMsg, spectatorMsg}, g.StateSnapshot().Chat)
+
+	t.Run("playback", func(t *testing.T) {
+		events, _ := g.subscribe(t, user.NewAnonymous(user.GenerateSessionToken()))
+		expectEvents(t, events, append(playbackEvents(g),
+			ChatMessageEvent{ChatMessage: spectatorMsg},
+		))
+	})
+}
+
+func TestGameInstanceChatLimits(t *testing.T) {
+	g := newTestingGameInstance(t, CreateGameOptions{})
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	err := g.SendChat(g.User1, "   ")
+	assert.IsError(t, err, ErrInvalidChatMessage, "empty messages should be rejected")
+
+	err = g.SendChat(g.User1, strings.Repeat("é", MaxChatMessageLength+1))
+	assert.IsError(t, err, ErrInvalidChatMessage, "long messages should be rejected")
+
+	assert.NoError(t, g.SendChat(g.User1, strings.Repeat("é", MaxChatMessageLength)))
+	for i := 1; i < chatRateLimit; i++ {
+		assert.NoError(t, g.SendChat(g.User1, "spam"))
+	}
+
+	err = g.SendChat(g.User1, "spam")
+	assert.IsError(t, err, ErrChatRateLimited, "sessions should be rate limited")
+	assert.NoError(t, g.SendChat(g.User2, "hello"), "rate limits should be per session")
+}
+
+func TestChatRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	session1 := user.GenerateSessionToken()
+	session2 := user.GenerateSessionToken()
+
+	var l chatRateLimiter
+	for i := 0; i < chatRateLimit; i++ {
+		assert.True(t, l.allow(session1, now))
+	}
+	assert.False(t, l.allow(session1, now))
+
+	now = now.Add(chatRateWindow)
+	assert.True(t, l.allow(session2, now))
+	assert.Equal(t, 1, len(l.sent), "idle sessions should be forgotten")
+
+	assert.True(t, l.allow(session1, now), "limit should reset after the window")
+}
+
+func TestGameInstanceChatFilter(t *testing.T) {
+	errSpam := errors.New("spam")
+
+	g := newTestingGameInstance(t, CreateGameOptions{})
+	g.chatFilter = ChatFilterFunc(func(msg ChatMessage) (ChatMessage, error) {
+		if strings.Contains(msg.Text, "http://") {
+			return msg, errSpam
+		}
+		msg.Text = strings.ReplaceAll(msg.Text, "darn", "****")
+		return msg, nil
+	})
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+
+	assert.IsError(t, g.SendChat(g.User1, "visit http://example.com"), errSpam)
+	assert.NoError(t, g.SendChat(g.User1, "darn it"))
+
+	chat := g.StateSnapshot().Chat
+	assert.Equal(t, 1, len(chat))
+	assert.Equal(t, "**** it", chat[0].Text)
+}
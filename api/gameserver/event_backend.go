@@ -0,0 +1,330 @@
+package gameserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/internal/pubsub"
+)
+
+// DefaultEventLogCapacity is the number of events kept in the event log of a
+// game if EventLogOptions.Capacity is zero.
+const DefaultEventLogCapacity = 1024
+
+// EventLogOptions configures games to deliver their events through a single
+// event log of bounded size that all subscribers share, instead of a queue per
+// subscriber. This bounds the memory used by games with many spectators.
+type EventLogOptions struct {
+	// Capacity is the number of latest events kept in the log of each game.
+	// If zero, then DefaultEventLogCapacity is used.
+	Capacity int
+	// SlowReaders decides what happens to subscribers that fall more than
+	// Capacity events behind. By default, they are disconnected.
+	SlowReaders pubsub.SlowReaderPolicy
+}
+
+// BackpressureOptions limits how far each subscriber of a game can fall
+// behind when subscribers have their own queues. It does not apply to games
+// that use an event log, which has its own SlowReaderPolicy.
+type BackpressureOptions struct {
+	// MaxQueuedEvents is the maximum number of events queued for a
+	// subscriber. If zero, then there is no limit.
+	MaxQueuedEvents int
+	// MaxLag is the maximum time that an event can be queued for a
+	// subscriber. If zero, then there is no limit.
+	MaxLag time.Duration
+	// Action is the action taken once a subscriber goes over either limit:
+	//
+	//   - pubsub.BackpressureBlock holds up the events of the game until the
+	//     subscriber catches up, which also holds up all other subscribers.
+	//   - pubsub.BackpressureDrop disconnects the subscriber with a
+	//     GoingAwayEvent.
+	//   - pubsub.BackpressureCoalesce replaces the queued events with a
+	//     ResyncEvent followed by the events that replay the game.
+	Action pubsub.Backpressure
+}
+
+// GameSubscription is a subscription to the events of a game.
+type GameSubscription struct {
+	stream eventStream
+	close  func()
+	once   sync.Once
+}
+
+// Next returns the next event of the game, waiting until there is one or ctx
+// is done. io.EOF is returned once the game has gone away and all of its
+// events have been read. A subscriber that falls too far behind receives a
+// GoingAwayEvent asking it to reconnect; if the game uses an event log,
+// pubsub.ErrLagged is returned after that.
+func (s *GameSubscription) Next(ctx context.Context) (GameEvent, error) {
+	return s.stream.next(ctx)
+}
+
+// Close unsubscribes from the game.
+func (s *GameSubscription) Close() {
+	s.once.Do(s.close)
+}
+
+// eventBackend delivers the events of a game to its subscribers. Its methods
+// are only called on the game's goroutine, so they must not block on
+// subscribers.
+type eventBackend interface {
+	// publish sends the events to all subscribers.
+	publish(events []GameEvent)
+	// subscribe adds a subscriber that first receives the events returned by
+	// playback and then the published events for which filter returns true.
+	// playback is called on the game's goroutine, and may be called again if
+	// the subscriber has to catch up.
+	subscribe(playback func() []GameEvent, filter func(GameEvent) bool) eventStream
+	// unsubscribe removes the subscriber and frees its stream.
+	unsubscribe(stream eventStream)
+	// close ends the streams of all subscribers after the events published so
+	// far. It waits for the goroutines of the backend to finish.
+	close()
+}
+
+// eventStream is the stream of events of a single subscriber.
+type eventStream interface {
+	next(ctx context.Context) (GameEvent, error)
+	// cancel stops sending events to the stream right away. Unlike the other
+	// methods, it may be called on any goroutine.
+	cancel()
+	// release frees the stream after the backend has been closed.
+	release()
+}
+
+// newEventBackend creates the event backend of the game. If the game has no
+// event log options, then each subscriber gets its own queue.
+func newEventBackend(g *gameInstance) eventBackend {
+	if g.eventLog == nil {
+		return newQueueBackend(g.logger, g.clock, g.limits, g.metrics.eventMetrics(), g.do)
+	}
+	return newLogBackend(*g.eventLog, g.clock, g.metrics.eventMetrics())
+}
+
+// queueBackend gives each subscriber its own queue, which is unbounded unless
+// the game has backpressure limits. Events are published by a goroutine of its
+// own, so that a slow subscriber cannot hold up the game.
+type queueBackend struct {
+	logger *slog.Logger
+	clock  clock.Clock
+	limits pubsub.Limits
+	// do runs a function on the game's goroutine, like gameInstance.do.
+	do     func(func()) bool
+	events *pubsub.Publisher[GameEvent]
+	// outbox receives the functions to run on the publishing goroutine.
+	outbox *pubsub.ConcurrentQueue[func()]
+	done   chan struct{}
+}
+
+func newQueueBackend(logger *slog.Logger, clock clock.Clock, limits pubsub.Limits, metrics *pubsub.Metrics, do func(func()) bool) *queueBackend {
+	b := &queueBackend{
+		logger: logger,
+		clock:  clock,
+		limits: limits,
+		do:     do,
+		events: pubsub.NewPublisher[GameEvent](),
+		outbox: pubsub.NewConcurrentQueue[func()](clock),
+		done:   make(chan struct{}),
+	}
+
+	b.events.Instrument(metrics)
+	b.outbox.Start()
+	go b.run()
+
+	return b
+}
+
+// run is the publishing goroutine. It runs the posted functions in order until
+// the backend is closed.
+func (b *queueBackend) run() {
+	defer close(b.done)
+
+	for fn := range b.outbox.Out() {
+		fn()
+	}
+}
+
+// post runs fn on the publishing goroutine after all previously posted
+// functions.
+func (b *queueBackend) post(fn func()) {
+	b.outbox.In() <- fn
+}
+
+func (b *queueBackend) publish(events []GameEvent) {
+	b.post(func() { b.events.Publish(events...) })
+}
+
+func (b *queueBackend) subscribe(playback func() []GameEvent, filter func(GameEvent) bool) eventStream {
+	s := &queueStream{
+		backend:  b,
+		queue:    pubsub.NewConcurrentQueue[GameEvent](b.clock),
+		playback: playback,
+		filter:   filter,
+	}
+	s.queue.Start()
+	b.follow(s)
+	return s
+}
+
+// follow sends the playback to the stream and subscribes it. It must be called
+// on the game's goroutine.
+func (b *queueBackend) follow(s *queueStream) {
+	playback := s.playback()
+
+	// Subscribe on the publishing goroutine, so that the subscriber receives
+	// exactly the events that are published after the playback.
+	b.post(func() {
+		pubsub.Send(s.queue.In(), playback...)
+		b.events.SubscribeWith(s.queue, pubsub.SubscribeOptions[GameEvent]{
+			Filter:     s.filter,
+			Limits:     b.limits,
+			OnOverflow: b.overflow,
+		})
+	})
+}
+
+// overflow is called on the publishing goroutine once a subscriber has gone
+// over its limits and has been unsubscribed.
+func (b *queueBackend) overflow(queue *pubsub.ConcurrentQueue[GameEvent]) {
+	header := EventHeader{ServerTime: b.clock.Now()}
+
+	b.logger.Debug(
+		"game event subscriber fell behind",
+		"action", b.limits.Backpressure,
+		"queued", queue.Len(),
+		"lag", queue.Lag())
+
+	switch b.limits.Backpressure {
+	case pubsub.BackpressureDrop:
+		ev := goingAwayEvent(GoingAwaySlowSubscriber)
+		ev.EventHeader = header
+		queue.Reset(ev)
+		queue.Close()
+	case pubsub.BackpressureCoalesce:
+		// The stream subscribes again once it reads the ResyncEvent.
+		queue.Reset(ResyncEvent{EventHeader: header})
+	}
+}
+
+func (b *queueBackend) unsubscribe(stream eventStream) {
+	queue := stream.(*queueStream).queue
+	b.post(func() {
+		b.events.Unsubscribe(queue)
+		queue.Stop()
+	})
+}
+
+func (b *queueBackend) close() {
+	b.post(func() {
+		for _, sub := range b.events.Subscribers() {
+			sub.Close()
+			b.events.Unsubscribe(sub)
+			b.logger.Debug(
+				"closed and unsubscribed game event subscriber")
+		}
+	})
+
+	b.outbox.Close()
+	<-b.done
+}
+
+type queueStream struct {
+	backend  *queueBackend
+	queue    *pubsub.ConcurrentQueue[GameEvent]
+	playback func() []GameEvent
+	filter   func(GameEvent) bool
+}
+
+func (s *queueStream) next(ctx context.Context) (GameEvent, error) {
+	select {
+	case ev, ok := <-s.queue.Out():
+		if !ok {
+			return nil, io.EOF
+		}
+		if _, ok := ev.(ResyncEvent); ok {
+			// The queued events were coalesced, so catch up by replaying
+			// the game again.
+			if !s.backend.do(func() { s.backend.follow(s) }) {
+				return nil, io.EOF
+			}
+		}
+		return ev, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *queueStream) cancel() {
+	s.backend.events.Unsubscribe(s.queue)
+}
+
+func (s *queueStream) release() {
+	s.queue.Stop()
+}
+
+// logBackend appends events to a log of bounded size that all subscribers
+// follow with their own cursors.
+type logBackend struct {
+	log   *pubsub.Log[GameEvent]
+	clock clock.Clock
+}
+
+func newLogBackend(opts EventLogOptions, clock clock.Clock, metrics *pubsub.Metrics) *logBackend {
+	capacity := opts.Capacity
+	if capacity == 0 {
+		capacity = DefaultEventLogCapacity
+	}
+	log := pubsub.NewLog[GameEvent](capacity, opts.SlowReaders)
+	log.Instrument(metrics)
+	return &logBackend{log: log, clock: clock}
+}
+
+func (b *logBackend) publish(events []GameEvent) {
+	b.log.Append(events...)
+}
+
+func (b *logBackend) subscribe(playback func() []GameEvent, filter func(GameEvent) bool) eventStream {
+	return &logStream{
+		reader: b.log.Follow(playback(), filter),
+		clock:  b.clock,
+	}
+}
+
+func (b *logBackend) unsubscribe(stream eventStream) {}
+
+func (b *logBackend) close() {
+	b.log.Close()
+}
+
+type logStream struct {
+	reader *pubsub.LogReader[GameEvent]
+	clock  clock.Clock
+	lagged bool
+}
+
+func (s *logStream) next(ctx context.Context) (GameEvent, error) {
+	if s.lagged {
+		return nil, pubsub.ErrLagged
+	}
+
+	ev, err := s.reader.Next(ctx)
+	if errors.Is(err, pubsub.ErrLagged) {
+		// Tell the client to reconnect, the same way the queue backend does
+		// when it drops a slow subscriber.
+		s.lagged = true
+		goingAway := goingAwayEvent(GoingAwaySlowSubscriber)
+		goingAway.EventHeader = EventHeader{ServerTime: s.clock.Now()}
+		return goingAway, nil
+	}
+	return ev, err
+}
+
+func (s *logStream) cancel() {}
+
+func (s *logStream) release() {}
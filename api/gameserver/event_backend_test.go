@@ -0,0 +1,146 @@
+package gameserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/internal/pubsub"
+)
+
+func TestGameInstanceBackpressure(t *testing.T) {
+	// Each move sends two events, so the second move puts a subscriber that
+	// isn't reading over the limit.
+	newGame := func(t *testing.T, action pubsub.Backpressure) *testingGameInstance {
+		g := newTestingGameInstance(t, CreateGameOptions{})
+		g.limits = pubsub.Limits{
+			MaxQueued:    2,
+			Backpressure: action,
+			Counters:     new(pubsub.BackpressureCounters),
+		}
+		t.Cleanup(g.stop)
+
+		g.join(t, g.User1)
+		g.join(t, g.User2)
+		return g
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		g := newGame(t, pubsub.BackpressureDrop)
+
+		events, _ := g.subscribe(t, user.NewAnonymous(user.GenerateSessionToken()))
+		expectEvents(t, events, playbackEvents(g))
+
+		g.turn(t, g.User1, "place_scout 0,9")
+		g.turn(t, g.User2, "place_scout 0,0")
+		flushEvents(g)
+
+		expectEvents(t, events, []GameEvent{
+			goingAwayEvent(GoingAwaySlowSubscriber),
+		})
+		assertSubscriptionClosed(t, events)
+		assert.Equal(t, pubsub.BackpressureCounts{Dropped: 1}, g.limits.Counters.Counts())
+	})
+
+	t.Run("coalesce", func(t *testing.T) {
+		g := newGame(t, pubsub.BackpressureCoalesce)
+
+		events, _ := g.subscribe(t, user.NewAnonymous(user.GenerateSessionToken()))
+		expectEvents(t, events, playbackEvents(g))
+
+		g.turn(t, g.User1, "place_scout 0,9")
+		g.turn(t, g.User2, "place_scout 0,0")
+		flushEvents(g)
+
+		expectEvents(t, events, []GameEvent{ResyncEvent{}})
+		expectEvents(t, events, playbackEvents(g))
+
+		g.turn(t, g.User1, "place_scout 1,9")
+
+		ev, err := events.Next(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "move_made", ev.Type(), "subscriber should follow the game again")
+		assert.Equal(t, pubsub.BackpressureCounts{Coalesced: 1}, g.limits.Counters.Counts())
+	})
+
+	t.Run("block", func(t *testing.T) {
+		g := newGame(t, pubsub.BackpressureBlock)
+
+		events, _ := g.subscribe(t, user.NewAnonymous(user.GenerateSessionToken()))
+		expectEvents(t, events, playbackEvents(g))
+
+		// The game must not be held up by the blocked subscriber.
+		g.turn(t, g.User1, "place_scout 0,9")
+		g.turn(t, g.User2, "place_scout 0,0")
+		g.turn(t, g.User1, "place_scout 1,9")
+
+		for g.limits.Counters.Counts().Blocked == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		var moves int
+		for moves < 3 {
+			ev, err := events.Next(context.Background())
+			assert.NoError(t, err)
+			if ev.Type() == "move_made" {
+				moves++
+			}
+		}
+
+		counts := g.limits.Counters.Counts()
+		assert.True(t, counts.Blocked > 0)
+		assert.Equal(t, pubsub.BackpressureCounts{Blocked: counts.Blocked}, counts)
+	})
+}
+
+func TestGameInstanceBackpressureLag(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	g := &testingGameInstance{
+		gameInstance: newGameInstance(CreateGameOptions{}, slogt.New(t), clk),
+		User1:        user.NewAuthorized(user.GenerateSessionToken(), 1),
+		User2:        user.NewAuthorized(user.GenerateSessionToken(), 2),
+	}
+	g.limits = pubsub.Limits{
+		MaxLag:       5 * time.Second,
+		Backpressure: pubsub.BackpressureDrop,
+		Counters:     new(pubsub.BackpressureCounters),
+	}
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	events, _ := g.subscribe(t, user.NewAnonymous(user.GenerateSessionToken()))
+	expectEvents(t, events, playbackEvents(g))
+
+	g.turn(t, g.User1, "place_scout 0,9")
+	flushEvents(g)
+	assert.Equal(t, pubsub.BackpressureCounts{}, g.limits.Counters.Counts(),
+		"subscriber should be within its limits")
+
+	// The subscriber is only over its limits once the events that it hasn't
+	// read have waited for long enough.
+	clk.Advance(5 * time.Second)
+	g.turn(t, g.User2, "place_scout 0,0")
+	flushEvents(g)
+
+	expectEvents(t, events, []GameEvent{
+		goingAwayEvent(GoingAwaySlowSubscriber),
+	})
+	assertSubscriptionClosed(t, events)
+	assert.Equal(t, pubsub.BackpressureCounts{Dropped: 1}, g.limits.Counters.Counts())
+}
+
+// flushEvents waits until the game has published all events sent so far.
+func flushEvents(g *testingGameInstance) {
+	done := make(chan struct{})
+	g.do(func() {
+		g.eventBackend().(*queueBackend).post(func() { close(done) })
+	})
+	<-done
+}
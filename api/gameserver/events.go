@@ -1,6 +1,8 @@
 package gameserver
 
 import (
+	"time"
+
 	"libdb.so/scouts-server/api/user"
 	"libdb.so/scouts-server/scouts"
 )
@@ -10,10 +12,26 @@ import (
 type GameEvent interface {
 	// Type returns the type of the game event.
 	Type() string
+	// Header returns the header of the game event.
+	Header() EventHeader
+	// withHeader returns a copy of the game event with the given header.
+	withHeader(EventHeader) GameEvent
+}
+
+// EventHeader contains the fields that every game event has.
+type EventHeader struct {
+	// ServerTime is the time on the server when the event was emitted. All
+	// remaining times within the event are as of this time.
+	ServerTime time.Time `json:"server_time"`
 }
 
+// Header returns the header itself. It allows game events to implement
+// GameEvent by embedding EventHeader.
+func (h EventHeader) Header() EventHeader { return h }
+
 // PlayerJoinedEvent is an event that is emitted when a player joins the game.
 type PlayerJoinedEvent struct {
+	EventHeader
 	// PlayerSide is the side that the user joined.
 	PlayerSide scouts.Player `json:"player_side"`
 	// UserID is the ID of the user that joined the game.
@@ -23,6 +41,7 @@ type PlayerJoinedEvent struct {
 
 // PlayerLeftEvent is an event that is emitted when a player leaves the game.
 type PlayerLeftEvent struct {
+	EventHeader
 	// PlayerSide is the side that the user left.
 	PlayerSide scouts.Player `json:"player_side"`
 	// UserID is the ID of the user that left the game.
@@ -34,6 +53,7 @@ type PlayerLeftEvent struct {
 // connects to the game. It can only be emitted after a PlayerJoinedEvent
 // but before a PlayerLeftEvent.
 type PlayerConnectedEvent struct {
+	EventHeader
 	// PlayerSide is the side that the user connected.
 	PlayerSide scouts.Player `json:"player_side"`
 }
@@ -44,22 +64,28 @@ type PlayerConnectedEvent struct {
 // still choose to rejoin the game, after which a PlayerConnectedEvent will be
 // emitted again.
 type PlayerDisconnectedEvent struct {
+	EventHeader
 	// PlayerSide is the side that the user disconnected.
 	PlayerSide scouts.Player `json:"player_side"`
 }
 
 // TurnBeginEvent is an event that is emitted when a turn begins.
 type TurnBeginEvent struct {
+	EventHeader
 	// PlayerSide is the side that is about to make a move.
 	PlayerSide scouts.Player `json:"player_side"`
 	// PlaysRemaining is the number of plays remaining for the player.
 	PlaysRemaining int `json:"plays_remaining"`
 	// TimeRemaining is the time remaining for both sides.
 	TimeRemaining [2]Duration `json:"time_remaining"`
+	// Deadline is the time by which the player must finish their turn.
+	// If nil, then the game has no time limit.
+	Deadline *time.Time `json:"deadline"`
 }
 
 // MoveMadeEvent is an event that is emitted when a move is made.
 type MoveMadeEvent struct {
+	EventHeader
 	// Move is the move that was made.
 	Move scouts.Move `json:"move"`
 	// PlayerSide is the side that made the move.
@@ -68,33 +94,179 @@ type MoveMadeEvent struct {
 	PlaysRemaining int `json:"plays_remaining"`
 	// TimeRemaining is the time remaining for both sides.
 	TimeRemaining [2]Duration `json:"time_remaining"`
+	// Changes is the list of pieces that the move added or changed.
+	Changes []PieceChange `json:"changes"`
+	// Deadline is the time by which the side to move must finish their turn.
+	// If nil, then the game has no time limit or has ended.
+	Deadline *time.Time `json:"deadline"`
+}
+
+// PieceState is the state of a single piece on the board.
+type PieceState struct {
+	// Kind is the kind of the piece.
+	Kind scouts.PieceKind `json:"kind"`
+	// Player is the player that owns the piece.
+	Player scouts.Player `json:"player"`
+	// Position is the list of points that the piece occupies. Boulders occupy
+	// four points.
+	Position []scouts.Point `json:"position"`
+	// Returning is true if the piece is a scout that is returning to its
+	// base.
+	Returning bool `json:"returning"`
+}
+
+// PieceChange describes how a single piece changed after a move.
+type PieceChange struct {
+	// Before is the piece before the move.
+	// If nil, then the piece was added by the move.
+	Before *PieceState `json:"before"`
+	// After is the piece after the move.
+	// If nil, then the piece was removed by the move.
+	After *PieceState `json:"after"`
+}
+
+// PhaseChangedEvent is an event that is emitted when the game moves on to a
+// new phase, such as when both players have placed all of their scouts. The
+// end of the game is announced using GameEndEvent instead.
+type PhaseChangedEvent struct {
+	EventHeader
+	// Phase is the new phase of the game.
+	Phase scouts.Phase `json:"phase"`
 }
 
 // GameEndEvent is an event that is emitted when the game ends.
 type GameEndEvent struct {
+	EventHeader
 	// Winner is the side that won the game.
 	Winner scouts.Player `json:"winner"`
 	// TimeRemaining is the time remaining for both sides.
 	TimeRemaining [2]Duration `json:"time_remaining"`
 }
 
+// OfferMadeEvent is an event that is emitted when a player offers a draw or
+// requests a takeback.
+type OfferMadeEvent struct {
+	EventHeader
+	Offer
+}
+
+// OfferAcceptedEvent is an event that is emitted when a player accepts their
+// opponent's offer. It is followed by the events that the offer results in.
+type OfferAcceptedEvent struct {
+	EventHeader
+	Offer
+}
+
+// OfferDeclinedEvent is an event that is emitted when a player declines their
+// opponent's offer.
+type OfferDeclinedEvent struct {
+	EventHeader
+	Offer
+}
+
+// OfferExpiredEvent is an event that is emitted when an offer expires because
+// a move has been made.
+type OfferExpiredEvent struct {
+	EventHeader
+	Offer
+}
+
+// RollbackEvent is an event that is emitted when moves are taken back.
+// Clients should drop all moves after the first MoveCount moves and re-sync
+// their game state. A TurnBeginEvent follows.
+type RollbackEvent struct {
+	EventHeader
+	// MoveCount is the number of moves that remain in the game.
+	MoveCount int `json:"move_count"`
+	// UndoneMoves is the number of moves that were taken back.
+	UndoneMoves int `json:"undone_moves"`
+	// TimeRemaining is the time remaining for both sides.
+	TimeRemaining [2]Duration `json:"time_remaining"`
+}
+
+// RematchOfferedEvent is an event that is emitted when a player offers a
+// rematch after the game has ended.
+type RematchOfferedEvent struct {
+	EventHeader
+	// PlayerSide is the side that offered the rematch.
+	PlayerSide scouts.Player `json:"player_side"`
+}
+
+// RematchAcceptedEvent is an event that is emitted when both players have
+// agreed to a rematch. Both players have already joined the new game with
+// their sides swapped. A GoingAwayEvent follows shortly after.
+type RematchAcceptedEvent struct {
+	EventHeader
+	// GameID is the ID of the new game.
+	GameID GameID `json:"game_id"`
+}
+
+// ChatMessageEvent is an event that is emitted when a chat message is sent.
+// It is only sent to the members of the message's chat room.
+type ChatMessageEvent struct {
+	EventHeader
+	ChatMessage
+}
+
+// PingEvent is an event that is sent to a player's connection to measure its
+// round-trip time. The client should acknowledge it as soon as possible by
+// sending back the ping ID. Unlike other events, it is not broadcast to every
+// subscriber.
+type PingEvent struct {
+	EventHeader
+	// PingID is the ID of the ping.
+	PingID int `json:"ping_id"`
+}
+
 // GoingAwayEvent is an event that is emitted when the server is about to
 // disconnect the client.
 type GoingAwayEvent struct {
-	// Reason string `json:"reason"`
+	EventHeader
+	// Reason is the reason that the client is being disconnected.
+	Reason GoingAwayReason `json:"reason"`
+	// Reconnect is true if the client should subscribe to the game again.
+	Reconnect bool `json:"reconnect"`
 }
 
-// var (
-// 	// GoingAwayGameEnd is an event that is emitted when the server is about to
-// 	// disconnect the client because the game has ended.
-// 	GoingAwayGameEnd = GoingAwayEvent{Reason: "game has ended"}
-// 	// GoingAwayPlayerLeft is an event that is emitted when the server is about
-// 	// to disconnect the client because the player left the game.
-// 	GoingAwayPlayerLeft = GoingAwayEvent{Reason: "player left the game"}
-// 	// GoingAwayGameExpired is an event that is emitted when the server is about
-// 	// to disconnect the client because the game has expired.
-// 	GoingAwayGameExpired = GoingAwayEvent{Reason: "game has expired"}
-// )
+// GoingAwayReason is the reason that the server disconnects a client.
+type GoingAwayReason string
+
+const (
+	// GoingAwayGameEnded is the reason given when the game has ended and is
+	// no longer served, either because it has been replaced by a rematch or
+	// because it has been archived.
+	GoingAwayGameEnded GoingAwayReason = "game_ended"
+	// GoingAwayGameExpired is the reason given when the game has not ended
+	// but has been inactive for too long.
+	GoingAwayGameExpired GoingAwayReason = "game_expired"
+	// GoingAwayGameEvicted is the reason given when an operator has evicted
+	// the game from memory.
+	GoingAwayGameEvicted GoingAwayReason = "game_evicted"
+	// GoingAwayServerShutdown is the reason given when the server is shutting
+	// down. Games are not kept across restarts, so they cannot be resumed,
+	// but the ones that have begun are archived and can still be viewed.
+	GoingAwayServerShutdown GoingAwayReason = "server_shutdown"
+	// GoingAwaySlowSubscriber is the reason given when the client has fallen
+	// too far behind the events of the game. The client can subscribe again
+	// to catch up.
+	GoingAwaySlowSubscriber GoingAwayReason = "slow_subscriber"
+)
+
+// goingAwayEvent returns the GoingAwayEvent for the given reason.
+func goingAwayEvent(reason GoingAwayReason) GoingAwayEvent {
+	return GoingAwayEvent{
+		Reason:    reason,
+		Reconnect: reason == GoingAwaySlowSubscriber,
+	}
+}
+
+// ResyncEvent is an event that is emitted when the client has fallen too far
+// behind and the events that it has missed were dropped. It is followed by the
+// events that replay the game from the start, as if the client had just
+// subscribed, so the client should reset its state.
+type ResyncEvent struct {
+	EventHeader
+}
 
 func (PlayerJoinedEvent) Type() string       { return "player_joined" }
 func (PlayerLeftEvent) Type() string         { return "player_left" }
@@ -102,5 +274,36 @@ func (PlayerConnectedEvent) Type() string    { return "player_connected" }
 func (PlayerDisconnectedEvent) Type() string { return "player_disconnected" }
 func (TurnBeginEvent) Type() string          { return "turn_begin" }
 func (MoveMadeEvent) Type() string           { return "move_made" }
+func (PhaseChangedEvent) Type() string       { return "phase_changed" }
 func (GameEndEvent) Type() string            { return "game_end" }
+func (OfferMadeEvent) Type() string          { return "offer_made" }
+func (OfferAcceptedEvent) Type() string      { return "offer_accepted" }
+func (OfferDeclinedEvent) Type() string      { return "offer_declined" }
+func (OfferExpiredEvent) Type() string       { return "offer_expired" }
+func (RollbackEvent) Type() string           { return "rollback" }
+func (RematchOfferedEvent) Type() string     { return "rematch_offered" }
+func (RematchAcceptedEvent) Type() string    { return "rematch_accepted" }
+func (ChatMessageEvent) Type() string        { return "chat_message" }
+func (PingEvent) Type() string               { return "ping" }
 func (GoingAwayEvent) Type() string          { return "going_away" }
+func (ResyncEvent) Type() string             { return "resync" }
+
+func (e PlayerJoinedEvent) withHeader(h EventHeader) GameEvent       { e.EventHeader = h; return e }
+func (e PlayerLeftEvent) withHeader(h EventHeader) GameEvent         { e.EventHeader = h; return e }
+func (e PlayerConnectedEvent) withHeader(h EventHeader) GameEvent    { e.EventHeader = h; return e }
+func (e PlayerDisconnectedEvent) withHeader(h EventHeader) GameEvent { e.EventHeader = h; return e }
+func (e TurnBeginEvent) withHeader(h EventHeader) GameEvent          { e.EventHeader = h; return e }
+func (e MoveMadeEvent) withHeader(h EventHeader) GameEvent           { e.EventHeader = h; return e }
+func (e PhaseChangedEvent) withHeader(h EventHeader) GameEvent       { e.EventHeader = h; return e }
+func (e GameEndEvent) withHeader(h EventHeader) GameEvent            { e.EventHeader = h; return e }
+func (e OfferMadeEvent) withHeader(h EventHeader) GameEvent          { e.EventHeader = h; return e }
+func (e OfferAcceptedEvent) withHeader(h EventHeader) GameEvent      { e.EventHeader = h; return e }
+func (e OfferDeclinedEvent) withHeader(h EventHeader) GameEvent      { e.EventHeader = h; return e }
+func (e OfferExpiredEvent) withHeader(h EventHeader) GameEvent       { e.EventHeader = h; return e }
+func (e RollbackEvent) withHeader(h EventHeader) GameEvent           { e.EventHeader = h; return e }
+func (e RematchOfferedEvent) withHeader(h EventHeader) GameEvent     { e.EventHeader = h; return e }
+func (e RematchAcceptedEvent) withHeader(h EventHeader) GameEvent    { e.EventHeader = h; return e }
+func (e ChatMessageEvent) withHeader(h EventHeader) GameEvent        { e.EventHeader = h; return e }
+func (e PingEvent) withHeader(h EventHeader) GameEvent               { e.EventHeader = h; return e }
+func (e GoingAwayEvent) withHeader(h EventHeader) GameEvent          { e.EventHeader = h; return e }
+func (e ResyncEvent) withHeader(h EventHeader) GameEvent             { e.EventHeader = h; return e }
@@ -1,54 +1,237 @@
 package gameserver
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"math/rand"
 	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"libdb.so/hrt"
 	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
 	"libdb.so/scouts-server/internal/pubsub"
+	"libdb.so/scouts-server/internal/tracing"
 	"libdb.so/scouts-server/scouts"
 )
 
-// GameSnapshot is a snapshot of a game, which can be used to restore it.
+// ErrGameStopped is an error that is returned when a game can no longer be
+// changed because it has been stopped.
+var ErrGameStopped = hrt.NewHTTPError(410, "game has stopped")
 
+// gameInstance is a single game. A goroutine owns the mutable fields of the
+// game and runs all changes to them one at a time, so that they never need to
+// be locked. Events are delivered to subscribers by an eventBackend, which
+// never blocks the game on a slow subscriber.
 type gameInstance struct {
 	// constant fields
-	game   *scouts.Game
-	logger *slog.Logger
-	events *pubsub.Publisher[GameEvent]
-	clock  customClock
-
-	// mutable, mutex-guarded fields
-	mu     sync.Mutex
-	state  GameState
-	timer  gameTimer
-	stopCh chan struct{}
-	waitg  sync.WaitGroup
+	game    *scouts.Game
+	logger  *slog.Logger
+	clock   clock.Clock
+	ratings RatingStorage
+	// chatFilter filters chat messages. It may be nil.
+	chatFilter ChatFilter
+	// eventLog configures the game to deliver events through an event log.
+	// If nil, then each subscriber gets its own queue.
+	eventLog *EventLogOptions
+	// limits limits how far each subscriber can fall behind if the game
+	// doesn't use an event log.
+	limits pubsub.Limits
+	// metrics records the metrics of the game. It may be nil.
+	metrics *gameMetrics
+	// observer is told about the events of the game. It may be nil.
+	observer GameObserver
+
+	// creator is the user that created the game, and creatorSide is the side
+	// reserved for them. If creatorSide is PlayerNone, then players take the
+	// first free side.
+	creator     user.Authorization
+	creatorSide scouts.Player
+
+	// inbox receives the functions to run on the game's goroutine.
+	inbox    chan func()
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	doneCh   chan struct{}
+	waitg    sync.WaitGroup
+	// stopReason is the reason given to subscribers once stopCh is closed.
+	stopReason GoingAwayReason
+	// subscribers counts the subscriptions that have yet to be closed, and
+	// numSubscribers is their number, which the WaitGroup can't tell.
+	subscribers    sync.WaitGroup
+	numSubscribers atomic.Int32
+
+	// mutable fields owned by the game's goroutine
+	state GameState
+	timer gameTimer
+	// ticker fires when the clock of the side to move should be checked. It
+	// is nil until the game begins.
+	ticker clock.Timer
+	// events delivers the game's events. It is created on first use by
+	// eventBackend.
+	events eventBackend
 
 	playerAConnected bool
 	playerBConnected bool
+
+	// rematching is true while the rematch game is being created.
+	rematching bool
+
+	idempotency idempotencyCache
+
+	// joinRatings are the ratings of both players as they joined, with player
+	// A first. They are nil if the player could not be rated.
+	joinRatings [2]*Rating
+
+	// lag estimates the connection latency of each side, with player A
+	// first.
+	lag [2]lagEstimator
+	// lagCredited is the total lag credit granted to each side, with player
+	// A first. Unlike the credit of the moves, it is kept when moves are
+	// taken back, so that taking back moves doesn't give back their credit.
+	lagCredited [2]Duration
+
+	chatLimiter chatRateLimiter
 }
 
-func newGameInstance(opts CreateGameOptions, logger *slog.Logger, clock customClock) *gameInstance {
-	return &gameInstance{
+// newGameInstance creates a new game. If clk is nil, then the real clock is
+// used.
+func newGameInstance(opts CreateGameOptions, logger *slog.Logger, clk clock.Clock) *gameInstance {
+	clk = clock.Or(clk)
+	g := &gameInstance{
 		game:   scouts.NewGame(),
 		logger: logger.With("component", "api/gameserver/gamemanager.gameInstance"),
-		events: pubsub.NewPublisher[GameEvent](),
-		clock:  clock,
+		clock:  clk,
+		inbox:  make(chan func()),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
 		state: GameState{
-			CreatedAt: clock.Now(),
+			CreatedAt: clk.Now(),
 			Metadata:  opts,
 		},
 	}
+
+	g.waitg.Add(1)
+	go g.run()
+
+	return g
 }
 
+// run is the game's goroutine. It runs until the game is stopped.
+func (g *gameInstance) run() {
+	defer g.waitg.Done()
+	defer close(g.doneCh)
+
+	for {
+		var tick <-chan time.Time
+		if g.ticker != nil {
+			tick = g.ticker.C()
+		}
+
+		select {
+		case fn := <-g.inbox:
+			fn()
+		case <-tick:
+			g.tick()
+		case <-g.stopCh:
+			g.logger.Debug("game stop signal received, going away")
+			if g.ticker != nil {
+				g.ticker.Stop()
+			}
+
+			g.sendEvent(goingAwayEvent(g.stopReason))
+			g.eventBackend().close()
+			return
+		}
+	}
+}
+
+// do runs fn on the game's goroutine and waits for it to return. All access to
+// the mutable fields of the game must happen within fn. False is returned
+// without running fn if the game has stopped.
+func (g *gameInstance) do(fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case g.inbox <- func() { defer close(done); fn() }:
+		<-done
+		return true
+	case <-g.doneCh:
+		return false
+	}
+}
+
+// doErr is like do, except fn returns an error. ErrGameStopped is returned if
+// the game has stopped.
+func (g *gameInstance) doErr(fn func() error) error {
+	var err error
+	if !g.do(func() { err = fn() }) {
+		return ErrGameStopped
+	}
+	return err
+}
+
+// doValue is like doErr, except fn also returns a value.
+func doValue[T any](g *gameInstance, fn func() (T, error)) (T, error) {
+	var v T
+	err := g.doErr(func() error {
+		var err error
+		v, err = fn()
+		return err
+	})
+	return v, err
+}
+
+// view runs fn with read access to the mutable fields of the game. Unlike do,
+// fn is still run once the game has stopped, since nothing can change the game
+// anymore at that point. fn must not change the game.
+func (g *gameInstance) view(fn func()) {
+	if !g.do(fn) {
+		fn()
+	}
+}
+
+// eventBackend returns the backend that delivers the game's events, creating
+// it on first use. It must be called on the game's goroutine.
+func (g *gameInstance) eventBackend() eventBackend {
+	if g.events == nil {
+		g.events = newEventBackend(g)
+	}
+	return g.events
+}
+
+// sendEvent publishes the given events to all subscribers. The events may be
+// delivered asynchronously, but in order. It must be called on the game's
+// goroutine.
 func (g *gameInstance) sendEvent(evs ...GameEvent) {
-	for _, ev := range evs {
-		g.logger.Debug(
+	g.sendEventContext(context.Background(), evs...)
+}
+
+// sendEventContext is like sendEvent, but the publishing of the events is
+// traced within the span of ctx, which is usually that of the move that
+// caused them.
+func (g *gameInstance) sendEventContext(ctx context.Context, evs ...GameEvent) {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		var span trace.Span
+		ctx, span = tracer.Start(ctx, "gameInstance.sendEvent", trace.WithAttributes(
+			tracing.GameIDKey.String(g.state.GameID.String()),
+			attribute.Int("events", len(evs))))
+		defer span.End()
+	}
+
+	evs = slices.Clone(evs)
+
+	now := g.clock.Now()
+	for i, ev := range evs {
+		if ev.Header().ServerTime.IsZero() {
+			evs[i] = ev.withHeader(EventHeader{ServerTime: now})
+		}
+		g.logger.DebugContext(ctx,
 			"sending game event",
 			"event_type", ev.Type(),
 			"event", ev,
@@ -56,23 +239,41 @@ func (g *gameInstance) sendEvent(evs ...GameEvent) {
 			"player_b", user.OptionalAuthorizedUserString(g.state.PlayerB),
 			"moves", len(g.state.Moves))
 	}
-	g.events.Publish(evs...)
-}
 
-// KillIfInactive kills the game if it has been inactive for the given TTL.
-// True is returned if the game was killed.
-func (g *gameInstance) KillIfInactive(ttl time.Duration) bool {
-	g.mu.Lock()
+	g.eventBackend().publish(evs)
 
-	lastActiveAt := g.state.CreatedAt
-	if g.state.BeganAt != nil {
-		lastActiveAt = *g.state.BeganAt
-	}
-	if len(g.state.Moves) > 0 {
-		lastActiveAt = g.state.Moves[len(g.state.Moves)-1].Time
+	if g.observer != nil {
+		g.observer.GameEvents(g.state.GameID, evs, g.stateSnapshot)
 	}
+}
 
-	kill := time.Since(lastActiveAt) > ttl
+// KillIfInactive kills the game if it has been inactive for longer than the
+// retention of its phase. Subscribers are told that the game has ended, or
+// that it has expired if it hasn't ended. True is returned if the game was
+// killed.
+func (g *gameInstance) KillIfInactive(retention GCOptions) bool {
+	var lastActiveAt time.Time
+	var ttl time.Duration
+	reason := GoingAwayGameExpired
+	g.view(func() {
+		switch {
+		case g.state.EndedAt != nil:
+			lastActiveAt = *g.state.EndedAt
+			ttl = retention.Finished
+			reason = GoingAwayGameEnded
+		case g.state.BeganAt != nil:
+			lastActiveAt = *g.state.BeganAt
+			if len(g.state.Moves) > 0 {
+				lastActiveAt = g.state.Moves[len(g.state.Moves)-1].Time
+			}
+			ttl = retention.Running
+		default:
+			lastActiveAt = g.state.CreatedAt
+			ttl = retention.Unstarted
+		}
+	})
+
+	kill := g.clock.Now().Sub(lastActiveAt) > ttl
 
 	g.logger.Debug(
 		"checking game for inactivity",
@@ -80,33 +281,46 @@ func (g *gameInstance) KillIfInactive(ttl time.Duration) bool {
 		"ttl", ttl,
 		"kill", kill)
 
-	g.mu.Unlock()
-
 	if kill {
-		g.Stop()
+		g.Stop(reason)
 		return true
 	}
 
 	return false
 }
 
-func (g *gameInstance) Stop() {
-	g.mu.Lock()
-	if g.stopCh != nil {
+// Stop stops the game. All subscribers are sent a GoingAwayEvent with the
+// given reason and are disconnected. The game can still be viewed afterwards,
+// but it can no longer be changed. Only the reason of the first call is used.
+func (g *gameInstance) Stop(reason GoingAwayReason) {
+	g.stopOnce.Do(func() {
+		g.stopReason = reason
 		close(g.stopCh)
-		g.stopCh = nil
-		g.logger.Debug("game has stopped")
-	} else {
-		g.logger.Debug("game has already stopped")
-	}
-	g.mu.Unlock()
-
+	})
 	g.waitg.Wait()
 	g.logger.Debug("game has stopped and goroutines have finished")
 }
 
+// waitSubscribers waits until all subscriptions to the game have been closed,
+// which happens once their clients have read the GoingAwayEvent of a stopped
+// game.
+func (g *gameInstance) waitSubscribers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.subscribers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (g *gameInstance) startIfReady() {
-	if !g.state.hasBothPlayers() || g.stopCh != nil {
+	if !g.state.hasBothPlayers() || g.ticker != nil {
 		g.logger.Debug(
 			"game is not ready to start",
 			"player_a", user.OptionalAuthorizedUserString(g.state.PlayerA),
@@ -124,223 +338,568 @@ func (g *gameInstance) startIfReady() {
 		// Actually start the game here.
 		now := g.clock.Now()
 		g.state.BeganAt = &now
+		g.metrics.enterPhase(gameUnstarted, gameRunning)
 
 		// Reset the timer as well.
 		g.timer = newGameTimer(now,
 			g.state.Metadata.TimeLimit,
 			g.state.Metadata.Increment)
+
+		g.beginRating()
+
+		if g.observer != nil {
+			g.observer.GameBegan(g.stateSnapshot(), turnBeginEvent(now, g.game, g.timer))
+		}
 	}
 
-	events := playbackGameEvents(g.state)
+	events := playbackGameEvents(g.state, g.clock.Now())
 	g.sendEvent(events...)
 
-	g.stopCh = make(chan struct{})
-	g.waitg.Add(1)
-	go func(stop <-chan struct{}) {
-		defer g.waitg.Done()
-
-		endTimer := time.NewTimer(1 * time.Second)
-		defer endTimer.Stop()
-
-	timerLoop:
-		for {
-			select {
-			case <-stop:
-				g.logger.Debug("game stop signal received, going away")
-				break timerLoop
-
-			case now := <-endTimer.C:
-				g.mu.Lock()
-
-				turn := g.game.CurrentTurn()
-				if !g.timer.Subtract(now, turn.Player) {
-					g.mu.Unlock()
-
-					g.logger.Debug(
-						"player ran out of time",
-						"player", turn.Player)
-					break timerLoop
-				}
+	g.ticker = g.clock.NewTimer(1 * time.Second)
+}
 
-				if minRemaining(g.timer) < Duration(5*time.Second) {
-					// Ramp up the timer to be more precise once we get close to the
-					// end.
-					endTimer.Stop()
-					endTimer.Reset(250 * time.Millisecond)
-				}
+// tick checks whether the side to move has run out of time. It is called on
+// the game's goroutine whenever the ticker fires.
+func (g *gameInstance) tick() {
+	if g.state.EndedAt != nil {
+		// The game has already ended, so there's no need to keep the timer
+		// going.
+		return
+	}
 
-				g.mu.Unlock()
-			}
+	now := g.clock.Now()
+	turn := g.game.CurrentTurn()
+	remaining := g.timer.RemainingAt(now, turn.Player)
+
+	if remaining == 0 {
+		g.timer.Subtract(now, turn.Player)
+		g.logger.Debug(
+			"player ran out of time",
+			"player", turn.Player)
+
+		g.endGame(now, turn.Player.Opponent())
+		g.sendEvent(GameEndEvent{
+			Winner:        turn.Player.Opponent(),
+			TimeRemaining: g.timer.Remaining(),
+		})
+		return
+	}
+
+	if remaining != InfiniteDuration && remaining < Duration(5*time.Second) {
+		// Ramp up the timer to be more precise once we get close to the end.
+		g.ticker.Reset(250 * time.Millisecond)
+	} else {
+		g.ticker.Reset(1 * time.Second)
+	}
+}
+
+// endGame marks the game as ended with the given winner. A winner of
+// PlayerNone means that the game was drawn. The caller is responsible for
+// sending the GameEndEvent.
+func (g *gameInstance) endGame(now time.Time, winner scouts.Player) {
+	g.metrics.enterPhase(g.state.gamePhase(), gameEnded)
+	g.state.EndedAt = &now
+	g.state.Winner = winner
+	g.state.Offers = nil
+	g.finishRating(now, winner)
+}
+
+// MakeMove makes a single move. The move is traced within the span of ctx.
+func (g *gameInstance) MakeMove(ctx context.Context, authorization user.Authorization, move scouts.Move, opts MoveOptions) error {
+	return g.makeMoves(ctx, authorization, scouts.Moves{move}, false, opts)
+}
+
+// MakeTurn makes all the given moves as a single turn. The moves must either
+// complete the player's turn or end the game. Either all moves are made or
+// none are.
+func (g *gameInstance) MakeTurn(ctx context.Context, authorization user.Authorization, moves scouts.Moves, opts MoveOptions) error {
+	if len(moves) == 0 {
+		return fmt.Errorf("%w: no moves given", ErrInvalidMove)
+	}
+	return g.makeMoves(ctx, authorization, moves, true, opts)
+}
+
+func (g *gameInstance) makeMoves(ctx context.Context, authorization user.Authorization, moves scouts.Moves, wholeTurn bool, opts MoveOptions) error {
+	now := g.clock.Now()
+
+	return g.doErr(func() error {
+		if opts.IdempotencyKey == "" {
+			return g.applyMoves(ctx, now, authorization, moves, wholeTurn, opts.MoveIndex)
 		}
 
-		g.mu.Lock()
-		defer g.mu.Unlock()
+		key := idempotencyKey{
+			session: authorization.Session(),
+			key:     opts.IdempotencyKey,
+		}
 
-		now := g.clock.Now()
-		turn := g.game.CurrentTurn()
+		text, _ := moves.MarshalText()
+		request := fmt.Sprintf("%t %s", wholeTurn, text)
 
-		if !g.timer.Subtract(now, turn.Player) {
-			// Someone ran out of time.
-			g.sendEvent(GameEndEvent{
-				Winner:        turn.Player.Opponent(),
-				TimeRemaining: g.timer.Remaining(),
-			})
+		if found, err := g.idempotency.lookup(key, request); found {
+			return err
+		}
+
+		err := g.applyMoves(ctx, now, authorization, moves, wholeTurn, opts.MoveIndex)
+		g.idempotency.store(key, request, err)
+		return err
+	})
+}
+
+// applyMoves makes the given moves. If wholeTurn is true, then the moves are
+// validated against a copy of the game first and must complete the turn. It
+// must be called on the game's goroutine.
+func (g *gameInstance) applyMoves(ctx context.Context, now time.Time, authorization user.Authorization, moves scouts.Moves, wholeTurn bool, moveIndex *int) error {
+	if moveIndex != nil && *moveIndex != len(g.state.Moves) {
+		return fmt.Errorf(
+			"%w: expected %d moves, game has %d",
+			ErrMoveIndexMismatch, *moveIndex, len(g.state.Moves))
+	}
+
+	player, err := g.movingPlayer(authorization)
+	if err != nil {
+		return err
+	}
+
+	if wholeTurn {
+		// Validate the moves against a copy of the game so that we don't end
+		// up with half a turn.
+		game := g.game.Clone()
+		for i, move := range moves {
+			_, span := startApplySpan(ctx, player, move, true)
+			err := game.Apply(player, move)
+			endSpan(span, err)
+			if err != nil {
+				return hrt.WrapHTTPError(http.StatusBadRequest, fmt.Errorf("move %d: %w", i+1, err))
+			}
+			if _, ended := game.Ended(); ended && i != len(moves)-1 {
+				return fmt.Errorf("%w: move %d ends the game", ErrInvalidMove, i+1)
+			}
+		}
+
+		if _, ended := game.Ended(); !ended && game.CurrentTurn().Player == player {
+			return fmt.Errorf("%w: moves do not complete the turn", ErrInvalidMove)
 		}
+	}
 
-		// TODO(diamondburned): figure out how to do this properly.
-		g.sendEvent(GoingAwayEvent{})
+	var events []GameEvent
+	for _, move := range moves {
+		credit := g.lagCredit(now, player)
+		if err := g.subtractTime(now, player, credit); err != nil {
+			return err
+		}
+		g.lagCredited[player-1] += credit
 
-		for _, sub := range g.events.Subscribers() {
-			sub.Close()
-			g.events.Unsubscribe(sub)
-			g.logger.Debug(
-				"closed and unsubscribed game event subscriber")
+		moveEvents, err := makeMoveForEvents(ctx, now, g.game, player, move, g.timer)
+		if err != nil {
+			return err
 		}
-	}(g.stopCh)
+		events = append(events, moveEvents...)
+
+		g.state.Moves = append(g.state.Moves, MoveSnapshot{
+			Player:    player,
+			Move:      move,
+			Time:      now,
+			LagCredit: credit,
+		})
+	}
+
+	g.finishMoves(ctx, now, events)
+	return nil
 }
 
-func (g *gameInstance) MakeMove(authorization user.Authorization, move scouts.Move) error {
-	if g.state.BeganAt == nil {
-		return fmt.Errorf("%w: less than two players in game", ErrInvalidGameState)
+// finishMoves sends the events of the moves that have just been made and ends
+// the game if needed. It must be called on the game's goroutine.
+func (g *gameInstance) finishMoves(ctx context.Context, now time.Time, events []GameEvent) {
+	if winner, ended := g.game.Ended(); ended {
+		g.endGame(now, winner)
 	}
 
-	now := g.clock.Now()
+	g.sendEventContext(ctx, events...)
+	g.expireOffers()
+}
 
-	g.mu.Lock()
-	defer g.mu.Unlock()
+// movingPlayer returns the side of the user if it is their turn to move. It
+// must be called on the game's goroutine.
+func (g *gameInstance) movingPlayer(authorization user.Authorization) (scouts.Player, error) {
+	if g.state.BeganAt == nil {
+		return 0, fmt.Errorf("%w: less than two players in game", ErrInvalidGameState)
+	}
 
-	var player scouts.Player
-	switch {
-	case user.AuthorizationEq(g.state.PlayerA, &authorization):
-		player = scouts.PlayerA
-	case user.AuthorizationEq(g.state.PlayerB, &authorization):
-		player = scouts.PlayerB
-	default:
-		return fmt.Errorf("%w: invalid session token", ErrInvalidMove)
+	if g.state.EndedAt != nil {
+		return 0, fmt.Errorf("%w: game has ended", ErrInvalidGameState)
+	}
+
+	player := g.playerSide(authorization)
+	if player == scouts.PlayerNone {
+		return 0, errNotAPlayer
 	}
 
 	turn := g.game.CurrentTurn()
 	if turn.Player != player {
-		return fmt.Errorf("%w: not your turn", ErrInvalidMove)
+		return 0, errNotYourTurn
 	}
 
-	if !g.timer.Subtract(now, player) {
-		return fmt.Errorf("%w: out of time", ErrInvalidMove)
+	return player, nil
+}
+
+// subtractTime subtracts the time that the player has spent on their move minus
+// the lag credit. If the player has run out of time, then the game ends and an
+// error is returned. It must be called on the game's goroutine.
+func (g *gameInstance) subtractTime(now time.Time, player scouts.Player, credit Duration) error {
+	g.timer.Credit(player, credit)
+	if g.timer.Subtract(now, player) {
+		return nil
 	}
 
-	events, err := makeMoveForEvents(g.game, player, move, g.timer)
-	if err != nil {
+	g.endGame(now, player.Opponent())
+	g.sendEvent(GameEndEvent{
+		Winner:        player.Opponent(),
+		TimeRemaining: g.timer.Remaining(),
+	})
+	return errOutOfTime
+}
+
+// OfferRematch records a rematch offer from the user. True is returned if
+// both players have now offered a rematch, in which case the caller must
+// create the new game and call either finishRematch or cancelRematch.
+func (g *gameInstance) OfferRematch(authorization user.Authorization) (GameState, bool, error) {
+	var state GameState
+	var accepted bool
+	err := g.doErr(func() error {
+		var err error
+		state, accepted, err = g.offerRematch(authorization)
 		return err
+	})
+	return state, accepted, err
+}
+
+func (g *gameInstance) offerRematch(authorization user.Authorization) (GameState, bool, error) {
+	if g.state.EndedAt == nil {
+		return GameState{}, false, fmt.Errorf("%w: game has not ended", ErrInvalidGameState)
+	}
+
+	player := g.playerSide(authorization)
+	if player == scouts.PlayerNone {
+		return GameState{}, false, fmt.Errorf("%w: only players can offer a rematch", ErrNotAllowed)
+	}
+
+	if g.state.RematchID != nil || g.rematching {
+		return g.state, false, nil
+	}
+
+	if !g.state.RematchOffers[player-1] {
+		g.state.RematchOffers[player-1] = true
+		g.sendEvent(RematchOfferedEvent{PlayerSide: player})
+	}
+
+	if g.state.RematchOffers != [2]bool{true, true} {
+		return g.state, false, nil
 	}
 
-	g.state.Moves = append(g.state.Moves, MoveSnapshot{
-		Player: player,
-		Move:   move,
-		Time:   now,
+	g.rematching = true
+	return g.state, true, nil
+}
+
+// finishRematch records the ID of the rematch game and announces it.
+func (g *gameInstance) finishRematch(id GameID) {
+	g.do(func() {
+		g.rematching = false
+		g.state.RematchID = &id
+		g.sendEvent(RematchAcceptedEvent{GameID: id})
 	})
+}
 
-	g.sendEvent(events...)
-	return nil
+// cancelRematch allows the rematch to be retried after the rematch game could
+// not be created.
+func (g *gameInstance) cancelRematch() {
+	g.do(func() { g.rematching = false })
 }
 
 func (g *gameInstance) StateSnapshot() GameState {
-	g.mu.Lock()
-	defer g.mu.Unlock()
+	var s GameState
+	g.view(func() { s = g.stateSnapshot() })
+	return s
+}
 
+func (g *gameInstance) stateSnapshot() GameState {
 	s := g.state
 	s.SnapshotAt = g.clock.Now()
+	s.Phase = g.game.Phase()
+	s.Board = boardPieces(g.game.Board())
+
+	if s.EndedAt == nil {
+		turn := g.game.CurrentTurn()
+		s.Turn = &TurnSnapshot{
+			PlayerSide:     turn.Player,
+			PlaysRemaining: turn.Plays,
+			Moves:          slices.Clone(turn.Moves),
+		}
+		if s.BeganAt != nil {
+			s.Turn.Deadline = turnDeadline(s.SnapshotAt, g.timer, turn.Player)
+		}
+	}
 
 	return s
 }
 
-func (g *gameInstance) PlayerJoin(authorization user.Authorization) error {
-	g.mu.Lock()
-	defer g.mu.Unlock()
+// LegalMoves returns the legal moves for the side to move.
+func (g *gameInstance) LegalMoves() (LegalMoves, error) {
+	var moves LegalMoves
+	var err error
+	g.view(func() {
+		moves, err = g.legalMoves()
+	})
+	return moves, err
+}
 
-	var player scouts.Player
+func (g *gameInstance) legalMoves() (LegalMoves, error) {
+	if g.state.BeganAt == nil {
+		return LegalMoves{}, fmt.Errorf("%w: game has not begun", ErrInvalidGameState)
+	}
 
-	switch {
-	case g.state.PlayerA == nil || user.AuthorizationEq(g.state.PlayerA, &authorization):
-		g.state.PlayerA = &authorization
-		player = scouts.PlayerA
-	case g.state.PlayerB == nil || user.AuthorizationEq(g.state.PlayerB, &authorization):
-		g.state.PlayerB = &authorization
-		player = scouts.PlayerB
-	default:
-		return ErrGameFull
+	if g.state.EndedAt != nil {
+		return LegalMoves{}, fmt.Errorf("%w: game has ended", ErrInvalidGameState)
 	}
 
-	g.sendEvent(PlayerJoinedEvent{
-		PlayerSide: player,
-		UserID:     authorization.UserID,
+	turn := g.game.CurrentTurn()
+	return LegalMoves{
+		PossibleMoves:  g.game.PossibleMoves(turn.Player),
+		PlayerSide:     turn.Player,
+		PlaysRemaining: turn.Plays,
+	}, nil
+}
+
+// boardPieces returns the pieces on the board ordered by their position, top
+// to bottom and left to right.
+func boardPieces(board *scouts.Board) []scouts.Piece {
+	pieces := board.Pieces()
+	slices.SortFunc(pieces, func(a, b scouts.Piece) int {
+		pa := a.Position()[0]
+		pb := b.Position()[0]
+		if pa.Y != pb.Y {
+			return pa.Y - pb.Y
+		}
+		return pa.X - pb.X
 	})
+	return pieces
+}
 
-	g.startIfReady()
-	return nil
+// setInviteCode sets the invite code of the game.
+func (g *gameInstance) setInviteCode(code InviteCode) {
+	g.do(func() { g.state.InviteCode = code })
 }
 
-func (g *gameInstance) SubscribeGame(authorization user.Authorization) (<-chan GameEvent, func(), error) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
+// CanAccess returns true if the user can join or spectate the game. Public
+// games can be accessed by anyone. Private games can only be accessed by the
+// creator, the players and users with the invite code.
+func (g *gameInstance) CanAccess(authorization user.Authorization, code InviteCode) bool {
+	var ok bool
+	g.view(func() { ok = g.canAccess(authorization, code) })
+	return ok
+}
 
-	var player scouts.Player
+func (g *gameInstance) canAccess(authorization user.Authorization, code InviteCode) bool {
+	switch {
+	case !g.state.Metadata.Private:
+		return true
+	case g.creator.Eq(authorization):
+		return true
+	case g.playerSide(authorization) != scouts.PlayerNone:
+		return true
+	default:
+		return code != "" && code == g.state.InviteCode
+	}
+}
+
+// PlayerJoin seats the user in the game and returns the side that they took.
+// If the user has already joined, then their current side is returned.
+func (g *gameInstance) PlayerJoin(authorization user.Authorization, pref SidePreference) (scouts.Player, error) {
+	if err := pref.Validate(); err != nil {
+		return 0, hrt.WrapHTTPError(http.StatusBadRequest, err)
+	}
+
+	rating := g.queryJoinRating(authorization)
+
+	return doValue(g, func() (scouts.Player, error) {
+		if player := g.playerSide(authorization); player != scouts.PlayerNone {
+			if pref != "" && pref != SideRandom && pref.Resolve() != player {
+				return 0, fmt.Errorf("%w: already joined as %v", ErrSideTaken, player)
+			}
+			return player, nil
+		}
+
+		player, err := g.chooseSide(authorization, pref)
+		if err != nil {
+			return 0, err
+		}
+
+		switch player {
+		case scouts.PlayerA:
+			g.state.PlayerA = &authorization
+		case scouts.PlayerB:
+			g.state.PlayerB = &authorization
+		}
+		g.joinRatings[player-1] = rating
+
+		g.sendEvent(PlayerJoinedEvent{
+			PlayerSide: player,
+			UserID:     authorization.UserID,
+		})
+
+		g.startIfReady()
+		return player, nil
+	})
+}
+
+// playerSide returns the side that the user is playing, or PlayerNone if the
+// user is not a player.
+func (g *gameInstance) playerSide(authorization user.Authorization) scouts.Player {
 	switch {
 	case user.AuthorizationEq(g.state.PlayerA, &authorization):
-		player = scouts.PlayerA
-		g.playerAConnected = true
+		return scouts.PlayerA
 	case user.AuthorizationEq(g.state.PlayerB, &authorization):
-		player = scouts.PlayerB
-		g.playerBConnected = true
+		return scouts.PlayerB
+	default:
+		return scouts.PlayerNone
 	}
+}
 
-	if player != scouts.PlayerNone {
-		g.sendEvent(PlayerConnectedEvent{PlayerSide: player})
+// chooseSide chooses the side that a new player takes given their preference.
+func (g *gameInstance) chooseSide(authorization user.Authorization, pref SidePreference) (scouts.Player, error) {
+	var free []scouts.Player
+	if g.state.PlayerA == nil {
+		free = append(free, scouts.PlayerA)
+	}
+	if g.state.PlayerB == nil {
+		free = append(free, scouts.PlayerB)
 	}
 
-	queue := pubsub.NewConcurrentQueue[GameEvent]()
-	queue.Start()
+	switch {
+	case len(free) == 0:
+		return 0, ErrGameFull
+	case pref == SideA || pref == SideB:
+		player := pref.Resolve()
+		if !slices.Contains(free, player) {
+			return 0, fmt.Errorf("%w: side %v is taken", ErrSideTaken, player)
+		}
+		return player, nil
+	case pref == SideRandom:
+		return free[rand.Intn(len(free))], nil
+	case len(free) == 1 || g.creatorSide == scouts.PlayerNone:
+		return free[0], nil
+	case g.creator.Eq(authorization):
+		return g.creatorSide, nil
+	default:
+		// Leave the creator's side for the creator.
+		return g.creatorSide.Opponent(), nil
+	}
+}
+
+// SubscribeGame subscribes the user to the events of the game. The
+// subscription first receives the events that replay the game so far.
+func (g *gameInstance) SubscribeGame(authorization user.Authorization) (*GameSubscription, error) {
+	var player scouts.Player
+	var stream eventStream
+	subscribed := g.do(func() {
+		g.subscribers.Add(1)
+		g.numSubscribers.Add(1)
+		g.metrics.subscribed(1)
+
+		player = g.playerSide(authorization)
+		switch player {
+		case scouts.PlayerA:
+			g.playerAConnected = true
+		case scouts.PlayerB:
+			g.playerBConnected = true
+		}
 
-	in := queue.In()
+		if player != scouts.PlayerNone {
+			g.sendEvent(PlayerConnectedEvent{PlayerSide: player})
+		}
 
-	pubsub.Send(in, playbackPlayerJoinEvents(g)...)
-	pubsub.Send(in, playbackGameEvents(g.state)...)
-	g.events.Subscribe(queue)
+		room := chatRoomFor(player)
 
-	return queue.Out(), func() {
-		// TODO(diamondburned): add explicit player leave
-		// TODO(diamondburned): report when player disconnects
+		stream = g.eventBackend().subscribe(func() []GameEvent {
+			now := g.clock.Now()
+			playback := playbackPlayerJoinEvents(g, now)
+			playback = append(playback, playbackGameEvents(g.state, now)...)
+			playback = append(playback, playbackChatEvents(g.state, room)...)
+			return playback
+		}, chatEventFilter(room))
+	})
+	if !subscribed {
+		return nil, ErrGameStopped
+	}
 
-		if player != 0 {
-			g.mu.Lock()
-			switch player {
-			case scouts.PlayerA:
-				g.playerAConnected = false
-			case scouts.PlayerB:
-				g.playerBConnected = false
+	return &GameSubscription{
+		stream: stream,
+		close: func() {
+			// TODO(diamondburned): add explicit player leave
+			// TODO(diamondburned): report when player disconnects
+
+			// Stop sending events right away, in case the game is held up
+			// waiting for this subscriber.
+			stream.cancel()
+
+			unsubscribed := g.do(func() {
+				if player != scouts.PlayerNone {
+					switch player {
+					case scouts.PlayerA:
+						g.playerAConnected = false
+					case scouts.PlayerB:
+						g.playerBConnected = false
+					}
+					g.sendEvent(PlayerDisconnectedEvent{PlayerSide: player})
+				}
+
+				g.eventBackend().unsubscribe(stream)
+			})
+			if !unsubscribed {
+				// The game has stopped, so the stream can be freed once the
+				// backend is closed.
+				g.waitg.Wait()
+				stream.release()
 			}
-			g.sendEvent(PlayerDisconnectedEvent{PlayerSide: player})
-			g.mu.Unlock()
-		}
 
-		g.events.Unsubscribe(queue)
-		queue.Stop()
+			g.metrics.subscribed(-1)
+			g.numSubscribers.Add(-1)
+			g.subscribers.Done()
+		},
 	}, nil
 }
 
-func turnBeginEvent(game *scouts.Game, timer gameTimer) TurnBeginEvent {
+func turnBeginEvent(now time.Time, game *scouts.Game, timer gameTimer) TurnBeginEvent {
 	turn := game.CurrentTurn()
 	return TurnBeginEvent{
+		EventHeader:    EventHeader{ServerTime: now},
 		PlayerSide:     turn.Player,
 		PlaysRemaining: turn.Plays,
 		TimeRemaining:  timer.Remaining(),
+		Deadline:       turnDeadline(now, timer, turn.Player),
+	}
+}
+
+// turnDeadline returns the time by which the player must finish their turn
+// if their clock started running at now. Nil is returned if the game has no
+// time limit.
+func turnDeadline(now time.Time, timer gameTimer, player scouts.Player) *time.Time {
+	remaining := timer.RemainingAt(now, player)
+	if remaining == InfiniteDuration {
+		return nil
 	}
+	deadline := now.Add(time.Duration(remaining))
+	return &deadline
 }
 
-func makeMoveForEvents(game *scouts.Game, player scouts.Player, move scouts.Move, timer gameTimer) ([]GameEvent, error) {
+func makeMoveForEvents(ctx context.Context, now time.Time, game *scouts.Game, player scouts.Player, move scouts.Move, timer gameTimer) ([]GameEvent, error) {
 	events := make([]GameEvent, 0, 2)
 	last := game.CurrentTurn()
+	lastPhase := game.Phase()
 
-	if err := game.MakeMove(player, move); err != nil {
+	_, span := startApplySpan(ctx, player, move, false)
+	changes, err := game.ApplyDiff(player, move)
+	endSpan(span, err)
+	if err != nil {
 		return nil, hrt.WrapHTTPError(http.StatusBadRequest, err)
 	}
 
@@ -351,15 +910,32 @@ func makeMoveForEvents(game *scouts.Game, player scouts.Player, move scouts.Move
 		playsRemaining = 0
 	}
 
-	events = append(events, MoveMadeEvent{
+	header := EventHeader{ServerTime: now}
+	winner, ended := game.Ended()
+
+	moveMade := MoveMadeEvent{
+		EventHeader:    header,
 		Move:           move,
 		PlayerSide:     player,
 		PlaysRemaining: playsRemaining,
 		TimeRemaining:  timer.Remaining(),
-	})
+		Changes:        pieceChanges(changes),
+	}
+	if !ended {
+		moveMade.Deadline = turnDeadline(now, timer, turn.Player)
+	}
+	events = append(events, moveMade)
 
-	if winner, ended := game.Ended(); ended {
+	if phase := game.Phase(); phase != lastPhase && phase != scouts.PhaseEnded {
+		events = append(events, PhaseChangedEvent{
+			EventHeader: header,
+			Phase:       phase,
+		})
+	}
+
+	if ended {
 		events = append(events, GameEndEvent{
+			EventHeader:   header,
 			Winner:        winner,
 			TimeRemaining: timer.Remaining(),
 		})
@@ -367,45 +943,148 @@ func makeMoveForEvents(game *scouts.Game, player scouts.Player, move scouts.Move
 	}
 
 	if turn.Player != last.Player {
-		events = append(events, TurnBeginEvent{
-			PlayerSide:     turn.Player,
-			PlaysRemaining: turn.Plays,
-			TimeRemaining:  timer.Remaining(),
-		})
+		events = append(events, turnBeginEvent(now, game, timer))
 		return events, nil
 	}
 
 	return events, nil
 }
 
-func playbackPlayerJoinEvents(game *gameInstance) []GameEvent {
+func pieceChanges(changes []scouts.PieceChange) []PieceChange {
+	result := make([]PieceChange, len(changes))
+	for i, change := range changes {
+		result[i] = PieceChange{
+			Before: pieceState(change.Before),
+			After:  pieceState(change.After),
+		}
+	}
+
+	// Order the changes by position so that they're deterministic.
+	slices.SortFunc(result, func(a, b PieceChange) int {
+		pa := a.After
+		if pa == nil {
+			pa = a.Before
+		}
+		pb := b.After
+		if pb == nil {
+			pb = b.Before
+		}
+		if pa.Position[0].Y != pb.Position[0].Y {
+			return pa.Position[0].Y - pb.Position[0].Y
+		}
+		return pa.Position[0].X - pb.Position[0].X
+	})
+
+	return result
+}
+
+func pieceState(piece scouts.Piece) *PieceState {
+	if piece == nil {
+		return nil
+	}
+	state := &PieceState{
+		Kind:     piece.Kind(),
+		Player:   piece.Player(),
+		Position: piece.Position(),
+	}
+	if scout, ok := piece.(*scouts.ScoutPiece); ok {
+		state.Returning = scout.Returning()
+	}
+	return state
+}
+
+func playbackPlayerJoinEvents(game *gameInstance, now time.Time) []GameEvent {
+	header := EventHeader{ServerTime: now}
+
 	var events []GameEvent
 	if game.state.PlayerA != nil {
 		events = append(events, PlayerJoinedEvent{
-			PlayerSide: scouts.PlayerA,
-			UserID:     game.state.PlayerA.UserID,
+			EventHeader: header,
+			PlayerSide:  scouts.PlayerA,
+			UserID:      game.state.PlayerA.UserID,
 		})
 		if game.playerAConnected {
 			events = append(events, PlayerConnectedEvent{
-				PlayerSide: scouts.PlayerA,
+				EventHeader: header,
+				PlayerSide:  scouts.PlayerA,
 			})
 		}
 	}
 	if game.state.PlayerB != nil {
 		events = append(events, PlayerJoinedEvent{
-			PlayerSide: scouts.PlayerB,
-			UserID:     game.state.PlayerB.UserID,
+			EventHeader: header,
+			PlayerSide:  scouts.PlayerB,
+			UserID:      game.state.PlayerB.UserID,
 		})
 		if game.playerBConnected {
 			events = append(events, PlayerConnectedEvent{
-				PlayerSide: scouts.PlayerB,
+				EventHeader: header,
+				PlayerSide:  scouts.PlayerB,
 			})
 		}
 	}
 	return events
 }
 
-func playbackGameEvents(state GameState) []GameEvent {
+// plyState replays the first ply moves of the game to reconstruct its state
+// at that point.
+func plyState(state GameState, ply int) (PlyState, error) {
+	if ply < 0 || ply > len(state.Moves) {
+		return PlyState{}, hrt.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("ply must be between 0 and %d", len(state.Moves)))
+	}
+
+	beganAt := state.CreatedAt
+	if state.BeganAt != nil {
+		beganAt = *state.BeganAt
+	}
+
+	game := scouts.NewGame()
+	timer := newGameTimer(beganAt, state.Metadata.TimeLimit, state.Metadata.Increment)
+
+	at := beganAt
+	for _, move := range state.Moves[:ply] {
+		at = move.Time
+		timer.Credit(move.Player, move.LagCredit)
+		timer.Subtract(move.Time, move.Player)
+		if err := game.MakeMove(move.Player, move.Move); err != nil {
+			return PlyState{}, fmt.Errorf("%w: cannot replay moves: %v", ErrInvalidGameState, err)
+		}
+	}
+
+	s := PlyState{
+		Ply:           ply,
+		Phase:         game.Phase(),
+		Board:         boardPieces(game.Board()),
+		TimeRemaining: timer.Remaining(),
+	}
+
+	if ply > 0 {
+		move := state.Moves[ply-1]
+		s.Move = &move
+	}
+
+	if winner, ended := game.Ended(); ended {
+		s.Winner = winner
+	} else {
+		turn := game.CurrentTurn()
+		s.Turn = &TurnSnapshot{
+			PlayerSide:     turn.Player,
+			PlaysRemaining: turn.Plays,
+			Moves:          turn.Moves,
+		}
+		if state.BeganAt != nil {
+			s.Turn.Deadline = turnDeadline(at, timer, turn.Player)
+		}
+	}
+
+	return s, nil
+}
+
+// playbackGameEvents returns the events that replay the game up to its current
+// state. Events for past moves carry the time that they were made at, while
+// pending offers and rematches are stamped with now.
+func playbackGameEvents(state GameState, now time.Time) []GameEvent {
 	if state.BeganAt == nil {
 		return nil
 	}
@@ -413,12 +1092,49 @@ func playbackGameEvents(state GameState) []GameEvent {
 	game := scouts.NewGame()
 	timer := newGameTimer(*state.BeganAt, state.Metadata.TimeLimit, state.Metadata.Increment)
 
-	events := []GameEvent{turnBeginEvent(game, timer)}
+	events := []GameEvent{turnBeginEvent(*state.BeganAt, game, timer)}
 	for _, move := range state.Moves {
+		timer.Credit(move.Player, move.LagCredit)
 		timer.Subtract(move.Time, move.Player)
-		moveEvents, _ := makeMoveForEvents(game, move.Player, move.Move, timer)
+		moveEvents, _ := makeMoveForEvents(context.Background(), move.Time, game, move.Player, move.Move, timer)
 		events = append(events, moveEvents...)
 	}
 
+	if _, ended := game.Ended(); !ended && state.EndedAt != nil {
+		// The game didn't end on a move, so it must've ended some other way,
+		// e.g. a player running out of time.
+		timer.Subtract(*state.EndedAt, game.CurrentTurn().Player)
+		events = append(events, GameEndEvent{
+			EventHeader:   EventHeader{ServerTime: *state.EndedAt},
+			Winner:        state.Winner,
+			TimeRemaining: timer.Remaining(),
+		})
+	}
+
+	header := EventHeader{ServerTime: now}
+
+	for _, offer := range state.Offers {
+		events = append(events, OfferMadeEvent{
+			EventHeader: header,
+			Offer:       offer,
+		})
+	}
+
+	for i, offered := range state.RematchOffers {
+		if offered {
+			events = append(events, RematchOfferedEvent{
+				EventHeader: header,
+				PlayerSide:  scouts.Player(i + 1),
+			})
+		}
+	}
+
+	if state.RematchID != nil {
+		events = append(events, RematchAcceptedEvent{
+			EventHeader: header,
+			GameID:      *state.RematchID,
+		})
+	}
+
 	return events
 }
@@ -1,14 +1,21 @@
 package gameserver
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
 	"slices"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/alecthomas/assert/v2"
 	"github.com/neilotoole/slogt"
 	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/internal/pubsub"
 	"libdb.so/scouts-server/scouts"
 )
 
@@ -20,11 +27,11 @@ func TestGameInstance(t *testing.T) {
 	}{{
 		name: "initial state testing",
 		replay: func(t *testing.T, game *testingGameInstance) {
-			killed := game.KillIfInactive(time.Hour)
+			killed := game.KillIfInactive(DefaultGCOptions)
 			assert.False(t, killed, "game not expired yet has already been killed")
 
 			// This should not do anything.
-			game.Stop()
+			game.stop()
 
 			state := game.StateSnapshot()
 			assert.Equal(t, state.PlayerA, nil, "player A should be nil")
@@ -32,9 +39,9 @@ func TestGameInstance(t *testing.T) {
 			assert.Equal(t, len(state.Moves), 0, "snapshot should have no moves")
 
 			var err error
-			err = game.MakeMove(game.User1, mustMove("place_scout 0,0"))
+			err = game.MakeMove(context.Background(), game.User1, mustMove("place_scout 0,0"), MoveOptions{})
 			assert.Error(t, err, "player was able to make move before game was ready")
-			err = game.MakeMove(game.User2, mustMove("place_scout 0,0"))
+			err = game.MakeMove(context.Background(), game.User2, mustMove("place_scout 0,0"), MoveOptions{})
 			assert.Error(t, err, "player was able to make move before game was ready")
 		},
 	}, {
@@ -108,6 +115,7 @@ func TestGameInstance(t *testing.T) {
 					PlayerSide:     scouts.Player1,
 					PlaysRemaining: 0,
 					TimeRemaining:  InfiniteDurationPair,
+					Changes:        scoutPlaced(scouts.Player1, 0, 9),
 				},
 				TurnBeginEvent{
 					PlayerSide:     scouts.Player2,
@@ -119,6 +127,7 @@ func TestGameInstance(t *testing.T) {
 					PlayerSide:     scouts.Player2,
 					PlaysRemaining: 0,
 					TimeRemaining:  InfiniteDurationPair,
+					Changes:        scoutPlaced(scouts.Player2, 0, 0),
 				},
 			}
 
@@ -134,7 +143,7 @@ func TestGameInstance(t *testing.T) {
 			ev1, _ := game.subscribe(t, game.User1)
 			ev2, _ := game.subscribe(t, game.User2)
 
-			err := game.MakeMove(game.User1, mustMove("jump 0,0 0,9"))
+			err := game.MakeMove(context.Background(), game.User1, mustMove("jump 0,0 0,9"), MoveOptions{})
 			assert.Error(t, err, "player was able to make illegal move")
 
 			// you should still be able to make a legal move afterwards
@@ -166,6 +175,7 @@ func TestGameInstance(t *testing.T) {
 					PlayerSide:     scouts.Player1,
 					PlaysRemaining: 0,
 					TimeRemaining:  InfiniteDurationPair,
+					Changes:        scoutPlaced(scouts.Player1, 0, 9),
 				},
 				TurnBeginEvent{
 					PlayerSide:     scouts.Player2,
@@ -177,6 +187,7 @@ func TestGameInstance(t *testing.T) {
 					PlayerSide:     scouts.Player2,
 					PlaysRemaining: 0,
 					TimeRemaining:  InfiniteDurationPair,
+					Changes:        scoutPlaced(scouts.Player2, 0, 0),
 				},
 				TurnBeginEvent{
 					PlayerSide:     scouts.Player1,
@@ -228,6 +239,7 @@ func TestGameInstance(t *testing.T) {
 					PlayerSide:     scouts.Player1,
 					PlaysRemaining: 0,
 					TimeRemaining:  InfiniteDurationPair,
+					Changes:        scoutPlaced(scouts.Player1, 0, 9),
 				},
 				TurnBeginEvent{
 					PlayerSide:     scouts.Player2,
@@ -239,6 +251,7 @@ func TestGameInstance(t *testing.T) {
 					PlayerSide:     scouts.Player2,
 					PlaysRemaining: 0,
 					TimeRemaining:  InfiniteDurationPair,
+					Changes:        scoutPlaced(scouts.Player2, 0, 0),
 				},
 				TurnBeginEvent{
 					PlayerSide:     scouts.Player1,
@@ -268,6 +281,532 @@ func TestGameInstance(t *testing.T) {
 	}
 }
 
+func TestGameInstanceSides(t *testing.T) {
+	t.Run("creator side", func(t *testing.T) {
+		g := newTestingGameInstance(t, CreateGameOptions{})
+		g.creator = g.User1
+		g.creatorSide = scouts.PlayerB
+
+		// The creator's side is reserved even if someone else joins first.
+		side, err := g.PlayerJoin(g.User2, "")
+		assert.NoError(t, err)
+		assert.Equal(t, scouts.PlayerA, side)
+
+		side, err = g.PlayerJoin(g.User1, "")
+		assert.NoError(t, err)
+		assert.Equal(t, scouts.PlayerB, side)
+
+		// Joining again returns the same side.
+		side, err = g.PlayerJoin(g.User2, SideRandom)
+		assert.NoError(t, err)
+		assert.Equal(t, scouts.PlayerA, side)
+	})
+
+	t.Run("requested side", func(t *testing.T) {
+		g := newTestingGameInstance(t, CreateGameOptions{})
+
+		side, err := g.PlayerJoin(g.User1, SideB)
+		assert.NoError(t, err)
+		assert.Equal(t, scouts.PlayerB, side)
+
+		_, err = g.PlayerJoin(g.User2, SideB)
+		assert.IsError(t, err, ErrSideTaken)
+
+		side, err = g.PlayerJoin(g.User2, SideRandom)
+		assert.NoError(t, err)
+		assert.Equal(t, scouts.PlayerA, side)
+
+		user3 := user.NewAuthorized(user.GenerateSessionToken(), 3)
+		_, err = g.PlayerJoin(user3, "")
+		assert.IsError(t, err, ErrGameFull)
+	})
+
+	t.Run("invalid side", func(t *testing.T) {
+		g := newTestingGameInstance(t, CreateGameOptions{})
+
+		_, err := g.PlayerJoin(g.User1, "C")
+		assert.Error(t, err)
+	})
+}
+
+func TestGameInstanceMakeTurn(t *testing.T) {
+	g := newTestingGameInstance(t, CreateGameOptions{})
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	for i := 0; i < scouts.PlaceScoutTurns; i++ {
+		g.turn(t, g.User1, fmt.Sprintf("place_scout %d,9", i))
+		g.turn(t, g.User2, fmt.Sprintf("place_scout %d,0", i))
+	}
+
+	g.turn(t, g.User1, "dash 0,9 1,8")
+
+	err := g.MakeTurn(context.Background(), g.User2, mustMoves("dash 4,0 3,1"), MoveOptions{})
+	assert.IsError(t, err, ErrInvalidMove, "moves that don't complete the turn should be rejected")
+
+	err = g.MakeTurn(context.Background(), g.User2, mustMoves("dash 4,0 3,1; dash 4,0 3,1"), MoveOptions{})
+	assert.Error(t, err, "invalid second move should be rejected")
+
+	state := g.StateSnapshot()
+	assert.Equal(t, 2*scouts.PlaceScoutTurns+1, len(state.Moves), "rejected turns should not make any moves")
+
+	g.turn(t, g.User2, "dash 4,0 3,1; skip")
+
+	state = g.StateSnapshot()
+	assert.Equal(t, 2*scouts.PlaceScoutTurns+3, len(state.Moves))
+	assert.Equal(t, scouts.PlayerA, g.game.CurrentTurn().Player)
+}
+
+func TestGameInstanceMoveGuards(t *testing.T) {
+	g := newTestingGameInstance(t, CreateGameOptions{})
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	err := g.MakeMove(context.Background(), g.User1, mustMove("place_scout 0,9"), MoveOptions{MoveIndex: ptr(1)})
+	assert.IsError(t, err, ErrMoveIndexMismatch)
+
+	opts := MoveOptions{MoveIndex: ptr(0), IdempotencyKey: "move-1"}
+
+	err = g.MakeMove(context.Background(), g.User1, mustMove("place_scout 0,9"), opts)
+	assert.NoError(t, err)
+
+	// Retrying the same request returns the original result.
+	err = g.MakeMove(context.Background(), g.User1, mustMove("place_scout 0,9"), opts)
+	assert.NoError(t, err, "retried move should succeed")
+	assert.Equal(t, 1, len(g.StateSnapshot().Moves), "retried move should not be made twice")
+
+	err = g.MakeMove(context.Background(), g.User1, mustMove("place_scout 1,9"), opts)
+	assert.IsError(t, err, ErrIdempotencyKeyReused)
+
+	// Keys are scoped to the session.
+	err = g.MakeMove(context.Background(), g.User2, mustMove("place_scout 0,0"), opts)
+	assert.IsError(t, err, ErrMoveIndexMismatch)
+
+	// Errors that go away once the game moves on are not remembered.
+	opts = MoveOptions{IdempotencyKey: "move-2"}
+
+	err = g.MakeMove(context.Background(), g.User1, mustMove("place_scout 1,9"), opts)
+	assert.IsError(t, err, errNotYourTurn)
+
+	g.move(t, g.User2, mustMove("place_scout 0,0"))
+
+	err = g.MakeMove(context.Background(), g.User1, mustMove("place_scout 1,9"), opts)
+	assert.NoError(t, err, "retried move should be made once it is the player's turn")
+	assert.Equal(t, 3, len(g.StateSnapshot().Moves))
+}
+
+func TestGameInstanceLegalMoves(t *testing.T) {
+	g := newTestingGameInstance(t, CreateGameOptions{})
+	t.Cleanup(g.stop)
+
+	_, err := g.LegalMoves()
+	assert.IsError(t, err, ErrInvalidGameState, "game has not begun yet")
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	moves, err := g.LegalMoves()
+	assert.NoError(t, err)
+	assert.Equal(t, scouts.PlayerA, moves.PlayerSide)
+	assert.Equal(t, scouts.BoardBounds.Dx(), len(moves.Moves), "player A can place a scout anywhere on their base")
+
+	for i := 0; i < scouts.PlaceScoutTurns; i++ {
+		g.move(t, g.User1, mustMove(fmt.Sprintf("place_scout %d,9", i)))
+		g.move(t, g.User2, mustMove(fmt.Sprintf("place_scout %d,0", i)))
+	}
+
+	moves, err = g.LegalMoves()
+	assert.NoError(t, err)
+	assert.True(t, moves.CanPlaceBoulder)
+	assert.NotZero(t, moves.BoulderSquares)
+
+	state := g.StateSnapshot()
+	assert.Equal(t, scouts.PhasePlay, state.Phase)
+	assert.Equal(t, &TurnSnapshot{
+		PlayerSide:     scouts.PlayerA,
+		PlaysRemaining: scouts.StartingPlaysPerTurn,
+		Moves:          scouts.Moves{},
+	}, state.Turn)
+	assert.Equal(t, 2*scouts.PlaceScoutTurns, len(state.Board))
+	assert.Equal(t, []scouts.Point{scouts.Pt(0, 0)}, state.Board[0].Position())
+
+	g.move(t, g.User1, mustMove("boulder 3,4"))
+
+	state = g.StateSnapshot()
+	assert.Equal(t, 2*scouts.PlaceScoutTurns+1, len(state.Board))
+	assert.Equal(t, scouts.PlayerB, state.Turn.PlayerSide)
+}
+
+func TestGameInstancePlyState(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := CreateGameOptions{TimeLimit: Duration(time.Minute)}
+
+	g := &testingGameInstance{
+		gameInstance: newGameInstance(opts, slogt.New(t), clk),
+		User1:        user.NewAuthorized(user.GenerateSessionToken(), 1),
+		User2:        user.NewAuthorized(user.GenerateSessionToken(), 2),
+	}
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	clk.Advance(10 * time.Second)
+	g.move(t, g.User1, mustMove("place_scout 0,9"))
+
+	clk.Advance(20 * time.Second)
+	g.move(t, g.User2, mustMove("place_scout 0,0"))
+
+	state := g.StateSnapshot()
+
+	ply, err := plyState(state, 0)
+	assert.NoError(t, err)
+	assert.Zero(t, ply.Move)
+	assert.Equal(t, 0, len(ply.Board))
+	assert.Equal(t, scouts.PlayerA, ply.Turn.PlayerSide)
+	assert.Equal(t, [2]Duration{Duration(time.Minute), Duration(time.Minute)}, ply.TimeRemaining)
+
+	ply, err = plyState(state, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, &state.Moves[0], ply.Move)
+	assert.Equal(t, 1, len(ply.Board))
+	assert.Equal(t, scouts.PlayerB, ply.Turn.PlayerSide)
+	assert.Equal(t, [2]Duration{Duration(50 * time.Second), Duration(time.Minute)}, ply.TimeRemaining)
+
+	ply, err = plyState(state, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ply.Board))
+	assert.Equal(t, scouts.PhasePlaceScouts, ply.Phase)
+	assert.Equal(t, [2]Duration{Duration(50 * time.Second), Duration(40 * time.Second)}, ply.TimeRemaining)
+
+	_, err = plyState(state, 3)
+	assert.Error(t, err, "ply past the last move should be rejected")
+}
+
+func TestGameInstanceEventTimes(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := CreateGameOptions{TimeLimit: Duration(time.Minute)}
+
+	g := &testingGameInstance{
+		gameInstance: newGameInstance(opts, slogt.New(t), clk),
+		User1:        user.NewAuthorized(user.GenerateSessionToken(), 1),
+		User2:        user.NewAuthorized(user.GenerateSessionToken(), 2),
+	}
+	t.Cleanup(g.stop)
+
+	began := clk.Now()
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	clk.Advance(5 * time.Second)
+	ev, _ := g.subscribe(t, g.User1)
+
+	expectEvents(t, ev, []GameEvent{
+		PlayerJoinedEvent{
+			EventHeader: EventHeader{ServerTime: clk.Now()},
+			PlayerSide:  scouts.PlayerA,
+			UserID:      g.User1.UserID,
+		},
+		PlayerConnectedEvent{
+			EventHeader: EventHeader{ServerTime: clk.Now()},
+			PlayerSide:  scouts.PlayerA,
+		},
+		PlayerJoinedEvent{
+			EventHeader: EventHeader{ServerTime: clk.Now()},
+			PlayerSide:  scouts.PlayerB,
+			UserID:      g.User2.UserID,
+		},
+		TurnBeginEvent{
+			EventHeader:    EventHeader{ServerTime: began},
+			PlayerSide:     scouts.PlayerA,
+			PlaysRemaining: 1,
+			TimeRemaining:  [2]Duration{Duration(time.Minute), Duration(time.Minute)},
+			Deadline:       ptr(began.Add(time.Minute)),
+		},
+	})
+
+	clk.Advance(5 * time.Second)
+	moved := clk.Now()
+	g.move(t, g.User1, mustMove("place_scout 0,9"))
+
+	remaining := [2]Duration{Duration(50 * time.Second), Duration(time.Minute)}
+	expectEvents(t, ev, []GameEvent{
+		MoveMadeEvent{
+			EventHeader:   EventHeader{ServerTime: moved},
+			Move:          mustMove("place_scout 0,9"),
+			PlayerSide:    scouts.PlayerA,
+			TimeRemaining: remaining,
+			Changes:       scoutPlaced(scouts.PlayerA, 0, 9),
+			Deadline:      ptr(moved.Add(time.Minute)),
+		},
+		TurnBeginEvent{
+			EventHeader:    EventHeader{ServerTime: moved},
+			PlayerSide:     scouts.PlayerB,
+			PlaysRemaining: 1,
+			TimeRemaining:  remaining,
+			Deadline:       ptr(moved.Add(time.Minute)),
+		},
+	})
+
+	clk.Advance(15 * time.Second)
+	state := g.StateSnapshot()
+	assert.Equal(t, ptr(moved.Add(time.Minute)), state.Turn.Deadline)
+}
+
+func TestGameInstanceMoveChanges(t *testing.T) {
+	g := newTestingGameInstance(t, CreateGameOptions{})
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	for i := 0; i < scouts.PlaceScoutTurns-1; i++ {
+		g.move(t, g.User1, mustMove(fmt.Sprintf("place_scout %d,9", i)))
+		g.move(t, g.User2, mustMove(fmt.Sprintf("place_scout %d,0", i)))
+	}
+	g.move(t, g.User1, mustMove("place_scout 4,9"))
+
+	ev, _ := g.subscribe(t, g.User1)
+	playback := playbackEvents(g)
+
+	g.move(t, g.User2, mustMove("place_scout 4,0"))
+	g.move(t, g.User1, mustMove("boulder 3,4"))
+
+	expectEvents(t, ev, append(playback,
+		MoveMadeEvent{
+			Move:          mustMove("place_scout 4,0"),
+			PlayerSide:    scouts.PlayerB,
+			TimeRemaining: InfiniteDurationPair,
+			Changes:       scoutPlaced(scouts.PlayerB, 4, 0),
+		},
+		PhaseChangedEvent{Phase: scouts.PhasePlay},
+		TurnBeginEvent{
+			PlayerSide:     scouts.PlayerA,
+			PlaysRemaining: scouts.StartingPlaysPerTurn,
+			TimeRemaining:  InfiniteDurationPair,
+		},
+		MoveMadeEvent{
+			Move:          mustMove("boulder 3,4"),
+			PlayerSide:    scouts.PlayerA,
+			TimeRemaining: InfiniteDurationPair,
+			Changes: []PieceChange{{
+				After: &PieceState{
+					Kind:   scouts.BoulderPieceKind,
+					Player: scouts.PlayerA,
+					Position: []scouts.Point{
+						scouts.Pt(3, 4), scouts.Pt(4, 4),
+						scouts.Pt(3, 5), scouts.Pt(4, 5),
+					},
+				},
+			}},
+		},
+		TurnBeginEvent{
+			PlayerSide:     scouts.PlayerB,
+			PlaysRemaining: scouts.PlaysPerTurn,
+			TimeRemaining:  InfiniteDurationPair,
+		},
+	))
+}
+
+func TestGameInstanceConcurrentSubscribers(t *testing.T) {
+	backends := []struct {
+		name     string
+		eventLog *EventLogOptions
+	}{
+		{"queue", nil},
+		{"log", &EventLogOptions{}},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			testConcurrentSubscribers(t, backend.eventLog)
+		})
+	}
+}
+
+func testConcurrentSubscribers(t *testing.T, eventLog *EventLogOptions) {
+	const subscribers = 32
+
+	g := newTestingGameInstance(t, CreateGameOptions{})
+	g.eventLog = eventLog
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	var moves scouts.Moves
+	for i := 0; i < scouts.PlaceScoutTurns; i++ {
+		moves = append(moves,
+			mustMove(fmt.Sprintf("place_scout %d,9", i)),
+			mustMove(fmt.Sprintf("place_scout %d,0", i)))
+	}
+
+	// Stall one subscriber after its first event until every other
+	// subscriber has caught up.
+	stalled, _ := g.subscribe(t, user.NewAnonymous(user.GenerateSessionToken()))
+	_, err := stalled.Next(context.Background())
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	received := make([]scouts.Moves, subscribers)
+
+	for i := 0; i < subscribers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Subscribe while the moves are being made, so that earlier moves
+			// are replayed and later ones are published.
+			events, _ := g.subscribe(t, user.NewAnonymous(user.GenerateSessionToken()))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			for len(received[i]) < len(moves) {
+				ev, err := events.Next(ctx)
+				if err != nil {
+					t.Errorf("subscriber %d: %v", i, err)
+					return
+				}
+				if ev, ok := ev.(MoveMadeEvent); ok {
+					received[i] = append(received[i], ev.Move)
+				}
+			}
+		}(i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.StateSnapshot()
+			g.LegalMoves()
+		}()
+	}
+
+	for i, move := range moves {
+		player := g.User1
+		if i%2 == 1 {
+			player = g.User2
+		}
+		g.move(t, player, move)
+	}
+
+	wg.Wait()
+
+	for i := 0; i < subscribers; i++ {
+		assert.Equal(t, moves, received[i],
+			"subscriber %d should receive every move exactly once while another is stalled", i)
+	}
+}
+
+func TestGameInstanceStopUnstarted(t *testing.T) {
+	g := newTestingGameInstance(t, CreateGameOptions{})
+	g.join(t, g.User1)
+
+	done := make(chan struct{})
+	go func() {
+		g.stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stopping a game that never began should not block")
+	}
+
+	_, err := g.PlayerJoin(g.User2, "")
+	assert.IsError(t, err, ErrGameStopped, "stopped games cannot be joined")
+
+	state := g.StateSnapshot()
+	assert.Zero(t, state.BeganAt, "stopped games should not begin")
+}
+
+func TestGameInstanceEventLog(t *testing.T) {
+	moves := []string{"place_scout 0,9", "place_scout 0,0", "place_scout 1,9"}
+
+	t.Run("disconnect", func(t *testing.T) {
+		g := newTestingGameInstance(t, CreateGameOptions{})
+		g.eventLog = &EventLogOptions{Capacity: 4}
+		t.Cleanup(g.stop)
+
+		g.join(t, g.User1)
+		g.join(t, g.User2)
+
+		events, _ := g.subscribe(t, user.NewAnonymous(user.GenerateSessionToken()))
+		expectEvents(t, events, playbackEvents(g))
+
+		// Each move sends two events, so the log overflows.
+		g.turn(t, g.User1, moves[0])
+		g.turn(t, g.User2, moves[1])
+		g.turn(t, g.User1, moves[2])
+
+		expectEvents(t, events, []GameEvent{
+			goingAwayEvent(GoingAwaySlowSubscriber),
+		})
+
+		_, err := events.Next(context.Background())
+		assert.IsError(t, err, pubsub.ErrLagged, "slow subscribers should be disconnected")
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		g := newTestingGameInstance(t, CreateGameOptions{})
+		g.eventLog = &EventLogOptions{Capacity: 4, SlowReaders: pubsub.SkipSlowReaders}
+		t.Cleanup(g.stop)
+
+		g.join(t, g.User1)
+		g.join(t, g.User2)
+
+		events, _ := g.subscribe(t, user.NewAnonymous(user.GenerateSessionToken()))
+		expectEvents(t, events, playbackEvents(g))
+
+		g.turn(t, g.User1, moves[0])
+		g.turn(t, g.User2, moves[1])
+		g.turn(t, g.User1, moves[2])
+
+		var received []string
+		for i := 0; i < 4; i++ {
+			ev, err := events.Next(context.Background())
+			assert.NoError(t, err)
+			if ev, ok := ev.(MoveMadeEvent); ok {
+				text, _ := ev.Move.MarshalText()
+				received = append(received, string(text))
+			}
+		}
+
+		assert.Equal(t, moves[1:], received,
+			"slow subscribers should skip to the oldest events in the log")
+	})
+}
+
+func TestGameInstanceStop(t *testing.T) {
+	g := newTestingGameInstance(t, CreateGameOptions{})
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+	g.move(t, g.User1, mustMove("place_scout 0,9"))
+
+	events, _ := g.subscribe(t, g.User2)
+	playback := playbackEvents(g)
+
+	g.Stop(GoingAwayGameExpired)
+	g.Stop(GoingAwayServerShutdown)
+
+	expectEvents(t, events, append(playback, GoingAwayEvent{Reason: GoingAwayGameExpired}))
+	assertSubscriptionClosed(t, events)
+
+	err := g.MakeMove(context.Background(), g.User2, mustMove("place_scout 0,0"), MoveOptions{})
+	assert.IsError(t, err, ErrGameStopped, "stopped games cannot be changed")
+
+	_, err = g.SubscribeGame(g.User1)
+	assert.IsError(t, err, ErrGameStopped, "stopped games cannot be subscribed to")
+
+	state := g.StateSnapshot()
+	assert.Equal(t, 1, len(state.Moves), "stopped games can still be viewed")
+}
+
 type testingGameInstance struct {
 	*gameInstance
 	User1 user.Authorization
@@ -291,23 +830,52 @@ func newTestingGameInstance(t *testing.T, opts CreateGameOptions) *testingGameIn
 	}
 }
 
+// stop stops the game as if the server were shutting down.
+func (g *testingGameInstance) stop() {
+	g.Stop(GoingAwayServerShutdown)
+}
+
 func (g *testingGameInstance) join(t *testing.T, player user.Authorization) {
-	err := g.PlayerJoin(player)
+	_, err := g.PlayerJoin(player, "")
 	assert.NoError(t, err, "player should be able to join")
 }
 
-func (g *testingGameInstance) subscribe(t *testing.T, player user.Authorization) (<-chan GameEvent, func()) {
-	ev, stop, err := g.SubscribeGame(player)
+func (g *testingGameInstance) subscribe(t *testing.T, player user.Authorization) (*GameSubscription, func()) {
+	sub, err := g.SubscribeGame(player)
 	assert.NoError(t, err, "player should be able to subscribe to game events")
-	t.Cleanup(func() { stop() })
-	return ev, stop
+	t.Cleanup(sub.Close)
+	return sub, sub.Close
 }
 
 func (g *testingGameInstance) move(t *testing.T, user user.Authorization, move scouts.Move) {
-	err := g.MakeMove(user, move)
+	err := g.MakeMove(context.Background(), user, move, MoveOptions{})
 	assert.NoError(t, err, "player should be able to make move")
 }
 
+func (g *testingGameInstance) turn(t *testing.T, user user.Authorization, moves string) {
+	err := g.MakeTurn(context.Background(), user, mustMoves(moves), MoveOptions{})
+	assert.NoError(t, err, "player should be able to make turn")
+}
+
+// scoutPlaced returns the changes made by placing a scout at x, y.
+func scoutPlaced(player scouts.Player, x, y int) []PieceChange {
+	return []PieceChange{{
+		After: &PieceState{
+			Kind:     scouts.ScoutPieceKind,
+			Player:   player,
+			Position: []scouts.Point{scouts.Pt(x, y)},
+		},
+	}}
+}
+
+func mustMoves(moves string) scouts.Moves {
+	m, err := scouts.ParseMoves(moves)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
 func mustMove(move string) scouts.Move {
 	m, err := scouts.ParseMove(move)
 	if err != nil {
@@ -316,47 +884,53 @@ func mustMove(move string) scouts.Move {
 	return m
 }
 
-func expectEvents(t *testing.T, ch <-chan GameEvent, events []GameEvent) {
+func expectEvents(t *testing.T, sub *GameSubscription, events []GameEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
 	checkQueue := slices.Clone(events)
 	t.Logf("expecting %d events", len(checkQueue))
 	for len(checkQueue) > 0 {
-		select {
-		case actual, ok := <-ch:
-			if !ok {
-				t.Fatal("channel closed prematurely")
-			}
-			ix := slices.IndexFunc(checkQueue, func(wanted GameEvent) bool {
-				return reflect.DeepEqual(wanted, actual)
-			})
-			if ix == -1 {
-				t.Fatalf("unexpected event %T", actual)
-			}
-			checkQueue = slices.Delete(checkQueue, ix, ix+1)
-			t.Logf("  received event %T", actual)
-		case <-time.After(2 * time.Second):
+		actual, err := sub.Next(ctx)
+		if err != nil {
 			for _, ev := range checkQueue {
 				t.Logf("  missed %T", ev)
 			}
-			t.Error("timed out waiting for event")
-			return
+			if errors.Is(err, context.DeadlineExceeded) {
+				t.Error("timed out waiting for event")
+				return
+			}
+			t.Fatal("subscription ended prematurely:", err)
+		}
+		ix := slices.IndexFunc(checkQueue, func(wanted GameEvent) bool {
+			// Most tests don't care about when an event was sent, so only
+			// compare the header if the wanted event has one.
+			if wanted.Header() == (EventHeader{}) {
+				return reflect.DeepEqual(wanted, actual.withHeader(EventHeader{}))
+			}
+			return reflect.DeepEqual(wanted, actual)
+		})
+		if ix == -1 {
+			t.Fatalf("unexpected event %T", actual)
 		}
+		checkQueue = slices.Delete(checkQueue, ix, ix+1)
+		t.Logf("  received event %T", actual)
 	}
 }
 
-func assertChClosed[T any](t *testing.T, ch <-chan T, msg ...any) {
+func assertSubscriptionClosed(t *testing.T, sub *GameSubscription, msg ...any) {
 	if msg == nil {
-		msg = []any{"channel should be closed"}
+		msg = []any{"subscription should be closed"}
 	}
-	timeout := time.After(1 * time.Second)
-drainLoop:
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
 	for {
-		select {
-		case _, ok := <-ch:
-			if ok {
-				break drainLoop
-			}
-		case <-timeout:
-			t.Fatal("timed out waiting for channel to close")
+		_, err := sub.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			t.Fatal(msg...)
 		}
 	}
 }
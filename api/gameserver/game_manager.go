@@ -2,12 +2,22 @@ package gameserver
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/puzpuzpuz/xsync/v3"
+	"go.opentelemetry.io/otel/attribute"
 	"libdb.so/hrt"
 	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/internal/pubsub"
 	"libdb.so/scouts-server/scouts"
 )
 
@@ -17,12 +27,20 @@ var ErrNotFound = hrt.NewHTTPError(404, "not found")
 // ErrGameFull is an error that is returned when a game is full.
 var ErrGameFull = hrt.NewHTTPError(400, "game already has two players")
 
+// ErrSideTaken is an error that is returned when a player requests a side
+// that is not available.
+var ErrSideTaken = hrt.NewHTTPError(409, "side is not available")
+
 // ErrInvalidMove is an error that is returned when a move is invalid.
 var ErrInvalidMove = hrt.NewHTTPError(400, "invalid move")
 
 // ErrInvalidMove is an error that is returned when a move is invalid.
 var ErrInvalidGameState = hrt.NewHTTPError(400, "invalid game state")
 
+// ErrShuttingDown is an error that is returned when a game is created while
+// the server is shutting down.
+var ErrShuttingDown = hrt.NewHTTPError(503, "server is shutting down")
+
 // CreateGameOptions is a struct that contains options for creating a game.
 // All fields are optional.
 type CreateGameOptions struct {
@@ -31,12 +49,33 @@ type CreateGameOptions struct {
 	TimeLimit Duration
 	// Increment is the time increment per move.
 	Increment Duration
+	// Rated is true if the game should affect the ratings of both players.
+	// Only games between two registered users can be rated.
+	Rated bool
+	// Side is the side that the creator would like to play. The side is
+	// reserved for the creator until they join. If empty, then no side is
+	// reserved and players take the sides in the order that they join.
+	Side SidePreference
+	// AutoJoin is true if the creator should join the game right away.
+	AutoJoin bool
+	// Private is true if the game should not be listed publicly. Only the
+	// players and users with the game's invite code can join or spectate a
+	// private game.
+	Private bool
+	// MaxLagCompensation is the maximum total time that each player can be
+	// credited for their connection's latency. If this is zero, then
+	// DefaultMaxLagCompensation is used. If this is negative, then lag
+	// compensation is disabled.
+	MaxLagCompensation Duration
 }
 
 // GameState is a struct that contains metadata about a game.
 type GameState struct {
 	// GameID is the GameID of the game.
 	GameID GameID `json:"game_id"`
+	// InviteCode is the invite code of the game.
+	// If empty, then the game is public.
+	InviteCode InviteCode `json:"invite_code,omitempty"`
 	// BeganAt is the time that the game began.
 	// If nil, then the game has not begun yet.
 	BeganAt *time.Time `json:"began_at"`
@@ -46,8 +85,36 @@ type GameState struct {
 	// PlayerB is the second player.
 	// If nil, then the player has not joined yet.
 	PlayerB *user.Authorization `json:"player_b"`
+	// EndedAt is the time that the game ended.
+	// If nil, then the game has not ended yet.
+	EndedAt *time.Time `json:"ended_at"`
+	// Winner is the side that won the game. It is only meaningful if EndedAt
+	// is not nil, in which case PlayerNone means that the game was drawn.
+	Winner scouts.Player `json:"winner"`
 	// Moves is the list of moves that have been made in the game.
 	Moves []MoveSnapshot `json:"moves"`
+	// Phase is the current phase of the game.
+	Phase scouts.Phase `json:"phase"`
+	// Turn is the current turn.
+	// If nil, then the game has ended.
+	Turn *TurnSnapshot `json:"turn"`
+	// Board is the list of pieces on the board.
+	Board []scouts.Piece `json:"board"`
+	// Offers is the list of pending draw offers and takeback requests.
+	Offers []Offer `json:"offers"`
+	// RematchOffers contains whether each side has offered a rematch, with
+	// player A first.
+	RematchOffers [2]bool `json:"rematch_offers"`
+	// RematchID is the ID of the rematch game.
+	// If nil, then no rematch has been agreed on.
+	RematchID *GameID `json:"rematch_id"`
+	// Chat is the list of chat messages sent in the game. It is not included
+	// in the JSON, since each chat room must only be seen by its members.
+	// Subscribers receive the messages of their room instead.
+	Chat []ChatMessage `json:"-"`
+	// Ratings contains the ratings of both players before and after the game.
+	// If nil, then the game is not rated.
+	Ratings *GameRatings `json:"ratings"`
 	// Metadata is the metadata of the game.
 	Metadata CreateGameOptions `json:"metadata"`
 	// CreatedAt is the time that the game was created.
@@ -60,70 +127,411 @@ func (s GameState) hasBothPlayers() bool {
 	return s.PlayerA != nil && s.PlayerB != nil
 }
 
+// TurnSnapshot describes the current turn of a game.
+type TurnSnapshot struct {
+	// PlayerSide is the side that is to move.
+	PlayerSide scouts.Player `json:"player_side"`
+	// PlaysRemaining is the number of plays remaining in the turn.
+	PlaysRemaining int `json:"plays_remaining"`
+	// Moves is the list of moves that have been made in the turn so far.
+	Moves scouts.Moves `json:"moves"`
+	// Deadline is the time by which the player must finish their turn.
+	// If nil, then the game has no time limit or has not begun.
+	Deadline *time.Time `json:"deadline"`
+}
+
+// PlyState is the state of a game after a number of moves were made.
+type PlyState struct {
+	// Ply is the number of moves that were made.
+	Ply int `json:"ply"`
+	// Move is the last move that was made.
+	// If nil, then no moves were made.
+	Move *MoveSnapshot `json:"move"`
+	// Phase is the phase of the game.
+	Phase scouts.Phase `json:"phase"`
+	// Turn is the turn after the last move.
+	// If nil, then the game ended on the last move.
+	Turn *TurnSnapshot `json:"turn"`
+	// Board is the list of pieces on the board.
+	Board []scouts.Piece `json:"board"`
+	// Winner is the side that won the game on the last move. It is only
+	// meaningful if Phase is PhaseEnded.
+	Winner scouts.Player `json:"winner"`
+	// TimeRemaining is the time remaining for both sides.
+	TimeRemaining [2]Duration `json:"time_remaining"`
+}
+
+// LegalMoves contains the moves that the side to move can make.
+type LegalMoves struct {
+	scouts.PossibleMoves
+	// PlayerSide is the side that is to move.
+	PlayerSide scouts.Player `json:"player_side"`
+	// PlaysRemaining is the number of plays remaining in the turn.
+	PlaysRemaining int `json:"plays_remaining"`
+}
+
 // MoveSnapshot contains a single move that a player made and the time that the
 // move was made.
 type MoveSnapshot struct {
 	Player scouts.Player
 	Move   scouts.Move
 	Time   time.Time
+	// LagCredit is the time that the player was credited for their
+	// connection's latency when making the move.
+	LagCredit Duration
 }
 
-const (
-	gameTTL = 2 * time.Hour
-	gameGC  = 1 * time.Hour
-)
+// UnmarshalJSON implements json.Unmarshaler. The move is parsed from its text
+// form, which is how it is marshaled.
+func (s *MoveSnapshot) UnmarshalJSON(b []byte) error {
+	type rawSnapshot MoveSnapshot
+	var raw struct {
+		rawSnapshot
+		Move string
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	move, err := scouts.ParseMove(raw.Move)
+	if err != nil {
+		return fmt.Errorf("invalid move %q: %w", raw.Move, err)
+	}
+
+	*s = MoveSnapshot(raw.rawSnapshot)
+	s.Move = move
+	return nil
+}
+
+// GCOptions configures the garbage collection of games, which evicts games
+// from memory once they have been inactive for longer than their retention.
+// Zero fields take their values from DefaultGCOptions.
+type GCOptions struct {
+	// Interval is how often games are checked for inactivity.
+	Interval time.Duration
+	// Unstarted is how long games that have not begun are kept after they
+	// were created.
+	Unstarted time.Duration
+	// Running is how long games that have begun are kept after their last
+	// move.
+	Running time.Duration
+	// Finished is how long games that have ended are kept after they ended.
+	// They are archived before they are evicted if there is a GameArchive.
+	Finished time.Duration
+}
+
+// DefaultGCOptions are the GCOptions used by default.
+var DefaultGCOptions = GCOptions{
+	Interval:  5 * time.Minute,
+	Unstarted: 30 * time.Minute,
+	Running:   2 * time.Hour,
+	Finished:  15 * time.Minute,
+}
+
+func (o GCOptions) withDefaults() GCOptions {
+	if o.Interval == 0 {
+		o.Interval = DefaultGCOptions.Interval
+	}
+	if o.Unstarted == 0 {
+		o.Unstarted = DefaultGCOptions.Unstarted
+	}
+	if o.Running == 0 {
+		o.Running = DefaultGCOptions.Running
+	}
+	if o.Finished == 0 {
+		o.Finished = DefaultGCOptions.Finished
+	}
+	return o
+}
 
 // GameManager is in charge of managing games. Games managed here may or may not
 // be persisted in a database.
 type GameManager struct {
-	games  *xsync.MapOf[GameID, *gameInstance]
-	logger *slog.Logger
+	games   *xsync.MapOf[GameID, *gameInstance]
+	invites *xsync.MapOf[InviteCode, GameID]
+	ratings RatingStorage
+	archive GameArchive
+	filter  ChatFilter
+	gc      GCOptions
+	events  *EventLogOptions
+	// observer is told about all games. It may be nil.
+	observer GameObserver
+	// limits limits the subscribers of all games, which share its counters.
+	limits  pubsub.Limits
+	metrics *gameMetrics
+	clock   clock.Clock
+	logger  *slog.Logger
+	// shuttingDown is true once Shutdown has been called.
+	shuttingDown atomic.Bool
+}
+
+// GameManagerOptions contains optional services for a GameManager.
+type GameManagerOptions struct {
+	// RatingStorage is used to persist player ratings.
+	// If nil, then no game is rated.
+	RatingStorage RatingStorage
+	// GameArchive is used to persist finished games once they are evicted
+	// from memory. If nil, then evicted games are dropped.
+	GameArchive GameArchive
+	// GC configures the garbage collection of games started by BeginGC.
+	GC GCOptions
+	// ChatFilter is used to filter chat messages, e.g. to censor profanity.
+	// If nil, then chat messages are not filtered.
+	ChatFilter ChatFilter
+	// EventLog configures games to deliver their events through a shared
+	// event log of bounded size. If nil, then each subscriber of a game gets
+	// its own queue.
+	EventLog *EventLogOptions
+	// Backpressure limits how far each subscriber of a game can fall behind
+	// if EventLog is nil. By default, there are no limits.
+	Backpressure BackpressureOptions
+	// Clock is used to tell the time in games, in the matchmaker and in the
+	// lobby, and to time garbage collection. If nil, then the real clock is
+	// used.
+	Clock clock.Clock
+	// Metrics is where the metrics of games are registered. If nil, then the
+	// metrics are not registered anywhere.
+	Metrics prometheus.Registerer
+	// Observer is told about the creation and the events of every game. It
+	// may be nil.
+	Observer GameObserver
 }
 
 // NewGameManager creates a new game manager.
-func NewGameManager(logger *slog.Logger) *GameManager {
-	return &GameManager{
-		games:  xsync.NewMapOf[GameID, *gameInstance](),
+func NewGameManager(logger *slog.Logger, opts GameManagerOptions) *GameManager {
+	m := &GameManager{
+		games:    xsync.NewMapOf[GameID, *gameInstance](),
+		invites:  xsync.NewMapOf[InviteCode, GameID](),
+		ratings:  opts.RatingStorage,
+		archive:  opts.GameArchive,
+		filter:   opts.ChatFilter,
+		observer: opts.Observer,
+		gc:       opts.GC.withDefaults(),
+		events:   opts.EventLog,
+		limits: pubsub.Limits{
+			MaxQueued:    opts.Backpressure.MaxQueuedEvents,
+			MaxLag:       opts.Backpressure.MaxLag,
+			Backpressure: opts.Backpressure.Action,
+			Counters:     new(pubsub.BackpressureCounters),
+		},
+		clock:  clock.Or(opts.Clock),
 		logger: logger.With("component", "api/gameserver/gamemanager"),
 	}
+
+	metrics, collectors := newGameMetrics(m.limits)
+	if opts.Metrics != nil {
+		opts.Metrics.MustRegister(collectors...)
+	}
+	m.metrics = metrics
+
+	return m
+}
+
+// BackpressureCounts returns how often each backpressure action has been taken
+// on the subscribers of all games.
+func (m *GameManager) BackpressureCounts() pubsub.BackpressureCounts {
+	return m.limits.Counters.Counts()
 }
 
 // BeginGC starts a background goroutine that will periodically garbage collect
-// games that have been inactive for a certain amount of time.
+// games that have been inactive for longer than their retention. Finished games
+// are archived before they are evicted.
 func (m *GameManager) BeginGC() (stop func()) {
 	ctx, cancel := context.WithCancel(context.Background())
+	ticker := m.clock.NewTicker(m.gc.Interval)
 	go func() {
-		ticker := time.NewTicker(gameGC)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
-				m.gc()
+			case <-ticker.C():
+				m.collectGarbage()
 			}
 		}
 	}()
 	return cancel
 }
 
-func (m *GameManager) gc() {
+func (m *GameManager) collectGarbage() {
 	m.games.Range(func(id GameID, game *gameInstance) bool {
-		if game.KillIfInactive(gameTTL) {
-			m.games.Delete(id)
-			m.logger.Info(
-				"game has been garbage collected",
+		if !game.KillIfInactive(m.gc) {
+			return true
+		}
+
+		if err := m.evictGame(id, game); err != nil {
+			// Keep the game around, so that archiving it is tried again
+			// next time.
+			m.logger.Error(
+				"failed to archive game, keeping it in memory",
 				"game_id", id,
-				"game_began_at", game.state.BeganAt,
-				"game_moves", len(game.state.Moves))
+				"err", err)
 		}
+
+		return true
+	})
+}
+
+// evictGame removes the stopped game from memory, archiving it first if it has
+// ended. The game is kept if it could not be archived.
+func (m *GameManager) evictGame(id GameID, game *gameInstance) error {
+	state := game.StateSnapshot()
+	if err := m.archiveGame(state); err != nil {
+		return err
+	}
+
+	if !m.forgetGame(id, state) {
+		// The game has been evicted by someone else in the meantime.
+		return nil
+	}
+
+	m.logger.Info(
+		"game has been evicted",
+		"game_id", id,
+		"game_began_at", state.BeganAt,
+		"game_ended_at", state.EndedAt,
+		"game_moves", len(state.Moves))
+
+	return nil
+}
+
+// discardGame stops the game with the given game ID and removes it from
+// memory without archiving it. It cleans up games that could not be set up,
+// e.g. because a player could not join.
+func (m *GameManager) discardGame(id GameID) {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return
+	}
+
+	game.Stop(GoingAwayGameEvicted)
+	if m.forgetGame(id, game.StateSnapshot()) {
+		m.logger.Debug(
+			"game has been discarded",
+			"game_id", id)
+	}
+}
+
+// forgetGame removes the game with the given game ID and state from memory.
+// False is returned if it has already been removed.
+func (m *GameManager) forgetGame(id GameID, state GameState) bool {
+	if _, ok := m.games.LoadAndDelete(id); !ok {
+		return false
+	}
+	if state.InviteCode != "" {
+		m.invites.Delete(state.InviteCode)
+	}
+	m.metrics.enterPhase(state.gamePhase(), "")
+	return true
+}
+
+// archiveGame archives the game if it has ended and there is a GameArchive.
+func (m *GameManager) archiveGame(state GameState) error {
+	if state.EndedAt == nil {
+		return nil
+	}
+	return m.archiveState(state)
+}
+
+// archiveState archives the game as it is, whether or not it has ended, if
+// there is a GameArchive.
+func (m *GameManager) archiveState(state GameState) error {
+	if m.archive == nil {
+		return nil
+	}
+	return m.archive.ArchiveGame(archiveGame(state, m.clock.Now()))
+}
+
+// queryArchivedGame queries the game with the given game ID from the archive.
+// ErrNotFound is returned if there is no archive.
+func (m *GameManager) queryArchivedGame(id GameID) (ArchivedGame, error) {
+	if m.archive == nil {
+		return ArchivedGame{}, ErrNotFound
+	}
+	return m.archive.QueryArchivedGame(id)
+}
+
+// Shutdown stops all games, which sends a GoingAwayEvent to their subscribers
+// so that they know that the games are no longer being served. Games are not
+// kept across restarts, so the games that have begun are archived, including
+// the ones that are still being played, so that they can still be viewed.
+// Shutdown then waits until all subscriptions have been closed or ctx is done,
+// in which case ctx's error is returned. No games can be created afterwards.
+func (m *GameManager) Shutdown(ctx context.Context) error {
+	m.shuttingDown.Store(true)
+
+	var games []*gameInstance
+	m.games.Range(func(id GameID, game *gameInstance) bool {
+		games = append(games, game)
 		return true
 	})
+
+	m.logger.Info(
+		"shutting down games",
+		"games", len(games))
+
+	var wg sync.WaitGroup
+	for _, game := range games {
+		wg.Add(1)
+		go func(game *gameInstance) {
+			defer wg.Done()
+			game.Stop(GoingAwayServerShutdown)
+		}(game)
+	}
+	wg.Wait()
+
+	// The games are archived once they have stopped, so that no move can be
+	// made after they are archived.
+	for _, game := range games {
+		state := game.StateSnapshot()
+		if state.BeganAt == nil {
+			continue
+		}
+		if err := m.archiveState(state); err != nil {
+			m.logger.Error(
+				"failed to archive game on shutdown",
+				"game_id", state.GameID,
+				"err", err)
+		}
+	}
+
+	m.logger.Info("stopped all games, waiting for subscribers to disconnect")
+
+	for _, game := range games {
+		if err := game.waitSubscribers(ctx); err != nil {
+			m.logger.Warn(
+				"gave up waiting for subscribers to disconnect",
+				"err", err)
+			return err
+		}
+	}
+
+	m.logger.Info("all subscribers have disconnected")
+	return nil
 }
 
 // CreateGame creates a game with the given game ID and game metadata.
+// The creator automatically joins the game if metadata.AutoJoin is true.
 func (m *GameManager) CreateGame(user user.Authorization, metadata CreateGameOptions) (GameID, error) {
-	game := newGameInstance(metadata, m.logger, nil)
+	if m.shuttingDown.Load() {
+		return GameID{}, ErrShuttingDown
+	}
+
+	if err := metadata.Side.Validate(); err != nil {
+		return GameID{}, hrt.WrapHTTPError(400, err)
+	}
+
+	game := newGameInstance(metadata, m.logger, m.clock)
+	game.ratings = m.ratings
+	game.chatFilter = m.filter
+	game.eventLog = m.events
+	game.limits = m.limits
+	game.metrics = m.metrics
+	game.observer = m.observer
+	game.creator = user
+	if metadata.Side != "" {
+		game.creatorSide = metadata.Side.Resolve()
+	}
 	for {
 		game.state.GameID = GenerateGameID()
 		_, exists := m.games.LoadOrStore(game.state.GameID, game)
@@ -132,45 +540,329 @@ func (m *GameManager) CreateGame(user user.Authorization, metadata CreateGameOpt
 		}
 	}
 	game.logger = game.logger.With("game_id", game.state.GameID)
+	m.metrics.enterPhase("", gameUnstarted)
+
+	if metadata.Private {
+		for {
+			code := GenerateInviteCode()
+			_, exists := m.invites.LoadOrStore(code, game.state.GameID)
+			if !exists {
+				game.setInviteCode(code)
+				break
+			}
+		}
+	}
+
+	if m.observer != nil {
+		game.view(func() { m.observer.GameCreated(game.stateSnapshot()) })
+	}
+
+	if metadata.AutoJoin {
+		if _, err := game.PlayerJoin(user, ""); err != nil {
+			// Nobody knows about the game yet, so don't leave it and its
+			// invite code behind.
+			m.discardGame(game.state.GameID)
+			return GameID{}, err
+		}
+	}
+
 	return game.state.GameID, nil
 }
 
-// QueryGame queries the game with the given game ID.
+// QueryGame queries the game with the given game ID. Games that have been
+// archived are queried from the archive.
 func (m *GameManager) QueryGame(id GameID) (GameState, error) {
 	game, ok := m.games.Load(id)
 	if !ok {
-		return GameState{}, ErrNotFound
+		archived, err := m.queryArchivedGame(id)
+		if err != nil {
+			return GameState{}, err
+		}
+		return archived.State()
 	}
 	return game.StateSnapshot(), nil
 }
 
-// JoinGame joins the game with the given game ID.
-// If the game is full, then this returns an error, otherwise the player
-// automatically takes the next available side.
-func (m *GameManager) JoinGame(user user.Authorization, id GameID) error {
+// ListGames lists all public games that have not ended yet, newest first.
+func (m *GameManager) ListGames() []GameState {
+	var states []GameState
+	m.games.Range(func(id GameID, game *gameInstance) bool {
+		state := game.StateSnapshot()
+		if !state.Metadata.Private && state.EndedAt == nil {
+			states = append(states, state)
+		}
+		return true
+	})
+
+	slices.SortFunc(states, func(a, b GameState) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return states
+}
+
+// AuthorizeGame checks that the user can access the game with the given game
+// ID. Anyone can access a public game, but a private game can only be accessed
+// by its creator, its players and users with its invite code. ErrNotFound is
+// returned if the user cannot access the game, so that the existence of
+// private games isn't leaked.
+func (m *GameManager) AuthorizeGame(user user.Authorization, id GameID, code InviteCode) error {
 	game, ok := m.games.Load(id)
 	if !ok {
+		archived, err := m.queryArchivedGame(id)
+		if err != nil {
+			return err
+		}
+		if !archived.canAccess(user, code) {
+			return ErrNotFound
+		}
+		return nil
+	}
+	if !game.CanAccess(user, code) {
 		return ErrNotFound
 	}
-	return game.PlayerJoin(user)
+	return nil
+}
+
+// ResolveInviteCode returns the ID of the game with the given invite code.
+func (m *GameManager) ResolveInviteCode(code InviteCode) (GameID, error) {
+	id, ok := m.invites.Load(code)
+	if !ok {
+		return GameID{}, ErrNotFound
+	}
+	return id, nil
+}
+
+// JoinGame joins the game with the given game ID and returns the side that the
+// player took. If the player has no side preference, then they take the side
+// that isn't reserved for the creator.
+func (m *GameManager) JoinGame(user user.Authorization, id GameID, side SidePreference) (scouts.Player, error) {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return game.PlayerJoin(user, side)
+}
+
+// QueryGameAt queries the state of the game with the given game ID after the
+// given number of moves were made. If ply is negative, then the state after
+// the latest move is returned.
+func (m *GameManager) QueryGameAt(id GameID, ply int) (PlyState, error) {
+	state, err := m.QueryGame(id)
+	if err != nil {
+		return PlyState{}, err
+	}
+
+	if ply < 0 {
+		ply = len(state.Moves)
+	}
+
+	return plyState(state, ply)
+}
+
+// LegalMoves returns the legal moves for the side to move in the game with the
+// given game ID.
+func (m *GameManager) LegalMoves(id GameID) (LegalMoves, error) {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return LegalMoves{}, ErrNotFound
+	}
+	return game.LegalMoves()
 }
 
 // MakeMove makes a move in the game with the given game ID.
-func (m *GameManager) MakeMove(user user.Authorization, id GameID, move scouts.Move) error {
+func (m *GameManager) MakeMove(ctx context.Context, user user.Authorization, id GameID, move scouts.Move, opts MoveOptions) (err error) {
+	ctx, span := startSpan(ctx, "GameManager.MakeMove", id, user,
+		attribute.String("move", move.String()))
+	defer func() { endSpan(span, err) }()
+
 	game, ok := m.games.Load(id)
 	if !ok {
+		m.metrics.moveFailed(ErrNotFound)
 		return ErrNotFound
 	}
-	return game.MakeMove(user, move)
+	err = game.MakeMove(ctx, user, move, opts)
+	m.metrics.moveFailed(err)
+	return err
 }
 
-// SubscribeGame returns a new channel that will receive game events.
-// The channel will first receive playbacks of all moves that have been made in
-// the game, and then it will receive new moves as they are made.
-func (m *GameManager) SubscribeGame(user user.Authorization, id GameID) (<-chan GameEvent, func(), error) {
+// MakeTurn makes all the given moves as a single turn in the game with the
+// given game ID. Either all moves are made or none are.
+func (m *GameManager) MakeTurn(ctx context.Context, user user.Authorization, id GameID, moves scouts.Moves, opts MoveOptions) (err error) {
+	ctx, span := startSpan(ctx, "GameManager.MakeTurn", id, user,
+		attribute.Int("moves", len(moves)))
+	defer func() { endSpan(span, err) }()
+
 	game, ok := m.games.Load(id)
 	if !ok {
-		return nil, nil, ErrNotFound
+		m.metrics.moveFailed(ErrNotFound)
+		return ErrNotFound
+	}
+	err = game.MakeTurn(ctx, user, moves, opts)
+	m.metrics.moveFailed(err)
+	return err
+}
+
+// MakeOffer offers a draw or requests a takeback in the game with the given
+// game ID.
+func (m *GameManager) MakeOffer(user user.Authorization, id GameID, kind OfferKind) error {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return ErrNotFound
+	}
+	return game.MakeOffer(user, kind)
+}
+
+// AcceptOffer accepts the opponent's offer of the given kind in the game with
+// the given game ID.
+func (m *GameManager) AcceptOffer(user user.Authorization, id GameID, kind OfferKind) error {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return ErrNotFound
+	}
+	return game.AcceptOffer(user, kind)
+}
+
+// DeclineOffer declines the opponent's offer of the given kind in the game
+// with the given game ID.
+func (m *GameManager) DeclineOffer(user user.Authorization, id GameID, kind OfferKind) error {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return ErrNotFound
+	}
+	return game.DeclineOffer(user, kind)
+}
+
+// SendChat sends a chat message from the user to the game with the given game
+// ID.
+func (m *GameManager) SendChat(user user.Authorization, id GameID, text string) error {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return ErrNotFound
+	}
+	return game.SendChat(user, text)
+}
+
+// PingGame records a ping to the user's connection to the game with the given
+// game ID. The returned event must be sent to that connection only.
+func (m *GameManager) PingGame(user user.Authorization, id GameID) (PingEvent, error) {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return PingEvent{}, ErrNotFound
+	}
+	return game.Ping(user)
+}
+
+// PongGame records the acknowledgement of a ping sent to the user's connection
+// to the game with the given game ID.
+func (m *GameManager) PongGame(user user.Authorization, id GameID, pingID int) error {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return ErrNotFound
+	}
+	return game.Pong(user, pingID)
+}
+
+// SubscribeGame returns a new subscription that will receive game events.
+// The subscription will first receive playbacks of all moves that have been
+// made in the game, and then it will receive new moves as they are made.
+// Archived games are replayed along with their chat, after which the
+// subscription goes away.
+func (m *GameManager) SubscribeGame(user user.Authorization, id GameID) (*GameSubscription, error) {
+	game, ok := m.games.Load(id)
+	if !ok {
+		archived, err := m.queryArchivedGame(id)
+		if err != nil {
+			return nil, err
+		}
+		return archived.subscribe(user)
 	}
 	return game.SubscribeGame(user)
 }
+
+// RematchResult is the result of offering a rematch.
+type RematchResult struct {
+	// Accepted is true if both players have agreed to the rematch.
+	Accepted bool `json:"accepted"`
+	// GameID is the ID of the new game. It is only set if Accepted is true.
+	GameID *GameID `json:"game_id"`
+}
+
+// Rematch offers a rematch in the game with the given game ID, which must have
+// ended. Once both players have offered a rematch, a new game is created with
+// the same options and with both players' sides swapped, and the old game is
+// stopped.
+func (m *GameManager) Rematch(user user.Authorization, id GameID) (RematchResult, error) {
+	game, ok := m.games.Load(id)
+	if !ok {
+		return RematchResult{}, ErrNotFound
+	}
+
+	state, accepted, err := game.OfferRematch(user)
+	if errors.Is(err, ErrGameStopped) {
+		// The game is stopped once the rematch is created, but the rematch
+		// should still be returned.
+		if state := game.StateSnapshot(); state.RematchID != nil {
+			return RematchResult{Accepted: true, GameID: state.RematchID}, nil
+		}
+	}
+	if err != nil {
+		return RematchResult{}, err
+	}
+	if state.RematchID != nil {
+		return RematchResult{Accepted: true, GameID: state.RematchID}, nil
+	}
+	if !accepted {
+		return RematchResult{}, nil
+	}
+
+	newID, err := m.createRematch(state)
+	if err != nil {
+		game.cancelRematch()
+		return RematchResult{}, err
+	}
+
+	game.finishRematch(newID)
+	game.Stop(GoingAwayGameEnded)
+
+	m.logger.Info(
+		"rematch created",
+		"game_id", id,
+		"rematch_id", newID)
+
+	return RematchResult{Accepted: true, GameID: &newID}, nil
+}
+
+func (m *GameManager) createRematch(old GameState) (GameID, error) {
+	opts := old.Metadata
+	opts.Side = SideA
+	opts.AutoJoin = false
+
+	// The old player B is player A in the new game and vice versa.
+	id, err := m.CreateGame(*old.PlayerB, opts)
+	if err != nil {
+		return GameID{}, err
+	}
+
+	// Neither player can find the new game until it is returned, so discard it
+	// if they cannot both join it.
+	if _, err := m.JoinGame(*old.PlayerB, id, SideA); err != nil {
+		m.discardGame(id)
+		return GameID{}, err
+	}
+
+	if _, err := m.JoinGame(*old.PlayerA, id, SideB); err != nil {
+		m.discardGame(id)
+		return GameID{}, err
+	}
+
+	return id, nil
+}
+
+// QueryRatings queries the ratings of the user with the given user ID.
+func (m *GameManager) QueryRatings(id user.UserID) (UserRatings, error) {
+	if m.ratings == nil {
+		return UserRatings{}, nil
+	}
+	return m.ratings.QueryRatings(id)
+}
@@ -0,0 +1,445 @@
+package gameserver
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/internal/tracing"
+	"libdb.so/scouts-server/scouts"
+)
+
+func TestGameManagerPrivateGame(t *testing.T) {
+	games := NewGameManager(slogt.New(t), GameManagerOptions{})
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	publicID, err := games.CreateGame(user1, CreateGameOptions{})
+	assert.NoError(t, err)
+
+	privateID, err := games.CreateGame(user1, CreateGameOptions{Private: true})
+	assert.NoError(t, err)
+
+	listed := games.ListGames()
+	assert.Equal(t, 1, len(listed), "only the public game should be listed")
+	assert.Equal(t, publicID, listed[0].GameID)
+	assert.Zero(t, listed[0].InviteCode, "public game should not have an invite code")
+
+	state, err := games.QueryGame(privateID)
+	assert.NoError(t, err)
+	assert.Equal(t, InviteCodeLength, len(state.InviteCode))
+
+	assert.NoError(t, games.AuthorizeGame(user2, publicID, ""))
+	assert.NoError(t, games.AuthorizeGame(user1, privateID, ""), "creator should be able to access")
+	assert.IsError(t, games.AuthorizeGame(user2, privateID, ""), ErrNotFound)
+	assert.IsError(t, games.AuthorizeGame(user2, privateID, "AAAAAA"), ErrNotFound)
+	assert.NoError(t, games.AuthorizeGame(user2, privateID, state.InviteCode))
+
+	code, err := ParseInviteCode(strings.ToLower(string(state.InviteCode)))
+	assert.NoError(t, err, "invite codes should be case-insensitive")
+
+	id, err := games.ResolveInviteCode(code)
+	assert.NoError(t, err)
+	assert.Equal(t, privateID, id)
+
+	// Once joined, the player no longer needs the invite code.
+	_, err = games.JoinGame(user2, privateID, "")
+	assert.NoError(t, err)
+	assert.NoError(t, games.AuthorizeGame(user2, privateID, ""))
+}
+
+func TestGameManagerCreatorSide(t *testing.T) {
+	games := NewGameManager(slogt.New(t), GameManagerOptions{})
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	// Without a side, nothing is reserved for the creator.
+	id, err := games.CreateGame(user1, CreateGameOptions{})
+	assert.NoError(t, err)
+
+	side, err := games.JoinGame(user2, id, "")
+	assert.NoError(t, err)
+	assert.Equal(t, scouts.PlayerA, side)
+
+	side, err = games.JoinGame(user1, id, "")
+	assert.NoError(t, err)
+	assert.Equal(t, scouts.PlayerB, side)
+
+	// With a side, it is kept for the creator.
+	id, err = games.CreateGame(user1, CreateGameOptions{Side: SideA})
+	assert.NoError(t, err)
+
+	side, err = games.JoinGame(user2, id, "")
+	assert.NoError(t, err)
+	assert.Equal(t, scouts.PlayerB, side)
+}
+
+func TestGameManagerDiscardGame(t *testing.T) {
+	archive := newMemoryGameArchive()
+	games := NewGameManager(slogt.New(t), GameManagerOptions{GameArchive: archive})
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+
+	id, err := games.CreateGame(user1, CreateGameOptions{Private: true, AutoJoin: true})
+	assert.NoError(t, err)
+	state, err := games.QueryGame(id)
+	assert.NoError(t, err)
+
+	games.discardGame(id)
+	games.discardGame(id)
+
+	_, err = games.QueryGame(id)
+	assert.IsError(t, err, ErrNotFound)
+	_, err = games.ResolveInviteCode(state.InviteCode)
+	assert.IsError(t, err, ErrNotFound)
+	_, err = archive.QueryArchivedGame(id)
+	assert.IsError(t, err, ErrNotFound)
+}
+
+func TestGameManagerRematch(t *testing.T) {
+	games := NewGameManager(slogt.New(t), GameManagerOptions{})
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	opts := CreateGameOptions{
+		TimeLimit: Duration(10 * time.Minute),
+		Increment: Duration(5 * time.Second),
+	}
+
+	oldID, err := games.CreateGame(user1, opts)
+	assert.NoError(t, err)
+
+	_, err = games.JoinGame(user1, oldID, SideA)
+	assert.NoError(t, err)
+	_, err = games.JoinGame(user2, oldID, SideB)
+	assert.NoError(t, err)
+
+	_, err = games.Rematch(user1, oldID)
+	assert.IsError(t, err, ErrInvalidGameState, "rematch should require the game to end")
+
+	game, _ := games.games.Load(oldID)
+	game.do(func() { game.endGame(time.Now(), scouts.PlayerA) })
+
+	spectator := user.NewAnonymous(user.GenerateSessionToken())
+	events, err := games.SubscribeGame(spectator, oldID)
+	assert.NoError(t, err)
+	defer events.Close()
+
+	var playback []GameEvent
+	game.view(func() {
+		playback = append(
+			playbackPlayerJoinEvents(game, time.Time{}),
+			playbackGameEvents(game.state, time.Time{})...)
+	})
+	expectEvents(t, events, playback)
+
+	result, err := games.Rematch(user1, oldID)
+	assert.NoError(t, err)
+	assert.False(t, result.Accepted, "rematch should not be accepted until both players agree")
+
+	result, err = games.Rematch(user2, oldID)
+	assert.NoError(t, err)
+	assert.True(t, result.Accepted)
+
+	expectEvents(t, events, []GameEvent{
+		RematchOfferedEvent{PlayerSide: scouts.PlayerA},
+		RematchOfferedEvent{PlayerSide: scouts.PlayerB},
+		RematchAcceptedEvent{GameID: *result.GameID},
+		GoingAwayEvent{Reason: GoingAwayGameEnded},
+	})
+	assertSubscriptionClosed(t, events)
+
+	state, err := games.QueryGame(*result.GameID)
+	assert.NoError(t, err)
+	assert.Equal(t, opts.TimeLimit, state.Metadata.TimeLimit)
+	assert.Equal(t, opts.Increment, state.Metadata.Increment)
+	assert.True(t, user.AuthorizationEq(state.PlayerA, &user2), "sides should be swapped")
+	assert.True(t, user.AuthorizationEq(state.PlayerB, &user1), "sides should be swapped")
+	assert.NotZero(t, state.BeganAt, "rematch should have begun")
+
+	// Offering again returns the same rematch.
+	again, err := games.Rematch(user1, oldID)
+	assert.NoError(t, err)
+	assert.Equal(t, result, again)
+}
+
+func TestGameManagerGC(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	archive := newMemoryGameArchive()
+	games := NewGameManager(slogt.New(t), GameManagerOptions{
+		Clock:       clk,
+		GameArchive: archive,
+		GC: GCOptions{
+			Interval:  time.Minute,
+			Unstarted: 10 * time.Minute,
+			Running:   30 * time.Minute,
+			Finished:  5 * time.Minute,
+		},
+	})
+
+	stop := games.BeginGC()
+	defer stop()
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	newGame := func() GameID {
+		id, err := games.CreateGame(user1, CreateGameOptions{})
+		assert.NoError(t, err)
+		return id
+	}
+
+	beginGame := func() GameID {
+		id := newGame()
+		_, err := games.JoinGame(user1, id, SideA)
+		assert.NoError(t, err)
+		_, err = games.JoinGame(user2, id, SideB)
+		assert.NoError(t, err)
+		assert.NoError(t, games.MakeMove(context.Background(), user1, id, mustMove("place_scout 0,9"), MoveOptions{}))
+		return id
+	}
+
+	unstartedID := newGame()
+	runningID := beginGame()
+	finishedID := beginGame()
+
+	finished, _ := games.games.Load(finishedID)
+	finished.do(func() { finished.endGame(clk.Now(), scouts.PlayerA) })
+	finishedState := finished.StateSnapshot()
+
+	finishedEvents, err := games.SubscribeGame(user1, finishedID)
+	assert.NoError(t, err)
+	t.Cleanup(finishedEvents.Close)
+
+	clk.Advance(6 * time.Minute)
+	waitEvicted(games, finishedID)
+	expectEvents(t, finishedEvents, append(playbackEvents(&testingGameInstance{gameInstance: finished}),
+		GoingAwayEvent{Reason: GoingAwayGameEnded}))
+
+	state, err := games.QueryGame(finishedID)
+	assert.NoError(t, err, "finished games should be archived")
+	assert.Equal(t, finishedState.Moves, state.Moves)
+	assert.Equal(t, finishedState.Board, state.Board)
+	assert.NoError(t, games.AuthorizeGame(user2, finishedID, ""))
+
+	for _, id := range []GameID{unstartedID, runningID} {
+		_, err := games.QueryGame(id)
+		assert.NoError(t, err, "games should be kept until their retention is over")
+	}
+
+	clk.Advance(5 * time.Minute)
+	waitEvicted(games, unstartedID)
+
+	_, err = games.QueryGame(unstartedID)
+	assert.IsError(t, err, ErrNotFound, "unstarted games should not be archived")
+	_, err = games.QueryGame(runningID)
+	assert.NoError(t, err, "running games should be kept longer than unstarted games")
+
+	clk.Advance(20 * time.Minute)
+	waitEvicted(games, runningID)
+}
+
+// waitEvicted waits until the game has been evicted from memory.
+func waitEvicted(games *GameManager, id GameID) {
+	for {
+		if _, ok := games.games.Load(id); !ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGameManagerShutdown(t *testing.T) {
+	archive := newMemoryGameArchive()
+	games := NewGameManager(slogt.New(t), GameManagerOptions{GameArchive: archive})
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	id, err := games.CreateGame(user1, CreateGameOptions{AutoJoin: true})
+	assert.NoError(t, err)
+
+	runningID, err := games.CreateGame(user1, CreateGameOptions{AutoJoin: true})
+	assert.NoError(t, err)
+	_, err = games.JoinGame(user2, runningID, "")
+	assert.NoError(t, err)
+	assert.NoError(t, games.MakeMove(context.Background(), user1, runningID, mustMove("place_scout 0,9"), MoveOptions{}))
+
+	events, err := games.SubscribeGame(user1, id)
+	assert.NoError(t, err)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- games.Shutdown(context.Background()) }()
+
+	for {
+		ev, err := events.Next(context.Background())
+		assert.NoError(t, err)
+		if ev, ok := ev.(GoingAwayEvent); ok {
+			assert.Equal(t, GoingAwayServerShutdown, ev.Reason)
+			assert.False(t, ev.Reconnect, "the game cannot be rejoined after a shutdown")
+			break
+		}
+	}
+
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown should wait for subscribers to disconnect")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	events.Close()
+	assert.NoError(t, <-shutdown)
+
+	_, err = games.CreateGame(user1, CreateGameOptions{})
+	assert.IsError(t, err, ErrShuttingDown)
+
+	archived, err := archive.QueryArchivedGame(runningID)
+	assert.NoError(t, err, "games that are still being played should be archived")
+	assert.Zero(t, archived.EndedAt)
+	assert.Equal(t, 1, len(archived.Moves))
+
+	_, err = archive.QueryArchivedGame(id)
+	assert.IsError(t, err, ErrNotFound, "games that never began should not be archived")
+}
+
+func TestGameManagerObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	games := NewGameManager(slogt.New(t), GameManagerOptions{Observer: observer})
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	id, err := games.CreateGame(user1, CreateGameOptions{AutoJoin: true, Side: SideA})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(observer.beganGames()))
+
+	_, err = games.JoinGame(user2, id, "")
+	assert.NoError(t, err)
+	assert.NoError(t, games.MakeMove(context.Background(), user1, id, mustMove("place_scout 0,9"), MoveOptions{}))
+
+	began := observer.beganGames()
+	assert.Equal(t, 1, len(began), "game should begin once")
+	assert.Equal(t, id, began[0].GameID)
+	assert.NotZero(t, began[0].BeganAt)
+	assert.Equal(t, 0, len(began[0].Moves))
+}
+
+// recordingObserver is a GameObserver that records the games that began.
+type recordingObserver struct {
+	mu    sync.Mutex
+	began []GameState
+}
+
+func (o *recordingObserver) GameCreated(GameState) {}
+
+func (o *recordingObserver) GameBegan(state GameState, turn TurnBeginEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.began = append(o.began, state)
+}
+
+func (o *recordingObserver) GameEvents(GameID, []GameEvent, func() GameState) {}
+
+func (o *recordingObserver) beganGames() []GameState {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Clone(o.began)
+}
+
+func TestGameManagerMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	games := NewGameManager(slogt.New(t), GameManagerOptions{Metrics: registry})
+	metrics := games.metrics
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	id, err := games.CreateGame(user1, CreateGameOptions{AutoJoin: true, Side: SideA})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.games.WithLabelValues("unstarted")))
+
+	_, err = games.JoinGame(user2, id, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.games.WithLabelValues("unstarted")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.games.WithLabelValues("running")))
+
+	events, err := games.SubscribeGame(user1, id)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.subscribers))
+	events.Close()
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.subscribers))
+
+	err = games.MakeMove(context.Background(), user2, id, mustMove("place_scout 0,0"), MoveOptions{})
+	assert.IsError(t, err, ErrInvalidMove)
+	err = games.MakeMove(context.Background(), user1, id, mustMove("place_scout 0,0"), MoveOptions{})
+	assert.Error(t, err)
+	err = games.MakeMove(context.Background(), user1, GenerateGameID(), mustMove("place_scout 0,9"), MoveOptions{})
+	assert.IsError(t, err, ErrNotFound)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.moveFailures.WithLabelValues("not_your_turn")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.moveFailures.WithLabelValues("illegal_move")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.moveFailures.WithLabelValues("not_found")))
+
+	game, _ := games.games.Load(id)
+	game.do(func() { game.endGame(time.Now(), scouts.PlayerA) })
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.games.WithLabelValues("running")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.games.WithLabelValues("ended")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.gamesTotal.WithLabelValues("running")))
+
+	_, err = registry.Gather()
+	assert.NoError(t, err)
+}
+
+func TestGameManagerTracing(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	games := NewGameManager(slogt.New(t), GameManagerOptions{})
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	id, err := games.CreateGame(user1, CreateGameOptions{AutoJoin: true, Side: SideA})
+	assert.NoError(t, err)
+	_, err = games.JoinGame(user2, id, "")
+	assert.NoError(t, err)
+
+	err = games.MakeMove(context.Background(), user1, id, mustMove("place_scout 0,9"), MoveOptions{})
+	assert.NoError(t, err)
+
+	ended := spans.Ended()
+	names := make([]string, len(ended))
+	for i, span := range ended {
+		names[i] = span.Name()
+	}
+	assert.Equal(t, []string{
+		"scouts.Game.Apply",
+		"gameInstance.sendEvent",
+		"GameManager.MakeMove",
+	}, names)
+
+	root := ended[2]
+	assert.True(t, slices.Contains(root.Attributes(), tracing.GameIDKey.String(id.String())))
+	assert.True(t, slices.Contains(root.Attributes(), tracing.UserKey.String(user1.String())))
+	for _, span := range ended[:2] {
+		assert.Equal(t, root.SpanContext().TraceID(), span.SpanContext().TraceID())
+		assert.Equal(t, root.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+}
@@ -10,8 +10,16 @@ type gameTimer interface {
 	// Subtract subtracts the elapsed time from the player's remaining time.
 	// It returns whether the player has time remaining.
 	Subtract(now time.Time, player scouts.Player) (keepGoing bool)
+	// Elapse is like Subtract, except the increment is not added.
+	Elapse(now time.Time, player scouts.Player) (keepGoing bool)
 	// Remaining returns the remaining time for both players.
 	Remaining() [2]Duration
+	// RemainingAt returns the remaining time for the player at the given time
+	// if the player is the one whose clock is running. Unlike Subtract, it does
+	// not modify the timer.
+	RemainingAt(now time.Time, player scouts.Player) Duration
+	// Credit gives the player extra time.
+	Credit(player scouts.Player, d Duration)
 }
 
 func newGameTimer(now time.Time, timeLimit, increment Duration) gameTimer {
@@ -21,11 +29,6 @@ func newGameTimer(now time.Time, timeLimit, increment Duration) gameTimer {
 	return newRealGameTimer(now, timeLimit, increment)
 }
 
-func minRemaining(g gameTimer) Duration {
-	remaining := g.Remaining()
-	return min(remaining[0], remaining[1])
-}
-
 // nilGameTimer is a gameTimer that does nothing. It is used when the game
 // has no time limit.
 type nilGameTimer struct{}
@@ -34,6 +37,10 @@ func (nilGameTimer) Subtract(now time.Time, player scouts.Player) bool {
 	return true
 }
 
+func (nilGameTimer) Elapse(now time.Time, player scouts.Player) bool {
+	return true
+}
+
 func (nilGameTimer) Remaining() [2]Duration {
 	return [2]Duration{
 		InfiniteDuration,
@@ -41,6 +48,12 @@ func (nilGameTimer) Remaining() [2]Duration {
 	}
 }
 
+func (nilGameTimer) RemainingAt(now time.Time, player scouts.Player) Duration {
+	return InfiniteDuration
+}
+
+func (nilGameTimer) Credit(player scouts.Player, d Duration) {}
+
 // realGameTimer is a gameTimer that keeps track of the remaining time for
 // each player.
 type realGameTimer struct {
@@ -61,6 +74,14 @@ func newRealGameTimer(now time.Time, timeLimit, increment Duration) *realGameTim
 }
 
 func (g *realGameTimer) Subtract(now time.Time, player scouts.Player) (keepGoing bool) {
+	if !g.Elapse(now, player) {
+		return false
+	}
+	g.remaining[player-1] += g.increment
+	return true
+}
+
+func (g *realGameTimer) Elapse(now time.Time, player scouts.Player) (keepGoing bool) {
 	elapsed := now.Sub(g.lastTick)
 	g.lastTick = now
 
@@ -68,29 +89,19 @@ func (g *realGameTimer) Subtract(now time.Time, player scouts.Player) (keepGoing
 	if g.remaining[player-1] < 0 {
 		g.remaining[player-1] = 0
 		return false
-	} else {
-		g.remaining[player-1] += g.increment
-		return true
 	}
+	return true
 }
 
 func (g *realGameTimer) Remaining() [2]Duration {
 	return g.remaining
 }
 
-type customClock func() time.Time
-
-func (n customClock) Now() time.Time {
-	if n == nil {
-		return time.Now()
-	}
-	return n()
-}
-
-type fakeClock struct {
-	now time.Time
+func (g *realGameTimer) RemainingAt(now time.Time, player scouts.Player) Duration {
+	remaining := g.remaining[player-1] - Duration(now.Sub(g.lastTick))
+	return max(remaining, 0)
 }
 
-func (f *fakeClock) Clock() customClock {
-	return func() time.Time { return f.now }
+func (g *realGameTimer) Credit(player scouts.Player, d Duration) {
+	g.remaining[player-1] += d
 }
@@ -5,6 +5,9 @@ import (
 	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
 	"libdb.so/scouts-server/scouts"
 )
 
@@ -41,3 +44,39 @@ func TestRealGameTimer(t *testing.T) {
 		Duration(5*time.Second) + Duration(500*time.Millisecond),
 	}, timer.remaining)
 }
+
+func TestGameInstanceFlagFall(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := CreateGameOptions{TimeLimit: Duration(time.Minute)}
+
+	g := &testingGameInstance{
+		gameInstance: newGameInstance(opts, slogt.New(t), clk),
+		User1:        user.NewAuthorized(user.GenerateSessionToken(), 1),
+		User2:        user.NewAuthorized(user.GenerateSessionToken(), 2),
+	}
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+
+	events, _ := g.subscribe(t, g.User2)
+	expectEvents(t, events, playbackEvents(g))
+
+	clk.Advance(59 * time.Second)
+
+	// Wait for the game to check the clock and set its timer again.
+	for clk.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Zero(t, g.StateSnapshot().EndedAt, "player should still have time left")
+
+	clk.Advance(time.Second)
+
+	expectEvents(t, events, []GameEvent{
+		GameEndEvent{
+			Winner:        scouts.PlayerB,
+			TimeRemaining: [2]Duration{0, Duration(time.Minute)},
+		},
+	})
+	assert.Equal(t, scouts.PlayerB, g.StateSnapshot().Winner)
+}
@@ -0,0 +1,101 @@
+package gameserver
+
+import (
+	"errors"
+	"slices"
+
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/user"
+)
+
+// ErrMoveIndexMismatch is an error that is returned when a move is made with
+// an expected move index that doesn't match the number of moves in the game.
+var ErrMoveIndexMismatch = hrt.NewHTTPError(409, "move index does not match")
+
+// ErrIdempotencyKeyReused is an error that is returned when an idempotency key
+// is reused for a different request.
+var ErrIdempotencyKeyReused = hrt.NewHTTPError(422, "idempotency key was used for a different request")
+
+// maxIdempotencyKeys is the maximum number of idempotency keys that a game
+// remembers. The oldest keys are forgotten first.
+const maxIdempotencyKeys = 64
+
+// MoveOptions contains optional guards for making moves.
+type MoveOptions struct {
+	// MoveIndex is the number of moves that the client expects the game to
+	// have before the move is made. If it doesn't match, then
+	// ErrMoveIndexMismatch is returned and no move is made.
+	MoveIndex *int
+	// IdempotencyKey is a key chosen by the client to identify the request.
+	// If a request with the same key has already been made by the same
+	// session, then the original result is returned instead.
+	IdempotencyKey string
+}
+
+type idempotencyKey struct {
+	session user.SessionToken
+	key     string
+}
+
+type idempotentResult struct {
+	key     idempotencyKey
+	request string
+	err     error
+}
+
+// idempotencyCache remembers the results of the most recent requests made
+// with an idempotency key.
+type idempotencyCache struct {
+	results []idempotentResult
+}
+
+// lookup looks up the result of the request with the given key. The request
+// string identifies the request itself, so that a key reused for a different
+// request can be rejected. False is returned if the key is unknown.
+func (c *idempotencyCache) lookup(key idempotencyKey, request string) (bool, error) {
+	i := slices.IndexFunc(c.results, func(r idempotentResult) bool { return r.key == key })
+	if i == -1 {
+		return false, nil
+	}
+	if c.results[i].request != request {
+		return true, ErrIdempotencyKeyReused
+	}
+	return true, c.results[i].err
+}
+
+// store stores the result of the request with the given key. Errors that may
+// go away by themselves, such as it not being the player's turn yet, are not
+// stored, so that retrying the request actually tries again.
+func (c *idempotencyCache) store(key idempotencyKey, request string, err error) {
+	if !isPermanentMoveError(err) {
+		return
+	}
+	if len(c.results) >= maxIdempotencyKeys {
+		c.results = slices.Delete(c.results, 0, len(c.results)-maxIdempotencyKeys+1)
+	}
+	c.results = append(c.results, idempotentResult{
+		key:     key,
+		request: request,
+		err:     err,
+	})
+}
+
+// reset forgets the results of all requests.
+func (c *idempotencyCache) reset() {
+	c.results = nil
+}
+
+// isPermanentMoveError returns true if retrying a move request that failed
+// with err would fail the same way. A nil error is permanent, since the moves
+// have been made.
+func isPermanentMoveError(err error) bool {
+	switch {
+	case errors.Is(err, ErrMoveIndexMismatch),
+		errors.Is(err, ErrInvalidGameState),
+		errors.Is(err, errNotAPlayer),
+		errors.Is(err, errNotYourTurn):
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,60 @@
+package gameserver
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+)
+
+// inviteCodeAlphabet is the alphabet used for invite codes. Characters that
+// are easily confused with each other, such as 0 and O, are left out.
+const inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// InviteCodeLength is the length of an invite code.
+const InviteCodeLength = 6
+
+// InviteCode is a short code that can be shared with other players to let them
+// join or spectate a private game.
+type InviteCode string
+
+// GenerateInviteCode generates a new random invite code.
+func GenerateInviteCode() InviteCode {
+	var b [InviteCodeLength]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = inviteCodeAlphabet[int(b[i])%len(inviteCodeAlphabet)]
+	}
+	return InviteCode(b[:])
+}
+
+// ParseInviteCode parses an invite code. Invite codes are case-insensitive.
+func ParseInviteCode(code string) (InviteCode, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != InviteCodeLength {
+		return "", errors.New("invalid invite code length")
+	}
+	for _, r := range code {
+		if !strings.ContainsRune(inviteCodeAlphabet, r) {
+			return "", errors.New("invalid invite code character")
+		}
+	}
+	return InviteCode(code), nil
+}
+
+// UnmarshalText unmarshals the invite code from text. An empty text is
+// unmarshaled into an empty invite code.
+func (c *InviteCode) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*c = ""
+		return nil
+	}
+	code, err := ParseInviteCode(string(text))
+	if err != nil {
+		return err
+	}
+	*c = code
+	return nil
+}
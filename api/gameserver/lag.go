@@ -0,0 +1,135 @@
+package gameserver
+
+import (
+	"fmt"
+	"time"
+
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/scouts"
+)
+
+// ErrUnknownPing is an error that is returned when a ping is acknowledged
+// that was never sent or has already been forgotten.
+var ErrUnknownPing = hrt.NewHTTPError(400, "unknown ping ID")
+
+const (
+	// MaxLagCredit is the maximum time that a player is credited for their
+	// connection's latency per move.
+	MaxLagCredit = Duration(500 * time.Millisecond)
+	// DefaultMaxLagCompensation is the default maximum total time that a player
+	// is credited for their connection's latency per game.
+	DefaultMaxLagCompensation = Duration(5 * time.Second)
+)
+
+const (
+	// maxPendingPings is the maximum number of unacknowledged pings that are
+	// kept per player. Older pings are forgotten.
+	maxPendingPings = 8
+	// rttSmoothing is the weight of a new round-trip time sample.
+	rttSmoothing = 0.25
+)
+
+// lagEstimator estimates the round-trip time of a player's connection using
+// the time between sending a ping and receiving its acknowledgement.
+type lagEstimator struct {
+	pending []pendingPing
+	nextID  int
+	// rtt is the smoothed round-trip time. It is zero until the first ping is
+	// acknowledged.
+	rtt Duration
+}
+
+type pendingPing struct {
+	id     int
+	sentAt time.Time
+}
+
+// ping records a new ping sent at now and returns its ID.
+func (e *lagEstimator) ping(now time.Time) int {
+	e.nextID++
+	if len(e.pending) == maxPendingPings {
+		e.pending = e.pending[1:]
+	}
+	e.pending = append(e.pending, pendingPing{id: e.nextID, sentAt: now})
+	return e.nextID
+}
+
+// pong records the acknowledgement of the ping with the given ID.
+func (e *lagEstimator) pong(now time.Time, id int) error {
+	for i, p := range e.pending {
+		if p.id != id {
+			continue
+		}
+
+		// Any earlier pings were lost or acknowledged out of order, so
+		// they're no longer useful.
+		e.pending = e.pending[i+1:]
+
+		sample := Duration(max(now.Sub(p.sentAt), 0))
+		if e.rtt == 0 {
+			e.rtt = sample
+		} else {
+			e.rtt += Duration(rttSmoothing * float64(sample-e.rtt))
+		}
+		return nil
+	}
+	return ErrUnknownPing
+}
+
+// maxLagCompensation returns the maximum total lag compensation per player.
+// Zero is returned if lag compensation is disabled.
+func (o CreateGameOptions) maxLagCompensation() Duration {
+	switch {
+	case o.MaxLagCompensation < 0:
+		return 0
+	case o.MaxLagCompensation == 0:
+		return DefaultMaxLagCompensation
+	default:
+		return o.MaxLagCompensation
+	}
+}
+
+// Ping records a ping to the user's connection. The returned event must be
+// sent to that connection only.
+func (g *gameInstance) Ping(authorization user.Authorization) (PingEvent, error) {
+	now := g.clock.Now()
+
+	return doValue(g, func() (PingEvent, error) {
+		player := g.playerSide(authorization)
+		if player == scouts.PlayerNone {
+			return PingEvent{}, fmt.Errorf("%w: only players are pinged", ErrNotAllowed)
+		}
+
+		return PingEvent{
+			EventHeader: EventHeader{ServerTime: now},
+			PingID:      g.lag[player-1].ping(now),
+		}, nil
+	})
+}
+
+// Pong records the acknowledgement of a ping sent to the user's connection.
+func (g *gameInstance) Pong(authorization user.Authorization, pingID int) error {
+	now := g.clock.Now()
+
+	return g.doErr(func() error {
+		player := g.playerSide(authorization)
+		if player == scouts.PlayerNone {
+			return fmt.Errorf("%w: only players are pinged", ErrNotAllowed)
+		}
+
+		return g.lag[player-1].pong(now, pingID)
+	})
+}
+
+// lagCredit returns the time that the player should be credited for their
+// connection's latency if they move at now. The credit never exceeds the time
+// that the player is about to be charged, nor what is left of the player's
+// total lag compensation. It must be called on the game's goroutine.
+func (g *gameInstance) lagCredit(now time.Time, player scouts.Player) Duration {
+	limit := g.state.Metadata.maxLagCompensation() - g.lagCredited[player-1]
+
+	charged := g.timer.Remaining()[player-1] - g.timer.RemainingAt(now, player)
+	credit := min(g.lag[player-1].rtt, MaxLagCredit, charged, limit)
+	return max(credit, 0)
+}
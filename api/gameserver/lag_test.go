@@ -0,0 +1,162 @@
+package gameserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+)
+
+func TestLagEstimator(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var lag lagEstimator
+	id1 := lag.ping(now)
+	id2 := lag.ping(now.Add(time.Second))
+
+	assert.NoError(t, lag.pong(now.Add(1200*time.Millisecond), id2))
+	assert.Equal(t, Duration(200*time.Millisecond), lag.rtt)
+
+	assert.IsError(t, lag.pong(now.Add(2*time.Second), id1), ErrUnknownPing,
+		"pings older than an acknowledged ping should be forgotten")
+	assert.IsError(t, lag.pong(now.Add(2*time.Second), id2), ErrUnknownPing,
+		"pings should only be acknowledged once")
+
+	id3 := lag.ping(now.Add(2 * time.Second))
+	assert.NoError(t, lag.pong(now.Add(3*time.Second), id3))
+	assert.Equal(t, Duration(400*time.Millisecond), lag.rtt)
+
+	for i := 0; i < maxPendingPings+1; i++ {
+		lag.ping(now)
+	}
+	assert.Equal(t, maxPendingPings, len(lag.pending))
+}
+
+func TestGameInstanceLagCompensation(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := CreateGameOptions{
+		TimeLimit:          Duration(time.Minute),
+		MaxLagCompensation: Duration(300 * time.Millisecond),
+	}
+
+	g := &testingGameInstance{
+		gameInstance: newGameInstance(opts, slogt.New(t), clk),
+		User1:        user.NewAuthorized(user.GenerateSessionToken(), 1),
+		User2:        user.NewAuthorized(user.GenerateSessionToken(), 2),
+	}
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+	start := clk.Now()
+
+	pong := func(auth user.Authorization, rtt time.Duration) {
+		ping, err := g.Ping(auth)
+		assert.NoError(t, err)
+		clk.Advance(rtt)
+		assert.NoError(t, g.Pong(auth, ping.PingID))
+	}
+
+	pong(g.User1, 200*time.Millisecond)
+
+	clk.Set(start.Add(10 * time.Second))
+	g.move(t, g.User1, mustMove("place_scout 0,9"))
+
+	clk.Set(start.Add(20 * time.Second))
+	g.move(t, g.User2, mustMove("place_scout 0,0"))
+
+	// The smoothed round-trip time is now 400ms, but only 100ms of the cap is
+	// left.
+	pong(g.User1, time.Second)
+
+	clk.Set(start.Add(30 * time.Second))
+	g.move(t, g.User1, mustMove("place_scout 1,9"))
+
+	var remaining [2]Duration
+	g.view(func() { remaining = g.timer.Remaining() })
+
+	state := g.StateSnapshot()
+	assert.Equal(t, Duration(200*time.Millisecond), state.Moves[0].LagCredit)
+	assert.Equal(t, Duration(0), state.Moves[1].LagCredit)
+	assert.Equal(t, Duration(100*time.Millisecond), state.Moves[2].LagCredit)
+	assert.Equal(t,
+		[2]Duration{Duration(40300 * time.Millisecond), Duration(50 * time.Second)},
+		remaining)
+
+	// Replaying the moves should give the same clocks.
+	ply, err := plyState(state, len(state.Moves))
+	assert.NoError(t, err)
+	assert.Equal(t, remaining, ply.TimeRemaining)
+
+	_, err = g.Ping(user.NewAnonymous(user.GenerateSessionToken()))
+	assert.IsError(t, err, ErrNotAllowed, "spectators should not be pinged")
+}
+
+func TestGameInstanceLagCreditPerMove(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := CreateGameOptions{TimeLimit: Duration(time.Minute)}
+
+	g := &testingGameInstance{
+		gameInstance: newGameInstance(opts, slogt.New(t), clk),
+		User1:        user.NewAuthorized(user.GenerateSessionToken(), 1),
+		User2:        user.NewAuthorized(user.GenerateSessionToken(), 2),
+	}
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+	start := clk.Now()
+
+	ping, err := g.Ping(g.User1)
+	assert.NoError(t, err)
+	clk.Advance(2 * time.Second)
+	assert.NoError(t, g.Pong(g.User1, ping.PingID))
+
+	clk.Set(start.Add(3 * time.Second))
+	g.move(t, g.User1, mustMove("place_scout 0,9"))
+
+	state := g.StateSnapshot()
+	assert.Equal(t, MaxLagCredit, state.Moves[0].LagCredit)
+}
+
+func TestGameInstanceLagCreditTakeback(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := CreateGameOptions{
+		TimeLimit:          Duration(time.Minute),
+		MaxLagCompensation: Duration(300 * time.Millisecond),
+	}
+
+	g := &testingGameInstance{
+		gameInstance: newGameInstance(opts, slogt.New(t), clk),
+		User1:        user.NewAuthorized(user.GenerateSessionToken(), 1),
+		User2:        user.NewAuthorized(user.GenerateSessionToken(), 2),
+	}
+	t.Cleanup(g.stop)
+
+	g.join(t, g.User1)
+	g.join(t, g.User2)
+	start := clk.Now()
+
+	ping, err := g.Ping(g.User1)
+	assert.NoError(t, err)
+	clk.Advance(200 * time.Millisecond)
+	assert.NoError(t, g.Pong(g.User1, ping.PingID))
+
+	clk.Set(start.Add(10 * time.Second))
+	g.move(t, g.User1, mustMove("place_scout 0,9"))
+
+	assert.NoError(t, g.MakeOffer(g.User1, OfferTakeback))
+	assert.NoError(t, g.AcceptOffer(g.User2, OfferTakeback))
+
+	// Taking back the move doesn't give back its credit, so only 100ms of
+	// the cap is left.
+	clk.Set(start.Add(20 * time.Second))
+	g.move(t, g.User1, mustMove("place_scout 0,9"))
+
+	state := g.StateSnapshot()
+	assert.Equal(t, 1, len(state.Moves))
+	assert.Equal(t, Duration(100*time.Millisecond), state.Moves[0].LagCredit)
+}
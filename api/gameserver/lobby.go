@@ -0,0 +1,352 @@
+package gameserver
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/scouts"
+)
+
+// ErrSeekClosed is an error that is returned when a seek can no longer be
+// accepted or declined.
+var ErrSeekClosed = hrt.NewHTTPError(409, "seek is no longer open")
+
+// ErrNotAllowed is an error that is returned when a user is not allowed to
+// perform an action.
+var ErrNotAllowed = hrt.NewHTTPError(403, "not allowed")
+
+// SeekTTL is how long a seek stays in the lobby before it expires.
+const SeekTTL = 15 * time.Minute
+
+// SeekStatus is the status of a seek.
+type SeekStatus string
+
+const (
+	// SeekOpen means that the seek can still be accepted.
+	SeekOpen SeekStatus = "open"
+	// SeekAccepted means that the seek has been accepted and a game has been
+	// created.
+	SeekAccepted SeekStatus = "accepted"
+	// SeekDeclined means that the challenged user has declined the seek.
+	SeekDeclined SeekStatus = "declined"
+	// SeekCancelled means that the creator has cancelled the seek.
+	SeekCancelled SeekStatus = "cancelled"
+)
+
+// SeekOptions contains options for creating a seek.
+type SeekOptions struct {
+	// TimeLimit is the time limit per side.
+	TimeLimit Duration
+	// Increment is the time increment per move.
+	Increment Duration
+	// Side is the side that the creator would like to play.
+	Side SidePreference
+	// Rated is true if the game should be rated.
+	Rated bool
+	// Challenged is the user being challenged. If nil, then the seek is an
+	// open challenge that anyone can accept.
+	Challenged *user.UserID
+}
+
+// Seek is a challenge to play a game. A seek without a challenged user is
+// listed in the lobby for anyone to accept.
+type Seek struct {
+	// ID is the ID of the seek.
+	ID SeekID `json:"id"`
+	// Creator is the user that created the seek.
+	Creator user.Authorization `json:"creator"`
+	// Challenged is the user being challenged.
+	// If nil, then anyone can accept the seek.
+	Challenged *user.UserID `json:"challenged"`
+	// TimeLimit is the time limit per side.
+	TimeLimit Duration `json:"time_limit"`
+	// Increment is the time increment per move.
+	Increment Duration `json:"increment"`
+	// Side is the side that the creator would like to play.
+	Side SidePreference `json:"side"`
+	// Rated is true if the game should be rated.
+	Rated bool `json:"rated"`
+	// Status is the status of the seek.
+	Status SeekStatus `json:"status"`
+	// GameID is the ID of the game created from the seek.
+	// If nil, then the seek has not been accepted.
+	GameID *GameID `json:"game_id"`
+	// CreatedAt is the time that the seek was created.
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is the time that the seek expires.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// visibleTo returns true if the seek can be seen by the given user. Direct
+// challenges can only be seen by the creator and the challenged user.
+func (s Seek) visibleTo(viewer user.Authorization) bool {
+	return s.Challenged == nil || s.Creator.Eq(viewer) || s.challenges(viewer)
+}
+
+// SeekAcceptedResult is the result of accepting a seek.
+type SeekAcceptedResult struct {
+	// GameID is the ID of the created game.
+	GameID GameID `json:"game_id"`
+	// PlayerSide is the side that the accepting player has been assigned.
+	PlayerSide scouts.Player `json:"player_side"`
+}
+
+// Lobby keeps track of seeks and direct challenges.
+type Lobby struct {
+	games  *GameManager
+	logger *slog.Logger
+	clock  clock.Clock
+
+	mu    sync.Mutex
+	seeks map[SeekID]*Seek
+}
+
+// NewLobby creates a new lobby that creates games using the given game
+// manager. It tells the time using the clock of the game manager.
+func NewLobby(games *GameManager, logger *slog.Logger) *Lobby {
+	return &Lobby{
+		games:  games,
+		logger: logger.With("component", "api/gameserver/lobby"),
+		clock:  games.clock,
+		seeks:  make(map[SeekID]*Seek),
+	}
+}
+
+// CreateSeek creates a new seek.
+func (l *Lobby) CreateSeek(creator user.Authorization, opts SeekOptions) (Seek, error) {
+	if err := opts.Side.Validate(); err != nil {
+		return Seek{}, hrt.WrapHTTPError(400, err)
+	}
+
+	if opts.Side == "" {
+		opts.Side = SideRandom
+	}
+
+	if opts.Challenged != nil && creator.UserID != nil && *opts.Challenged == *creator.UserID {
+		return Seek{}, fmt.Errorf("%w: cannot challenge yourself", ErrNotAllowed)
+	}
+
+	now := l.clock.Now()
+	seek := &Seek{
+		ID:         GenerateSeekID(),
+		Creator:    creator,
+		Challenged: opts.Challenged,
+		TimeLimit:  opts.TimeLimit,
+		Increment:  opts.Increment,
+		Side:       opts.Side,
+		Rated:      opts.Rated,
+		Status:     SeekOpen,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(SeekTTL),
+	}
+
+	l.mu.Lock()
+	l.pruneExpired(now)
+	l.seeks[seek.ID] = seek
+	l.mu.Unlock()
+
+	l.logger.Debug(
+		"seek created",
+		"seek_id", seek.ID,
+		"creator", creator,
+		"challenged", seek.Challenged)
+
+	return *seek, nil
+}
+
+// ListSeeks lists all open seeks that are visible to the given user, oldest
+// first.
+func (l *Lobby) ListSeeks(viewer user.Authorization) []Seek {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneExpired(l.clock.Now())
+
+	seeks := make([]Seek, 0, len(l.seeks))
+	for _, seek := range l.seeks {
+		if seek.Status == SeekOpen && seek.visibleTo(viewer) {
+			seeks = append(seeks, *seek)
+		}
+	}
+
+	slices.SortFunc(seeks, func(a, b Seek) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return seeks
+}
+
+// QuerySeek queries the seek with the given ID. Seeks that are no longer open
+// can still be queried until they expire, which allows the creator to find
+// out the game that was created from it.
+func (l *Lobby) QuerySeek(viewer user.Authorization, id SeekID) (Seek, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seek, err := l.loadSeek(viewer, id)
+	if err != nil {
+		return Seek{}, err
+	}
+
+	return *seek, nil
+}
+
+// CancelSeek cancels the seek with the given ID. Only the creator can cancel
+// a seek.
+func (l *Lobby) CancelSeek(creator user.Authorization, id SeekID) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seek, err := l.loadSeek(creator, id)
+	if err != nil {
+		return err
+	}
+
+	if !seek.Creator.Eq(creator) {
+		return fmt.Errorf("%w: only the creator can cancel a seek", ErrNotAllowed)
+	}
+
+	if seek.Status != SeekOpen {
+		return ErrSeekClosed
+	}
+
+	seek.Status = SeekCancelled
+	return nil
+}
+
+// DeclineSeek declines the direct challenge with the given ID. Only the
+// challenged user can decline a challenge.
+func (l *Lobby) DeclineSeek(challenged user.Authorization, id SeekID) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seek, err := l.loadSeek(challenged, id)
+	if err != nil {
+		return err
+	}
+
+	if !seek.challenges(challenged) {
+		return fmt.Errorf("%w: only the challenged user can decline", ErrNotAllowed)
+	}
+
+	if seek.Status != SeekOpen {
+		return ErrSeekClosed
+	}
+
+	seek.Status = SeekDeclined
+	return nil
+}
+
+// AcceptSeek accepts the seek with the given ID. A game is created and both
+// players are seated on their sides.
+func (l *Lobby) AcceptSeek(acceptor user.Authorization, id SeekID) (SeekAcceptedResult, error) {
+	l.mu.Lock()
+
+	seek, err := l.loadSeek(acceptor, id)
+	if err != nil {
+		l.mu.Unlock()
+		return SeekAcceptedResult{}, err
+	}
+
+	if seek.Creator.SameUser(acceptor) {
+		l.mu.Unlock()
+		return SeekAcceptedResult{}, fmt.Errorf("%w: cannot accept your own seek", ErrNotAllowed)
+	}
+
+	if seek.Challenged != nil && !seek.challenges(acceptor) {
+		l.mu.Unlock()
+		return SeekAcceptedResult{}, fmt.Errorf("%w: seek is for another user", ErrNotAllowed)
+	}
+
+	if seek.Status != SeekOpen {
+		l.mu.Unlock()
+		return SeekAcceptedResult{}, ErrSeekClosed
+	}
+
+	// Mark the seek as accepted before we release the lock so that nobody else
+	// can accept it while we're creating the game.
+	seek.Status = SeekAccepted
+	accepted := *seek
+	l.mu.Unlock()
+
+	gameID, acceptorSide, err := l.createGame(accepted, acceptor)
+	if err != nil {
+		l.mu.Lock()
+		seek.Status = SeekOpen
+		l.mu.Unlock()
+		return SeekAcceptedResult{}, err
+	}
+
+	l.mu.Lock()
+	seek.GameID = &gameID
+	l.mu.Unlock()
+
+	l.logger.Debug(
+		"seek accepted",
+		"seek_id", seek.ID,
+		"game_id", gameID,
+		"creator", seek.Creator,
+		"acceptor", acceptor)
+
+	return SeekAcceptedResult{
+		GameID:     gameID,
+		PlayerSide: acceptorSide,
+	}, nil
+}
+
+func (l *Lobby) createGame(seek Seek, acceptor user.Authorization) (GameID, scouts.Player, error) {
+	creatorSide := seek.Side.Resolve()
+
+	gameID, err := l.games.CreateGame(seek.Creator, CreateGameOptions{
+		TimeLimit: seek.TimeLimit,
+		Increment: seek.Increment,
+		Rated:     seek.Rated,
+		Side:      sidePreferenceOf(creatorSide),
+		AutoJoin:  true,
+	})
+	if err != nil {
+		return GameID{}, 0, err
+	}
+
+	acceptorSide, err := l.games.JoinGame(acceptor, gameID, sidePreferenceOf(creatorSide.Opponent()))
+	if err != nil {
+		// Don't leave the creator alone in the game, since the seek is
+		// reopened.
+		l.games.discardGame(gameID)
+		return GameID{}, 0, err
+	}
+
+	return gameID, acceptorSide, nil
+}
+
+// loadSeek loads the seek with the given ID. l.mu must be held.
+func (l *Lobby) loadSeek(viewer user.Authorization, id SeekID) (*Seek, error) {
+	l.pruneExpired(l.clock.Now())
+
+	seek, ok := l.seeks[id]
+	if !ok || !seek.visibleTo(viewer) {
+		return nil, ErrNotFound
+	}
+
+	return seek, nil
+}
+
+// pruneExpired removes all expired seeks. l.mu must be held.
+func (l *Lobby) pruneExpired(now time.Time) {
+	for id, seek := range l.seeks {
+		if now.After(seek.ExpiresAt) {
+			delete(l.seeks, id)
+		}
+	}
+}
+
+// challenges returns true if the seek directly challenges the given user.
+func (s Seek) challenges(u user.Authorization) bool {
+	return s.Challenged != nil && u.UserID != nil && *u.UserID == *s.Challenged
+}
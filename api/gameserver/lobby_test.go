@@ -0,0 +1,100 @@
+package gameserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/scouts"
+)
+
+func TestLobbySeek(t *testing.T) {
+	logger := slogt.New(t)
+	games := NewGameManager(logger, GameManagerOptions{})
+	lobby := NewLobby(games, logger)
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+	user3 := user.NewAuthorized(user.GenerateSessionToken(), 3)
+
+	seek, err := lobby.CreateSeek(user1, SeekOptions{Side: SideB})
+	assert.NoError(t, err)
+	assert.Equal(t, []Seek{seek}, lobby.ListSeeks(user2))
+
+	_, err = lobby.AcceptSeek(user1, seek.ID)
+	assert.IsError(t, err, ErrNotAllowed, "creator should not be able to accept their own seek")
+	_, err = lobby.AcceptSeek(user.NewAuthorized(user.GenerateSessionToken(), 1), seek.ID)
+	assert.IsError(t, err, ErrNotAllowed, "creator should not be able to accept their own seek from another session")
+
+	result, err := lobby.AcceptSeek(user2, seek.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, scouts.PlayerA, result.PlayerSide)
+
+	_, err = lobby.AcceptSeek(user3, seek.ID)
+	assert.IsError(t, err, ErrSeekClosed, "seek should not be accepted twice")
+	assert.Equal(t, 0, len(lobby.ListSeeks(user3)), "accepted seek should not be listed")
+
+	seek, err = lobby.QuerySeek(user1, seek.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, SeekAccepted, seek.Status)
+	assert.Equal(t, &result.GameID, seek.GameID)
+
+	state, err := games.QueryGame(result.GameID)
+	assert.NoError(t, err)
+	assert.True(t, user.AuthorizationEq(state.PlayerA, &user2), "acceptor should be player A")
+	assert.True(t, user.AuthorizationEq(state.PlayerB, &user1), "creator should be player B")
+}
+
+func TestLobbyChallenge(t *testing.T) {
+	logger := slogt.New(t)
+	games := NewGameManager(logger, GameManagerOptions{})
+	lobby := NewLobby(games, logger)
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+	user3 := user.NewAuthorized(user.GenerateSessionToken(), 3)
+
+	challenge, err := lobby.CreateSeek(user1, SeekOptions{Challenged: user2.UserID})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(lobby.ListSeeks(user2)), "challenged user should see the challenge")
+	assert.Equal(t, 0, len(lobby.ListSeeks(user3)), "other users should not see the challenge")
+
+	_, err = lobby.AcceptSeek(user3, challenge.ID)
+	assert.IsError(t, err, ErrNotFound, "other users should not be able to accept the challenge")
+
+	err = lobby.DeclineSeek(user1, challenge.ID)
+	assert.IsError(t, err, ErrNotAllowed, "creator should not be able to decline")
+
+	err = lobby.DeclineSeek(user2, challenge.ID)
+	assert.NoError(t, err)
+
+	_, err = lobby.AcceptSeek(user2, challenge.ID)
+	assert.IsError(t, err, ErrSeekClosed, "declined challenge should not be accepted")
+}
+
+func TestLobbySeekExpiry(t *testing.T) {
+	logger := slogt.New(t)
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	games := NewGameManager(logger, GameManagerOptions{Clock: clk})
+	lobby := NewLobby(games, logger)
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	seek, err := lobby.CreateSeek(user1, SeekOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, clk.Now().Add(SeekTTL), seek.ExpiresAt)
+
+	clk.Advance(SeekTTL)
+	assert.Equal(t, []Seek{seek}, lobby.ListSeeks(user2), "seek should not have expired yet")
+
+	clk.Advance(time.Second)
+	assert.Equal(t, 0, len(lobby.ListSeeks(user2)), "expired seek should not be listed")
+
+	_, err = lobby.AcceptSeek(user2, seek.ID)
+	assert.IsError(t, err, ErrNotFound, "expired seek should not be accepted")
+}
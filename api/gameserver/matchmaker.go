@@ -0,0 +1,319 @@
+package gameserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/internal/glicko2"
+	"libdb.so/scouts-server/scouts"
+)
+
+// ErrAlreadyQueued is an error that is returned when a session is already
+// waiting in the matchmaking queue.
+var ErrAlreadyQueued = hrt.NewHTTPError(409, "already in matchmaking queue")
+
+const (
+	// DefaultRatingRange is the initial rating range used when the player
+	// doesn't specify one.
+	DefaultRatingRange = 100
+	// MaxRatingRange is the widest that a rating range can grow to.
+	MaxRatingRange = 1000
+	// RatingRangeGrowth is how much the rating range widens for every second
+	// that a player spends waiting in the queue.
+	RatingRangeGrowth = 10
+)
+
+const matchmakingInterval = 1 * time.Second
+
+// MatchmakingOptions describes the kind of game that a player is looking for.
+type MatchmakingOptions struct {
+	// TimeLimit is the time limit per side. Players are only paired with
+	// players that want the same time control.
+	TimeLimit Duration
+	// Increment is the time increment per move.
+	Increment Duration
+	// Rated is true if the player wants a rated game.
+	Rated bool
+	// RatingRange is the maximum rating difference that the player initially
+	// accepts. It widens the longer the player waits.
+	// If zero, then DefaultRatingRange is used.
+	RatingRange float64
+}
+
+// MatchmakingEvent is an event that is sent to a player waiting in the
+// matchmaking queue.
+type MatchmakingEvent interface {
+	// Type returns the type of the matchmaking event.
+	Type() string
+}
+
+// MatchmakingQueuedEvent is an event that is emitted when a player enters the
+// matchmaking queue.
+type MatchmakingQueuedEvent struct {
+	// Rating is the rating that the player is matched with.
+	Rating float64 `json:"rating"`
+	// RatingRange is the initial rating range of the player.
+	RatingRange float64 `json:"rating_range"`
+}
+
+// MatchFoundEvent is an event that is emitted when a player has been paired
+// with another player. The player has already joined the game.
+type MatchFoundEvent struct {
+	// GameID is the ID of the new game.
+	GameID GameID `json:"game_id"`
+	// PlayerSide is the side that the player has been assigned.
+	PlayerSide scouts.Player `json:"player_side"`
+}
+
+func (MatchmakingQueuedEvent) Type() string { return "matchmaking_queued" }
+func (MatchFoundEvent) Type() string        { return "match_found" }
+
+// Matchmaker pairs up players that are waiting for a game.
+type Matchmaker struct {
+	games  *GameManager
+	logger *slog.Logger
+	clock  clock.Clock
+
+	mu    sync.Mutex
+	queue []*matchmakingTicket
+}
+
+type matchmakingTicket struct {
+	user     user.Authorization
+	opts     MatchmakingOptions
+	rating   float64
+	joinedAt time.Time
+	events   chan MatchmakingEvent
+	// cancelled is true once the player has left the queue, after which the
+	// ticket must never be queued again. It is guarded by Matchmaker.mu.
+	cancelled bool
+}
+
+// ratingRange returns the rating range of the ticket at the given time.
+func (t *matchmakingTicket) ratingRange(now time.Time) float64 {
+	waited := now.Sub(t.joinedAt).Seconds()
+	return min(t.opts.RatingRange+waited*RatingRangeGrowth, MaxRatingRange)
+}
+
+// compatible returns true if the two tickets can be paired at the given time.
+// Both players must accept each other's rating.
+func (t *matchmakingTicket) compatible(other *matchmakingTicket, now time.Time) bool {
+	if t.user.SameUser(other.user) {
+		return false
+	}
+	if t.opts.TimeLimit != other.opts.TimeLimit ||
+		t.opts.Increment != other.opts.Increment ||
+		t.opts.Rated != other.opts.Rated {
+		return false
+	}
+	diff := math.Abs(t.rating - other.rating)
+	return diff <= t.ratingRange(now) && diff <= other.ratingRange(now)
+}
+
+// NewMatchmaker creates a new matchmaker that creates games using the given
+// game manager. It tells the time using the clock of the game manager.
+func NewMatchmaker(games *GameManager, logger *slog.Logger) *Matchmaker {
+	return &Matchmaker{
+		games:  games,
+		logger: logger.With("component", "api/gameserver/matchmaker"),
+		clock:  games.clock,
+	}
+}
+
+// BeginMatching starts a background goroutine that periodically tries to pair
+// waiting players as their rating ranges widen.
+func (m *Matchmaker) BeginMatching() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ticker := m.clock.NewTicker(matchmakingInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				m.match()
+			}
+		}
+	}()
+	return cancel
+}
+
+// Enqueue puts the user in the matchmaking queue. The returned channel first
+// receives a MatchmakingQueuedEvent, then a MatchFoundEvent once the user has
+// been paired, after which it is closed. Calling the returned function removes
+// the user from the queue if they haven't been paired yet.
+func (m *Matchmaker) Enqueue(user user.Authorization, opts MatchmakingOptions) (<-chan MatchmakingEvent, func(), error) {
+	if opts.RatingRange <= 0 {
+		opts.RatingRange = DefaultRatingRange
+	}
+
+	rating := glicko2.Default.Rating
+	if user.UserID != nil {
+		ratings, err := m.games.QueryRatings(*user.UserID)
+		if err != nil {
+			return nil, nil, err
+		}
+		category := RatingCategoryFor(opts.TimeLimit, opts.Increment)
+		if r, ok := ratings[category]; ok {
+			rating = r.Current.Rating
+		}
+	}
+
+	ticket := &matchmakingTicket{
+		user:     user,
+		opts:     opts,
+		rating:   rating,
+		joinedAt: m.clock.Now(),
+		// Buffer enough for both events so that we never block on the client.
+		events: make(chan MatchmakingEvent, 2),
+	}
+
+	// Send this before the ticket is queued, since the ticket may be paired
+	// by another goroutine right after.
+	ticket.events <- MatchmakingQueuedEvent{
+		Rating:      rating,
+		RatingRange: opts.RatingRange,
+	}
+
+	m.mu.Lock()
+	for _, queued := range m.queue {
+		if queued.user.Eq(user) {
+			m.mu.Unlock()
+			return nil, nil, ErrAlreadyQueued
+		}
+	}
+	m.queue = append(m.queue, ticket)
+	m.mu.Unlock()
+
+	m.logger.Debug(
+		"player entered matchmaking queue",
+		"user", user,
+		"rating", rating,
+		"rating_range", opts.RatingRange)
+
+	m.match()
+
+	return ticket.events, func() { m.dequeue(ticket) }, nil
+}
+
+func (m *Matchmaker) dequeue(ticket *matchmakingTicket) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The ticket may be out of the queue while a game is being created for
+	// it, so make sure that it isn't put back if that fails.
+	ticket.cancelled = true
+
+	i := slices.Index(m.queue, ticket)
+	if i == -1 {
+		return
+	}
+	m.queue = slices.Delete(m.queue, i, i+1)
+
+	m.logger.Debug(
+		"player left matchmaking queue",
+		"user", ticket.user)
+}
+
+// match pairs up as many compatible players as possible. Players that have
+// waited the longest are paired first.
+func (m *Matchmaker) match() {
+	now := m.clock.Now()
+
+	var pairs [][2]*matchmakingTicket
+
+	m.mu.Lock()
+	for i := 0; i < len(m.queue); i++ {
+		for j := i + 1; j < len(m.queue); j++ {
+			if !m.queue[i].compatible(m.queue[j], now) {
+				continue
+			}
+			pairs = append(pairs, [2]*matchmakingTicket{m.queue[i], m.queue[j]})
+			m.queue = slices.Delete(m.queue, j, j+1)
+			m.queue = slices.Delete(m.queue, i, i+1)
+			i--
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	for _, pair := range pairs {
+		m.startGame(pair)
+	}
+}
+
+func (m *Matchmaker) startGame(pair [2]*matchmakingTicket) {
+	// Randomly assign sides.
+	if randomSide() == scouts.PlayerB {
+		pair[0], pair[1] = pair[1], pair[0]
+	}
+
+	gameID, err := m.games.CreateGame(pair[0].user, CreateGameOptions{
+		TimeLimit: pair[0].opts.TimeLimit,
+		Increment: pair[0].opts.Increment,
+		Rated:     pair[0].opts.Rated,
+	})
+	if err == nil {
+		if _, err = m.games.JoinGame(pair[0].user, gameID, SideA); err == nil {
+			_, err = m.games.JoinGame(pair[1].user, gameID, SideB)
+		}
+		if err != nil {
+			// Don't leave the half-joined game behind.
+			m.games.discardGame(gameID)
+		}
+	}
+	if err != nil {
+		m.logger.Error(
+			"cannot create matchmade game",
+			"player_a", pair[0].user,
+			"player_b", pair[1].user,
+			"err", err)
+
+		m.requeue(pair, !errors.Is(err, ErrShuttingDown))
+		return
+	}
+
+	m.logger.Info(
+		"matched players",
+		"game_id", gameID,
+		"player_a", pair[0].user,
+		"player_b", pair[1].user)
+
+	for i, ticket := range pair {
+		ticket.events <- MatchFoundEvent{
+			GameID:     gameID,
+			PlayerSide: scouts.Player(i + 1),
+		}
+		close(ticket.events)
+	}
+}
+
+// requeue puts the players of a pair that could not be started back at the
+// front of the queue so that they can try again, unless they have left the
+// queue in the meantime. If retry is false, then the players are told that
+// matchmaking is over by closing their events instead.
+func (m *Matchmaker) requeue(pair [2]*matchmakingTicket, retry bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var requeued []*matchmakingTicket
+	for _, ticket := range pair {
+		switch {
+		case ticket.cancelled:
+		case retry:
+			requeued = append(requeued, ticket)
+		default:
+			close(ticket.events)
+		}
+	}
+	m.queue = append(requeued, m.queue...)
+}
@@ -0,0 +1,191 @@
+package gameserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/internal/glicko2"
+	"libdb.so/scouts-server/scouts"
+)
+
+func TestMatchmaker(t *testing.T) {
+	logger := slogt.New(t)
+	games := NewGameManager(logger, GameManagerOptions{})
+	matchmaker := NewMatchmaker(games, logger)
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+	opts := MatchmakingOptions{TimeLimit: Duration(time.Minute)}
+
+	ev1, stop1, err := matchmaker.Enqueue(user1, opts)
+	assert.NoError(t, err)
+	t.Cleanup(stop1)
+
+	_, _, err = matchmaker.Enqueue(user1, opts)
+	assert.IsError(t, err, ErrAlreadyQueued)
+
+	ev2, stop2, err := matchmaker.Enqueue(user2, opts)
+	assert.NoError(t, err)
+	t.Cleanup(stop2)
+
+	found1 := expectMatchFound(t, ev1)
+	found2 := expectMatchFound(t, ev2)
+
+	assert.Equal(t, found1.GameID, found2.GameID, "players should be in the same game")
+	assert.NotEqual(t, found1.PlayerSide, found2.PlayerSide, "players should be on opposite sides")
+
+	state, err := games.QueryGame(found1.GameID)
+	assert.NoError(t, err)
+	assert.NotZero(t, state.BeganAt, "matchmade game should have begun")
+
+	players := map[scouts.Player]*user.Authorization{
+		scouts.PlayerA: state.PlayerA,
+		scouts.PlayerB: state.PlayerB,
+	}
+	assert.True(t, user.AuthorizationEq(players[found1.PlayerSide], &user1))
+	assert.True(t, user.AuthorizationEq(players[found2.PlayerSide], &user2))
+}
+
+func TestMatchmakerRatingRange(t *testing.T) {
+	logger := slogt.New(t)
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ratings := newMemoryRatingStorage()
+	games := NewGameManager(logger, GameManagerOptions{RatingStorage: ratings, Clock: clk})
+	matchmaker := NewMatchmaker(games, logger)
+
+	stop := matchmaker.BeginMatching()
+	t.Cleanup(stop)
+
+	rating := glicko2.Default
+	rating.Rating += 200
+	setRating(t, ratings, 2, RatingBullet, rating)
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+	opts := MatchmakingOptions{TimeLimit: Duration(time.Minute), RatingRange: 100}
+
+	ev1, stop1, err := matchmaker.Enqueue(user1, opts)
+	assert.NoError(t, err)
+	t.Cleanup(stop1)
+
+	ev2, stop2, err := matchmaker.Enqueue(user2, opts)
+	assert.NoError(t, err)
+	t.Cleanup(stop2)
+
+	assert.Equal(t, "matchmaking_queued", (<-ev1).Type())
+	assert.Equal(t, "matchmaking_queued", (<-ev2).Type())
+
+	clk.Advance(9 * time.Second)
+	select {
+	case ev := <-ev1:
+		t.Fatalf("players should not be paired before their ranges widen, got %T", ev)
+	default:
+	}
+
+	clk.Advance(time.Second)
+	found1 := expectMatchFound(t, ev1)
+	found2 := expectMatchFound(t, ev2)
+	assert.Equal(t, found1.GameID, found2.GameID, "players should be paired once their ranges widen")
+}
+
+func TestMatchmakerShutdown(t *testing.T) {
+	logger := slogt.New(t)
+	games := NewGameManager(logger, GameManagerOptions{})
+	matchmaker := NewMatchmaker(games, logger)
+	assert.NoError(t, games.Shutdown(context.Background()))
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+	opts := MatchmakingOptions{TimeLimit: Duration(time.Minute)}
+
+	ev1, stop1, err := matchmaker.Enqueue(user1, opts)
+	assert.NoError(t, err)
+	t.Cleanup(stop1)
+	ev2, stop2, err := matchmaker.Enqueue(user2, opts)
+	assert.NoError(t, err)
+	t.Cleanup(stop2)
+
+	// No game can be created, so the players are not put back in the queue
+	// to be paired again every tick.
+	for _, events := range []<-chan MatchmakingEvent{ev1, ev2} {
+		_, ok := (<-events).(MatchmakingQueuedEvent)
+		assert.True(t, ok)
+		_, ok = <-events
+		assert.False(t, ok, "events should be closed")
+	}
+	assert.Equal(t, 0, len(matchmaker.queue))
+}
+
+func TestMatchmakerRequeueCancelled(t *testing.T) {
+	logger := slogt.New(t)
+	matchmaker := NewMatchmaker(NewGameManager(logger, GameManagerOptions{}), logger)
+
+	ticket1 := &matchmakingTicket{user: user.NewAuthorized(user.GenerateSessionToken(), 1)}
+	ticket2 := &matchmakingTicket{user: user.NewAuthorized(user.GenerateSessionToken(), 2)}
+
+	// The first player leaves while a game is being created for the pair.
+	matchmaker.dequeue(ticket1)
+	matchmaker.requeue([2]*matchmakingTicket{ticket1, ticket2}, true)
+
+	assert.Equal(t, []*matchmakingTicket{ticket2}, matchmaker.queue, "cancelled ticket should not be queued again")
+}
+
+func TestMatchmakingTicketCompatible(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ticket1 := &matchmakingTicket{
+		user:     user.NewAnonymous(user.GenerateSessionToken()),
+		opts:     MatchmakingOptions{RatingRange: 100},
+		rating:   1500,
+		joinedAt: now,
+	}
+	ticket2 := &matchmakingTicket{
+		user:     user.NewAnonymous(user.GenerateSessionToken()),
+		opts:     MatchmakingOptions{RatingRange: 100},
+		rating:   1700,
+		joinedAt: now,
+	}
+
+	assert.False(t, ticket1.compatible(ticket2, now), "ratings are too far apart")
+	assert.True(t, ticket1.compatible(ticket2, now.Add(10*time.Second)), "rating range should widen")
+
+	ticket2.opts.Increment = Duration(time.Second)
+	assert.False(t, ticket1.compatible(ticket2, now.Add(10*time.Second)), "time controls differ")
+
+	ticket3 := &matchmakingTicket{
+		user:     user.NewAuthorized(user.GenerateSessionToken(), 1),
+		opts:     MatchmakingOptions{RatingRange: 100},
+		rating:   1500,
+		joinedAt: now,
+	}
+	ticket4 := &matchmakingTicket{
+		user:     user.NewAuthorized(user.GenerateSessionToken(), 1),
+		opts:     MatchmakingOptions{RatingRange: 100},
+		rating:   1500,
+		joinedAt: now,
+	}
+	assert.False(t, ticket3.compatible(ticket4, now), "same user on different sessions")
+}
+
+func expectMatchFound(t *testing.T, events <-chan MatchmakingEvent) MatchFoundEvent {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatal("channel closed before match was found")
+			}
+			if found, ok := ev.(MatchFoundEvent); ok {
+				return found
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for match")
+		}
+	}
+}
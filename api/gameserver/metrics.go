@@ -0,0 +1,168 @@
+package gameserver
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"libdb.so/scouts-server/internal/pubsub"
+)
+
+// Move errors that are told apart by the move failure metrics. They all wrap
+// ErrInvalidMove.
+var (
+	errNotAPlayer  = fmt.Errorf("%w: invalid session token", ErrInvalidMove)
+	errNotYourTurn = fmt.Errorf("%w: not your turn", ErrInvalidMove)
+	errOutOfTime   = fmt.Errorf("%w: out of time", ErrInvalidMove)
+)
+
+// gamePhase is the phase of a game's lifetime, as opposed to scouts.Phase,
+// which is the phase of the board game itself.
+type gamePhase string
+
+const (
+	gameUnstarted gamePhase = "unstarted"
+	gameRunning   gamePhase = "running"
+	gameEnded     gamePhase = "ended"
+)
+
+// gamePhase returns the phase of the game's lifetime.
+func (s GameState) gamePhase() gamePhase {
+	switch {
+	case s.EndedAt != nil:
+		return gameEnded
+	case s.BeganAt != nil:
+		return gameRunning
+	default:
+		return gameUnstarted
+	}
+}
+
+// gameMetrics are the Prometheus metrics of a GameManager and its games. A nil
+// *gameMetrics records nothing, which is the case for games in tests that are
+// not created by a GameManager.
+type gameMetrics struct {
+	games        *prometheus.GaugeVec
+	gamesTotal   *prometheus.CounterVec
+	subscribers  prometheus.Gauge
+	moveFailures *prometheus.CounterVec
+	events       *pubsub.Metrics
+}
+
+func newGameMetrics(limits pubsub.Limits) (*gameMetrics, []prometheus.Collector) {
+	m := &gameMetrics{
+		games: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "scouts",
+			Name:      "games",
+			Help:      "Number of games in memory by phase.",
+		}, []string{"phase"}),
+		gamesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "scouts",
+			Name:      "games_total",
+			Help:      "Number of games that have entered each phase.",
+		}, []string{"phase"}),
+		subscribers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "scouts",
+			Name:      "game_subscribers",
+			Help:      "Number of open subscriptions to the events of games.",
+		}),
+		moveFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "scouts",
+			Name:      "move_failures_total",
+			Help:      "Number of moves that were rejected by kind of error.",
+		}, []string{"kind"}),
+		events: pubsub.NewMetrics("scouts", "game"),
+	}
+
+	collectors := []prometheus.Collector{
+		m.games,
+		m.gamesTotal,
+		m.subscribers,
+		m.moveFailures,
+		m.events,
+	}
+
+	backpressure := func(action pubsub.Backpressure, count func(pubsub.BackpressureCounts) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   "scouts",
+			Name:        "backpressure_actions_total",
+			Help:        "Number of times that each backpressure action was taken on a subscriber.",
+			ConstLabels: prometheus.Labels{"action": action.String()},
+		}, func() float64 {
+			return float64(count(limits.Counters.Counts()))
+		})
+	}
+
+	collectors = append(collectors,
+		backpressure(pubsub.BackpressureBlock, func(c pubsub.BackpressureCounts) uint64 { return c.Blocked }),
+		backpressure(pubsub.BackpressureDrop, func(c pubsub.BackpressureCounts) uint64 { return c.Dropped }),
+		backpressure(pubsub.BackpressureCoalesce, func(c pubsub.BackpressureCounts) uint64 { return c.Coalesced }),
+	)
+
+	return m, collectors
+}
+
+// eventMetrics returns the metrics that the event backends of games count
+// their events in.
+func (m *gameMetrics) eventMetrics() *pubsub.Metrics {
+	if m == nil {
+		return nil
+	}
+	return m.events
+}
+
+// enterPhase records that a game went from one phase to another. An empty from
+// means that the game was just created, and an empty to means that the game
+// was evicted.
+func (m *gameMetrics) enterPhase(from, to gamePhase) {
+	if m == nil {
+		return
+	}
+	if from != "" {
+		m.games.WithLabelValues(string(from)).Dec()
+	}
+	if to != "" {
+		m.games.WithLabelValues(string(to)).Inc()
+		m.gamesTotal.WithLabelValues(string(to)).Inc()
+	}
+}
+
+func (m *gameMetrics) subscribed(delta float64) {
+	if m != nil {
+		m.subscribers.Add(delta)
+	}
+}
+
+func (m *gameMetrics) moveFailed(err error) {
+	if m != nil && err != nil {
+		m.moveFailures.WithLabelValues(moveFailureKind(err)).Inc()
+	}
+}
+
+// moveFailureKind returns the label of the move failure metric for err.
+func moveFailureKind(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrGameStopped):
+		return "game_stopped"
+	case errors.Is(err, ErrMoveIndexMismatch):
+		return "move_index_mismatch"
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		return "idempotency_key_reused"
+	case errors.Is(err, ErrInvalidGameState):
+		return "invalid_game_state"
+	case errors.Is(err, errNotAPlayer):
+		return "not_a_player"
+	case errors.Is(err, errNotYourTurn):
+		return "not_your_turn"
+	case errors.Is(err, errOutOfTime):
+		return "out_of_time"
+	case errors.Is(err, ErrInvalidMove):
+		return "invalid_move"
+	default:
+		// Moves that break the rules of the game are rejected by the scouts
+		// package with errors of its own.
+		return "illegal_move"
+	}
+}
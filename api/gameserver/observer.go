@@ -0,0 +1,20 @@
+package gameserver
+
+// GameObserver observes the lifecycle of every game of a GameManager, e.g. to
+// notify other services of it. Its methods are called on the goroutines of
+// the games, so they must return quickly.
+type GameObserver interface {
+	// GameCreated is called once a game has been created, with its initial
+	// state.
+	GameCreated(state GameState)
+	// GameBegan is called once both players have joined a game and it has
+	// begun, with its state and its first turn. It is only called once per
+	// game, unlike the TurnBeginEvent of the first turn, which is published
+	// again whenever the game is replayed.
+	GameBegan(state GameState, turn TurnBeginEvent)
+	// GameEvents is called with the events that a game publishes to its
+	// subscribers, in the order that they are published. state returns the
+	// state of the game right after the events. It must not be called once
+	// GameEvents has returned.
+	GameEvents(id GameID, events []GameEvent, state func() GameState)
+}
@@ -0,0 +1,246 @@
+package gameserver
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/scouts"
+)
+
+// ErrNoOffer is an error that is returned when there is no pending offer to
+// accept or decline.
+var ErrNoOffer = hrt.NewHTTPError(409, "no pending offer")
+
+// OfferKind is the kind of an offer that a player can make to their opponent.
+type OfferKind string
+
+const (
+	// OfferDraw is an offer to end the game in a draw.
+	OfferDraw OfferKind = "draw"
+	// OfferTakeback is a request to take back the player's last turn. Any
+	// moves that the opponent has made since are also taken back.
+	OfferTakeback OfferKind = "takeback"
+)
+
+// Validate validates the offer kind.
+func (k OfferKind) Validate() error {
+	switch k {
+	case OfferDraw, OfferTakeback:
+		return nil
+	default:
+		return fmt.Errorf("invalid offer kind: %q", k)
+	}
+}
+
+// UnmarshalText unmarshals the offer kind from text.
+func (k *OfferKind) UnmarshalText(text []byte) error {
+	kind := OfferKind(text)
+	if err := kind.Validate(); err != nil {
+		return err
+	}
+	*k = kind
+	return nil
+}
+
+// Offer is an offer that a player has made to their opponent. Offers expire
+// once the next move is made.
+type Offer struct {
+	// Kind is the kind of the offer.
+	Kind OfferKind `json:"kind"`
+	// PlayerSide is the side that made the offer.
+	PlayerSide scouts.Player `json:"player_side"`
+	// MoveIndex is the number of moves that had been made when the offer was
+	// made.
+	MoveIndex int `json:"move_index"`
+}
+
+// MakeOffer makes an offer to the user's opponent. Making the same offer twice
+// does nothing.
+func (g *gameInstance) MakeOffer(authorization user.Authorization, kind OfferKind) error {
+	if err := kind.Validate(); err != nil {
+		return hrt.WrapHTTPError(400, err)
+	}
+
+	return g.doErr(func() error {
+		player, err := g.negotiatingPlayer(authorization)
+		if err != nil {
+			return err
+		}
+
+		if slices.ContainsFunc(g.state.Offers, func(o Offer) bool {
+			return o.Kind == kind && o.PlayerSide == player
+		}) {
+			return nil
+		}
+
+		if kind == OfferTakeback && takebackIndex(g.state.Moves, player) == -1 {
+			return fmt.Errorf("%w: no moves to take back", ErrInvalidGameState)
+		}
+
+		offer := Offer{
+			Kind:       kind,
+			PlayerSide: player,
+			MoveIndex:  len(g.state.Moves),
+		}
+
+		g.state.Offers = append(slices.Clip(g.state.Offers), offer)
+		g.sendEvent(OfferMadeEvent{Offer: offer})
+		return nil
+	})
+}
+
+// AcceptOffer accepts the opponent's pending offer of the given kind.
+func (g *gameInstance) AcceptOffer(authorization user.Authorization, kind OfferKind) error {
+	now := g.clock.Now()
+
+	return g.doErr(func() error {
+		offer, err := g.takeOffer(authorization, kind)
+		if err != nil {
+			return err
+		}
+
+		g.sendEvent(OfferAcceptedEvent{Offer: offer})
+
+		switch offer.Kind {
+		case OfferDraw:
+			g.timer.Elapse(now, g.game.CurrentTurn().Player)
+			g.endGame(now, scouts.PlayerNone)
+			g.sendEvent(GameEndEvent{
+				Winner:        scouts.PlayerNone,
+				TimeRemaining: g.timer.Remaining(),
+			})
+		case OfferTakeback:
+			return g.rollback(now, takebackIndex(g.state.Moves, offer.PlayerSide))
+		}
+
+		return nil
+	})
+}
+
+// DeclineOffer declines the opponent's pending offer of the given kind.
+func (g *gameInstance) DeclineOffer(authorization user.Authorization, kind OfferKind) error {
+	return g.doErr(func() error {
+		offer, err := g.takeOffer(authorization, kind)
+		if err != nil {
+			return err
+		}
+
+		g.sendEvent(OfferDeclinedEvent{Offer: offer})
+		return nil
+	})
+}
+
+// negotiatingPlayer returns the side of the user if they can currently
+// negotiate with their opponent. It must be called on the game's goroutine.
+func (g *gameInstance) negotiatingPlayer(authorization user.Authorization) (scouts.Player, error) {
+	if g.state.BeganAt == nil {
+		return 0, fmt.Errorf("%w: less than two players in game", ErrInvalidGameState)
+	}
+
+	if g.state.EndedAt != nil {
+		return 0, fmt.Errorf("%w: game has ended", ErrInvalidGameState)
+	}
+
+	player := g.playerSide(authorization)
+	if player == scouts.PlayerNone {
+		return 0, fmt.Errorf("%w: only players can negotiate", ErrNotAllowed)
+	}
+
+	return player, nil
+}
+
+// takeOffer removes and returns the pending offer of the given kind that the
+// user's opponent has made. It must be called on the game's goroutine.
+func (g *gameInstance) takeOffer(authorization user.Authorization, kind OfferKind) (Offer, error) {
+	if err := kind.Validate(); err != nil {
+		return Offer{}, hrt.WrapHTTPError(400, err)
+	}
+
+	player, err := g.negotiatingPlayer(authorization)
+	if err != nil {
+		return Offer{}, err
+	}
+
+	i := slices.IndexFunc(g.state.Offers, func(o Offer) bool {
+		return o.Kind == kind && o.PlayerSide == player.Opponent()
+	})
+	if i == -1 {
+		return Offer{}, ErrNoOffer
+	}
+
+	offer := g.state.Offers[i]
+	g.state.Offers = slices.Delete(slices.Clone(g.state.Offers), i, i+1)
+	return offer, nil
+}
+
+// expireOffers expires all pending offers. It is called whenever a move is
+// made or taken back. It must be called on the game's goroutine.
+func (g *gameInstance) expireOffers() {
+	for _, offer := range g.state.Offers {
+		g.sendEvent(OfferExpiredEvent{Offer: offer})
+	}
+	g.state.Offers = nil
+}
+
+// rollback takes back all moves starting from the given move index and rebuilds
+// the game up to that point. The clock is rebuilt from the remaining moves the
+// same way that replaying the game does, so the player to move is charged for
+// all the time since the last remaining move. It must be called on the game's
+// goroutine.
+func (g *gameInstance) rollback(now time.Time, index int) error {
+	if index < 0 || index > len(g.state.Moves) {
+		return fmt.Errorf("%w: invalid rollback index %d", ErrInvalidGameState, index)
+	}
+
+	game := scouts.NewGame()
+	timer := newGameTimer(*g.state.BeganAt, g.state.Metadata.TimeLimit, g.state.Metadata.Increment)
+	for _, move := range g.state.Moves[:index] {
+		timer.Credit(move.Player, move.LagCredit)
+		timer.Subtract(move.Time, move.Player)
+		if err := game.MakeMove(move.Player, move.Move); err != nil {
+			return fmt.Errorf("%w: cannot replay moves: %v", ErrInvalidGameState, err)
+		}
+	}
+
+	undone := len(g.state.Moves) - index
+	// Clip the moves so that future moves don't overwrite older snapshots.
+	g.state.Moves = slices.Clip(g.state.Moves[:index])
+	g.game = game
+	g.timer = timer
+
+	g.sendEvent(
+		RollbackEvent{
+			EventHeader:   EventHeader{ServerTime: now},
+			MoveCount:     index,
+			UndoneMoves:   undone,
+			TimeRemaining: g.timer.Remaining(),
+		},
+		turnBeginEvent(now, g.game, g.timer),
+	)
+	g.expireOffers()
+
+	// The results of earlier requests no longer apply to the game, so a
+	// retried move must be made again.
+	g.idempotency.reset()
+
+	return nil
+}
+
+// takebackIndex returns the index of the first move of the player's last turn,
+// or -1 if the player has not made any moves.
+func takebackIndex(moves []MoveSnapshot, player scouts.Player) int {
+	i := len(moves) - 1
+	for i >= 0 && moves[i].Player != player {
+		i--
+	}
+	if i == -1 {
+		return -1
+	}
+	for i > 0 && moves[i-1].Player == player {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,200 @@
+package gameserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/scouts"
+)
+
+func TestGameOffers(t *testing.T) {
+	t.Run("draw", func(t *testing.T) {
+		game := newTestingGameInstance(t, CreateGameOptions{})
+		t.Cleanup(game.stop)
+
+		game.join(t, game.User1)
+		game.join(t, game.User2)
+
+		assert.NoError(t, game.MakeOffer(game.User1, OfferDraw))
+
+		err := game.AcceptOffer(game.User1, OfferDraw)
+		assert.IsError(t, err, ErrNoOffer, "player should not accept their own offer")
+
+		assert.NoError(t, game.AcceptOffer(game.User2, OfferDraw))
+
+		state := game.StateSnapshot()
+		assert.NotZero(t, state.EndedAt, "game should have ended")
+		assert.Equal(t, scouts.PlayerNone, state.Winner)
+		assert.Zero(t, state.Offers)
+	})
+
+	t.Run("declined", func(t *testing.T) {
+		game := newTestingGameInstance(t, CreateGameOptions{})
+		t.Cleanup(game.stop)
+
+		game.join(t, game.User1)
+		game.join(t, game.User2)
+
+		assert.NoError(t, game.MakeOffer(game.User2, OfferDraw))
+		assert.NoError(t, game.DeclineOffer(game.User1, OfferDraw))
+		assert.IsError(t, game.AcceptOffer(game.User1, OfferDraw), ErrNoOffer)
+		assert.Zero(t, game.StateSnapshot().EndedAt, "game should not have ended")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		game := newTestingGameInstance(t, CreateGameOptions{})
+		t.Cleanup(game.stop)
+
+		game.join(t, game.User1)
+		game.join(t, game.User2)
+
+		ev, _ := game.subscribe(t, game.User2)
+		playback := playbackEvents(game)
+
+		assert.NoError(t, game.MakeOffer(game.User2, OfferDraw))
+		game.move(t, game.User1, mustMove("place_scout 0,9"))
+
+		offer := Offer{Kind: OfferDraw, PlayerSide: scouts.PlayerB}
+		expectEvents(t, ev, append(playback,
+			OfferMadeEvent{Offer: offer},
+			MoveMadeEvent{
+				Move:          mustMove("place_scout 0,9"),
+				PlayerSide:    scouts.PlayerA,
+				TimeRemaining: InfiniteDurationPair,
+				Changes:       scoutPlaced(scouts.PlayerA, 0, 9),
+			},
+			TurnBeginEvent{
+				PlayerSide:     scouts.PlayerB,
+				PlaysRemaining: 1,
+				TimeRemaining:  InfiniteDurationPair,
+			},
+			OfferExpiredEvent{Offer: offer},
+		))
+
+		assert.IsError(t, game.AcceptOffer(game.User1, OfferDraw), ErrNoOffer)
+	})
+
+	t.Run("takeback", func(t *testing.T) {
+		game := newTestingGameInstance(t, CreateGameOptions{})
+		t.Cleanup(game.stop)
+
+		game.join(t, game.User1)
+		game.join(t, game.User2)
+
+		err := game.MakeOffer(game.User1, OfferTakeback)
+		assert.IsError(t, err, ErrInvalidGameState, "player has no moves to take back")
+
+		game.move(t, game.User1, mustMove("place_scout 0,9"))
+		game.move(t, game.User2, mustMove("place_scout 0,0"))
+
+		ev, _ := game.subscribe(t, game.User2)
+		playback := playbackEvents(game)
+
+		assert.NoError(t, game.MakeOffer(game.User1, OfferTakeback))
+		assert.NoError(t, game.AcceptOffer(game.User2, OfferTakeback))
+
+		offer := Offer{Kind: OfferTakeback, PlayerSide: scouts.PlayerA, MoveIndex: 2}
+		expectEvents(t, ev, append(playback,
+			OfferMadeEvent{Offer: offer},
+			OfferAcceptedEvent{Offer: offer},
+			RollbackEvent{
+				MoveCount:     0,
+				UndoneMoves:   2,
+				TimeRemaining: InfiniteDurationPair,
+			},
+			TurnBeginEvent{
+				PlayerSide:     scouts.PlayerA,
+				PlaysRemaining: 1,
+				TimeRemaining:  InfiniteDurationPair,
+			},
+		))
+
+		state := game.StateSnapshot()
+		assert.Equal(t, 0, len(state.Moves))
+		assert.Equal(t, scouts.PlayerA, game.game.CurrentTurn().Player)
+
+		// The game continues from the rolled back state.
+		game.move(t, game.User1, mustMove("place_scout 1,9"))
+	})
+
+	t.Run("timed takeback", func(t *testing.T) {
+		clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		opts := CreateGameOptions{TimeLimit: Duration(time.Minute)}
+
+		game := &testingGameInstance{
+			gameInstance: newGameInstance(opts, slogt.New(t), clk),
+			User1:        user.NewAuthorized(user.GenerateSessionToken(), 1),
+			User2:        user.NewAuthorized(user.GenerateSessionToken(), 2),
+		}
+		t.Cleanup(game.stop)
+
+		game.join(t, game.User1)
+		game.join(t, game.User2)
+
+		clk.Advance(10 * time.Second)
+		game.move(t, game.User1, mustMove("place_scout 0,9"))
+
+		clk.Advance(10 * time.Second)
+		assert.NoError(t, game.MakeOffer(game.User1, OfferTakeback))
+		assert.NoError(t, game.AcceptOffer(game.User2, OfferTakeback))
+
+		// The clock after a rollback is the same as when replaying the
+		// remaining moves.
+		ply, err := plyState(game.StateSnapshot(), 0)
+		assert.NoError(t, err)
+		assert.Equal(t, ply.TimeRemaining, timeRemaining(game))
+
+		clk.Advance(5 * time.Second)
+		game.move(t, game.User1, mustMove("place_scout 1,9"))
+
+		ply, err = plyState(game.StateSnapshot(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, ply.TimeRemaining, timeRemaining(game))
+		assert.Equal(t, [2]Duration{Duration(35 * time.Second), Duration(time.Minute)}, ply.TimeRemaining)
+	})
+
+	t.Run("takeback resets", func(t *testing.T) {
+		game := newTestingGameInstance(t, CreateGameOptions{})
+		t.Cleanup(game.stop)
+
+		game.join(t, game.User1)
+		game.join(t, game.User2)
+
+		opts := MoveOptions{IdempotencyKey: "move-1"}
+		assert.NoError(t, game.MakeMove(context.Background(), game.User1, mustMove("place_scout 0,9"), opts))
+
+		assert.NoError(t, game.MakeOffer(game.User2, OfferDraw))
+		assert.NoError(t, game.MakeOffer(game.User1, OfferTakeback))
+		assert.NoError(t, game.AcceptOffer(game.User2, OfferTakeback))
+		assert.Zero(t, game.StateSnapshot().Offers, "offers should expire once a move is taken back")
+
+		// Retrying the move makes it again rather than returning the result
+		// from before the takeback.
+		assert.NoError(t, game.MakeMove(context.Background(), game.User1, mustMove("place_scout 0,9"), opts))
+		assert.Equal(t, 1, len(game.StateSnapshot().Moves))
+	})
+}
+
+// timeRemaining returns the time remaining on the live clock of the game.
+func timeRemaining(game *testingGameInstance) [2]Duration {
+	var remaining [2]Duration
+	game.view(func() { remaining = game.timer.Remaining() })
+	return remaining
+}
+
+// playbackEvents returns the events that a new subscriber receives when it
+// subscribes to the game at the moment.
+func playbackEvents(game *testingGameInstance) []GameEvent {
+	var events []GameEvent
+	game.view(func() {
+		events = append(
+			playbackPlayerJoinEvents(game.gameInstance, time.Time{}),
+			playbackGameEvents(game.state, time.Time{})...)
+	})
+	return events
+}
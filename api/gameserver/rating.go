@@ -0,0 +1,248 @@
+package gameserver
+
+import (
+	"time"
+
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/glicko2"
+	"libdb.so/scouts-server/scouts"
+)
+
+// RatingCategory is a category of time controls. Players have a separate
+// rating pool for each category.
+type RatingCategory string
+
+const (
+	RatingUntimed   RatingCategory = "untimed"
+	RatingBullet    RatingCategory = "bullet"
+	RatingBlitz     RatingCategory = "blitz"
+	RatingRapid     RatingCategory = "rapid"
+	RatingClassical RatingCategory = "classical"
+)
+
+// RatingCategoryFor returns the rating category for the given time control.
+// The category is determined by the estimated duration of the game, assuming
+// that each side makes 40 moves.
+func RatingCategoryFor(timeLimit, increment Duration) RatingCategory {
+	if timeLimit <= 0 {
+		return RatingUntimed
+	}
+
+	estimated := timeLimit.ToDuration() + 40*increment.ToDuration()
+	switch {
+	case estimated < 3*time.Minute:
+		return RatingBullet
+	case estimated < 8*time.Minute:
+		return RatingBlitz
+	case estimated < 25*time.Minute:
+		return RatingRapid
+	default:
+		return RatingClassical
+	}
+}
+
+// Rating is a player's Glicko-2 rating.
+type Rating = glicko2.Rating
+
+// RatingHistoryEntry is a single entry in a player's rating history.
+type RatingHistoryEntry struct {
+	Rating
+	// GameID is the game that resulted in this rating.
+	GameID GameID `json:"game_id"`
+	// Time is the time that the rating was updated.
+	Time time.Time `json:"time"`
+}
+
+// CategoryRating is a player's rating in a single category.
+type CategoryRating struct {
+	// Current is the current rating of the player.
+	Current Rating `json:"current"`
+	// History is the list of past ratings of the player, oldest first. The last
+	// entry is always the current rating.
+	History []RatingHistoryEntry `json:"history"`
+}
+
+// UserRatings contains the ratings of a user in all categories that they have
+// played in.
+type UserRatings map[RatingCategory]CategoryRating
+
+// RatingStorage is in charge of persisting player ratings.
+type RatingStorage interface {
+	// QueryRatings queries all ratings of the given user. Categories that the
+	// user has never played in are omitted.
+	QueryRatings(user.UserID) (UserRatings, error)
+	// UpdateRating atomically replaces the user's current rating in the given
+	// category with the entry returned by update and appends the entry to the
+	// user's rating history. update is called with the current rating, or
+	// with glicko2.Default if the user has never played in the category.
+	UpdateRating(userID user.UserID, category RatingCategory, update func(current Rating) RatingHistoryEntry) error
+}
+
+// GameRatings contains the ratings of both players in a rated game. Ratings
+// are indexed by side, with player A first.
+type GameRatings struct {
+	// Category is the rating category of the game.
+	Category RatingCategory `json:"category"`
+	// Before is the ratings of both players before the game.
+	Before [2]Rating `json:"before"`
+	// After is the ratings of both players after the game.
+	// If nil, then the game has not ended yet.
+	After *[2]Rating `json:"after"`
+}
+
+// applyRatingChange applies the change from before to after that a game made
+// to a player's rating onto the player's current rating, which may have
+// changed while the game was played. The rating moves by the same number of
+// points, and the deviation and volatility are scaled by the same factors,
+// which keeps them positive.
+func applyRatingChange(current, before, after Rating) Rating {
+	return Rating{
+		Rating:     current.Rating + after.Rating - before.Rating,
+		Deviation:  current.Deviation * after.Deviation / before.Deviation,
+		Volatility: current.Volatility * after.Volatility / before.Volatility,
+	}
+}
+
+func queryRating(storage RatingStorage, userID user.UserID, category RatingCategory) (Rating, error) {
+	ratings, err := storage.QueryRatings(userID)
+	if err != nil {
+		return Rating{}, err
+	}
+	if rating, ok := ratings[category]; ok {
+		return rating.Current, nil
+	}
+	return glicko2.Default, nil
+}
+
+// ratedUsers returns the user IDs of both players if the game can be rated.
+// A game can only be rated if both players are registered users and are not
+// the same user.
+func ratedUsers(state GameState) ([2]user.UserID, bool) {
+	if !state.Metadata.Rated || !state.hasBothPlayers() {
+		return [2]user.UserID{}, false
+	}
+	a := state.PlayerA.UserID
+	b := state.PlayerB.UserID
+	if a == nil || b == nil || *a == *b {
+		return [2]user.UserID{}, false
+	}
+	return [2]user.UserID{*a, *b}, true
+}
+
+// queryJoinRating queries the rating of a user who is about to join the game,
+// so that beginRating doesn't query the storage on the game's goroutine. nil
+// is returned if the game is not rated, if the user is anonymous or if the
+// rating cannot be queried.
+func (g *gameInstance) queryJoinRating(authorization user.Authorization) *Rating {
+	// The metadata of the game never changes, so it can be read from any
+	// goroutine.
+	metadata := g.state.Metadata
+	if g.ratings == nil || !metadata.Rated || authorization.UserID == nil {
+		return nil
+	}
+
+	category := RatingCategoryFor(metadata.TimeLimit, metadata.Increment)
+	rating, err := queryRating(g.ratings, *authorization.UserID, category)
+	if err != nil {
+		g.logger.Error(
+			"cannot query player rating, game will not be rated",
+			"user_id", *authorization.UserID,
+			"err", err)
+		return nil
+	}
+	return &rating
+}
+
+// beginRating records the ratings of both players before the game begins,
+// which were queried as they joined. It does nothing if the game is not
+// rated.
+func (g *gameInstance) beginRating() {
+	if g.ratings == nil {
+		return
+	}
+
+	if _, ok := ratedUsers(g.state); !ok {
+		return
+	}
+
+	ratings := GameRatings{
+		Category: RatingCategoryFor(
+			g.state.Metadata.TimeLimit,
+			g.state.Metadata.Increment),
+	}
+
+	for i, rating := range g.joinRatings {
+		if rating == nil {
+			return
+		}
+		ratings.Before[i] = *rating
+	}
+
+	g.state.Ratings = &ratings
+}
+
+// finishRating computes the ratings of both players after the game has ended
+// with the given winner. A winner of PlayerNone is considered a draw. Both
+// sides are rated from the ratings recorded by beginRating, and the change in
+// their ratings is applied to their stored ratings in the background so that
+// the game isn't held up by the storage.
+func (g *gameInstance) finishRating(now time.Time, winner scouts.Player) {
+	if g.state.Ratings == nil || g.state.Ratings.After != nil {
+		return
+	}
+
+	users, ok := ratedUsers(g.state)
+	if !ok {
+		return
+	}
+
+	// Copy the ratings so that we don't modify existing snapshots.
+	ratings := *g.state.Ratings
+	ratings.After = &[2]Rating{}
+
+	for i := range users {
+		player := scouts.Player(i + 1)
+
+		score := glicko2.Draw
+		switch winner {
+		case player:
+			score = glicko2.Win
+		case player.Opponent():
+			score = glicko2.Loss
+		}
+
+		ratings.After[i] = glicko2.Update(ratings.Before[i], glicko2.Result{
+			Opponent: ratings.Before[1-i],
+			Score:    score,
+		})
+	}
+
+	g.state.Ratings = &ratings
+
+	// Stop waits for the ratings to be persisted.
+	entry := RatingHistoryEntry{GameID: g.state.GameID, Time: now}
+	g.waitg.Add(1)
+	go func() {
+		defer g.waitg.Done()
+		g.persistRatings(users, entry, ratings)
+	}()
+}
+
+// persistRatings applies the change that a game made to the ratings of both
+// players to their stored ratings, which may have changed while the game was
+// played, e.g. if they finished another game. It must not be called on the
+// game's goroutine.
+func (g *gameInstance) persistRatings(users [2]user.UserID, entry RatingHistoryEntry, ratings GameRatings) {
+	for i, userID := range users {
+		err := g.ratings.UpdateRating(userID, ratings.Category, func(current Rating) RatingHistoryEntry {
+			entry.Rating = applyRatingChange(current, ratings.Before[i], ratings.After[i])
+			return entry
+		})
+		if err != nil {
+			g.logger.Error(
+				"cannot update player rating",
+				"user_id", userID,
+				"err", err)
+		}
+	}
+}
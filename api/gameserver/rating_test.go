@@ -0,0 +1,187 @@
+package gameserver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/internal/glicko2"
+	"libdb.so/scouts-server/scouts"
+)
+
+func TestRatingCategoryFor(t *testing.T) {
+	tests := []struct {
+		timeLimit time.Duration
+		increment time.Duration
+		expected  RatingCategory
+	}{
+		{0, 0, RatingUntimed},
+		{time.Minute, time.Second, RatingBullet},
+		{3 * time.Minute, 2 * time.Second, RatingBlitz},
+		{10 * time.Minute, 0, RatingRapid},
+		{30 * time.Minute, 0, RatingClassical},
+	}
+
+	for _, test := range tests {
+		category := RatingCategoryFor(Duration(test.timeLimit), Duration(test.increment))
+		assert.Equal(t, test.expected, category, "time control %v+%v", test.timeLimit, test.increment)
+	}
+}
+
+func TestGameRating(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ratings := newMemoryRatingStorage()
+
+	opts := CreateGameOptions{
+		TimeLimit: Duration(time.Minute),
+		Rated:     true,
+	}
+
+	game := newGameInstance(opts, slogt.New(t), clk)
+	game.ratings = ratings
+	t.Cleanup(func() { game.Stop(GoingAwayServerShutdown) })
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	_, err := game.PlayerJoin(user1, "")
+	assert.NoError(t, err)
+	_, err = game.PlayerJoin(user2, "")
+	assert.NoError(t, err)
+
+	state := game.StateSnapshot()
+	assert.NotZero(t, state.Ratings, "game should be rated")
+	assert.Equal(t, RatingBullet, state.Ratings.Category)
+	assert.Equal(t, [2]Rating{glicko2.Default, glicko2.Default}, state.Ratings.Before)
+	assert.Zero(t, state.Ratings.After, "game should not have post-game ratings yet")
+
+	// Player A runs out of time.
+	clk.Advance(2 * time.Minute)
+	err = game.MakeMove(context.Background(), user1, mustMove("place_scout 0,9"), MoveOptions{})
+	assert.Error(t, err, "player should be out of time")
+
+	state = game.StateSnapshot()
+	assert.NotZero(t, state.EndedAt, "game should have ended")
+	assert.Equal(t, scouts.PlayerB, state.Winner)
+	assert.NotZero(t, state.Ratings.After, "game should have post-game ratings")
+
+	after := *state.Ratings.After
+	assert.True(t, after[0].Rating < glicko2.Default.Rating, "loser's rating should drop")
+	assert.True(t, after[1].Rating > glicko2.Default.Rating, "winner's rating should rise")
+
+	// The ratings are persisted by the time that the game has stopped.
+	game.Stop(GoingAwayServerShutdown)
+
+	user2Ratings, err := ratings.QueryRatings(2)
+	assert.NoError(t, err)
+	assert.Equal(t, after[1], user2Ratings[RatingBullet].Current)
+	assert.Equal(t, 1, len(user2Ratings[RatingBullet].History))
+}
+
+func TestGameRatingUnrated(t *testing.T) {
+	ratings := newMemoryRatingStorage()
+
+	game := newGameInstance(CreateGameOptions{Rated: true}, slogt.New(t), nil)
+	game.ratings = ratings
+	t.Cleanup(func() { game.Stop(GoingAwayServerShutdown) })
+
+	// Anonymous players cannot be rated.
+	_, err := game.PlayerJoin(user.NewAnonymous(user.GenerateSessionToken()), "")
+	assert.NoError(t, err)
+	_, err = game.PlayerJoin(user.NewAuthorized(user.GenerateSessionToken(), 2), "")
+	assert.NoError(t, err)
+
+	state := game.StateSnapshot()
+	assert.Zero(t, state.Ratings, "game with anonymous player should not be rated")
+}
+
+func TestGameRatingBaseline(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ratings := newMemoryRatingStorage()
+
+	game := newGameInstance(CreateGameOptions{TimeLimit: Duration(time.Minute), Rated: true}, slogt.New(t), clk)
+	game.ratings = ratings
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	_, err := game.PlayerJoin(user1, "")
+	assert.NoError(t, err)
+	_, err = game.PlayerJoin(user2, "")
+	assert.NoError(t, err)
+
+	// Player A finishes another game while this one is running.
+	other := glicko2.Default
+	other.Rating += 200
+	setRating(t, ratings, 1, RatingBullet, other)
+
+	clk.Advance(2 * time.Minute)
+	err = game.MakeMove(context.Background(), user1, mustMove("place_scout 0,9"), MoveOptions{})
+	assert.Error(t, err, "player should be out of time")
+	game.Stop(GoingAwayServerShutdown)
+
+	// Both sides are rated from the ratings that they had when the game
+	// began.
+	state := game.StateSnapshot()
+	assert.Equal(t, [2]Rating{
+		glicko2.Update(glicko2.Default, glicko2.Result{Opponent: glicko2.Default, Score: glicko2.Loss}),
+		glicko2.Update(glicko2.Default, glicko2.Result{Opponent: glicko2.Default, Score: glicko2.Win}),
+	}, *state.Ratings.After)
+
+	// The change is applied on top of the rating from the other game, which
+	// is kept.
+	user1Ratings, err := ratings.QueryRatings(1)
+	assert.NoError(t, err)
+	current := user1Ratings[RatingBullet].Current
+	assert.Equal(t, applyRatingChange(other, glicko2.Default, state.Ratings.After[0]), current)
+	assert.True(t, current.Rating < other.Rating, "loss should lower the interim rating")
+	assert.True(t, current.Rating > state.Ratings.After[0].Rating, "interim rating should be kept")
+	assert.Equal(t, 2, len(user1Ratings[RatingBullet].History))
+}
+
+// setRating stores the rating as the user's current rating in the category.
+func setRating(t *testing.T, ratings RatingStorage, userID user.UserID, category RatingCategory, rating Rating) {
+	err := ratings.UpdateRating(userID, category, func(Rating) RatingHistoryEntry {
+		return RatingHistoryEntry{Rating: rating}
+	})
+	assert.NoError(t, err)
+}
+
+type memoryRatingStorage struct {
+	mu      sync.Mutex
+	ratings map[user.UserID]UserRatings
+}
+
+func newMemoryRatingStorage() *memoryRatingStorage {
+	return &memoryRatingStorage{ratings: make(map[user.UserID]UserRatings)}
+}
+
+func (s *memoryRatingStorage) QueryRatings(userID user.UserID) (UserRatings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ratings[userID], nil
+}
+
+func (s *memoryRatingStorage) UpdateRating(userID user.UserID, category RatingCategory, update func(Rating) RatingHistoryEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ratings, ok := s.ratings[userID]
+	if !ok {
+		ratings = UserRatings{}
+		s.ratings[userID] = ratings
+	}
+	rating, ok := ratings[category]
+	if !ok {
+		rating.Current = glicko2.Default
+	}
+	entry := update(rating.Current)
+	rating.Current = entry.Rating
+	rating.History = append(rating.History, entry)
+	ratings[category] = rating
+	return nil
+}
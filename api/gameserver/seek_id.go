@@ -0,0 +1,41 @@
+package gameserver
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// SeekID is a type that represents a seek ID.
+type SeekID ulid.ULID
+
+// GenerateSeekID generates a new seek ID.
+func GenerateSeekID() SeekID {
+	return SeekID(ulid.MustNew(ulid.Now(), rand.Reader))
+}
+
+// CreatedAt returns the time that the seek ID was generated.
+func (s SeekID) CreatedAt() time.Time {
+	return ulid.Time((ulid.ULID)(s).Time())
+}
+
+// String returns the string representation of the seek ID.
+func (s SeekID) String() string {
+	return ulid.ULID(s).String()
+}
+
+// MarshalText marshals the seek ID into text.
+func (s SeekID) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText unmarshals the seek ID from text.
+func (s *SeekID) UnmarshalText(text []byte) error {
+	id, err := ulid.Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*s = SeekID(id)
+	return nil
+}
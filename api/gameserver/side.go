@@ -0,0 +1,59 @@
+package gameserver
+
+import (
+	"fmt"
+	"math/rand"
+
+	"libdb.so/scouts-server/scouts"
+)
+
+// SidePreference is the side that a player would like to play.
+type SidePreference string
+
+const (
+	// SideRandom means that the player doesn't mind which side they play.
+	SideRandom SidePreference = "random"
+	// SideA means that the player wants to play as player A.
+	SideA SidePreference = "A"
+	// SideB means that the player wants to play as player B.
+	SideB SidePreference = "B"
+)
+
+// Validate validates the side preference.
+func (p SidePreference) Validate() error {
+	switch p {
+	case "", SideRandom, SideA, SideB:
+		return nil
+	default:
+		return fmt.Errorf("invalid side preference: %q", p)
+	}
+}
+
+// Resolve returns the side that the player will play. If the player has no
+// preference, then a side is chosen at random.
+func (p SidePreference) Resolve() scouts.Player {
+	switch p {
+	case SideA:
+		return scouts.PlayerA
+	case SideB:
+		return scouts.PlayerB
+	default:
+		return randomSide()
+	}
+}
+
+// sidePreferenceOf returns the side preference for the given side.
+func sidePreferenceOf(player scouts.Player) SidePreference {
+	switch player {
+	case scouts.PlayerA:
+		return SideA
+	case scouts.PlayerB:
+		return SideB
+	default:
+		return SideRandom
+	}
+}
+
+func randomSide() scouts.Player {
+	return scouts.Player(rand.Intn(2) + 1)
+}
@@ -0,0 +1,47 @@
+package gameserver
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/tracing"
+	"libdb.so/scouts-server/scouts"
+)
+
+var tracer = otel.Tracer("libdb.so/scouts-server/api/gameserver")
+
+// startSpan starts a span for an action of the user in the game with the
+// given game ID.
+func startSpan(ctx context.Context, name string, id GameID, authorization user.Authorization, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		tracing.GameIDKey.String(id.String()),
+		tracing.UserKey.String(authorization.String()))
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, marking it as failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startApplySpan starts the span of applying the move to the board of a game.
+// If validate is true, then the move is only applied to a copy of the board to
+// validate it. Moves that are applied outside of a trace, such as when the
+// game is replayed, are not traced.
+func startApplySpan(ctx context.Context, player scouts.Player, move scouts.Move, validate bool) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracer.Start(ctx, "scouts.Game.Apply", trace.WithAttributes(
+		attribute.String("player", player.String()),
+		attribute.String("move", move.String()),
+		attribute.Bool("validate", validate)))
+}
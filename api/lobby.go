@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/gameserver"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/context"
+	"libdb.so/scouts-server/internal/unmarshal"
+)
+
+type lobbyServices struct {
+	*gameserver.Lobby
+}
+
+type lobbyHandler struct {
+	service lobbyServices
+}
+
+func mountLobbyHandler(r chi.Router, service lobbyServices) {
+	h := &lobbyHandler{service: service}
+	r.Get("/lobby", hrt.Wrap(h.listSeeks))
+	r.Route("/seeks", func(r chi.Router) {
+		r.Post("/", hrt.Wrap(h.createSeek))
+	})
+	r.Route("/seeks/{id}", func(r chi.Router) {
+		r.Use(h.parseSeekID)
+		r.Get("/", hrt.Wrap(h.seekInfo))
+		r.Delete("/", hrt.Wrap(h.cancelSeek))
+		r.Post("/accept", hrt.Wrap(h.acceptSeek))
+		r.Post("/decline", hrt.Wrap(h.declineSeek))
+	})
+}
+
+func (h *lobbyHandler) parseSeekID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idstr := chi.URLParam(r, "id")
+		if idstr == "" {
+			err := errors.New("missing seek ID")
+			errorWriter.WriteError(w, hrt.WrapHTTPError(http.StatusBadRequest, err))
+			return
+		}
+
+		id, err := unmarshal.Text[*gameserver.SeekID](idstr)
+		if err != nil {
+			errorWriter.WriteError(w, hrt.WrapHTTPError(http.StatusBadRequest, err))
+			return
+		}
+
+		ctx := context.With(r.Context(), *id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *lobbyHandler) listSeeks(ctx context.Context, _ hrt.None) ([]gameserver.Seek, error) {
+	authorization := context.From[user.Authorization](ctx)
+	return h.service.ListSeeks(authorization), nil
+}
+
+type createSeekRequest struct {
+	TimeLimit  gameserver.Duration       `json:"time_limit"`
+	Increment  gameserver.Duration       `json:"increment"`
+	Side       gameserver.SidePreference `json:"side"`
+	Rated      bool                      `json:"rated"`
+	Challenged *user.UserID              `json:"challenged"`
+}
+
+func (h *lobbyHandler) createSeek(ctx context.Context, req createSeekRequest) (gameserver.Seek, error) {
+	authorization := context.From[user.Authorization](ctx)
+	return h.service.CreateSeek(authorization, gameserver.SeekOptions{
+		TimeLimit:  req.TimeLimit,
+		Increment:  req.Increment,
+		Side:       req.Side,
+		Rated:      req.Rated,
+		Challenged: req.Challenged,
+	})
+}
+
+func (h *lobbyHandler) seekInfo(ctx context.Context, _ hrt.None) (gameserver.Seek, error) {
+	seekID := context.From[gameserver.SeekID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+	return h.service.QuerySeek(authorization, seekID)
+}
+
+func (h *lobbyHandler) cancelSeek(ctx context.Context, _ hrt.None) (hrt.None, error) {
+	seekID := context.From[gameserver.SeekID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+	return hrt.Empty, h.service.CancelSeek(authorization, seekID)
+}
+
+func (h *lobbyHandler) acceptSeek(ctx context.Context, _ hrt.None) (gameserver.SeekAcceptedResult, error) {
+	seekID := context.From[gameserver.SeekID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+	return h.service.AcceptSeek(authorization, seekID)
+}
+
+func (h *lobbyHandler) declineSeek(ctx context.Context, _ hrt.None) (hrt.None, error) {
+	seekID := context.From[gameserver.SeekID](ctx)
+	authorization := context.From[user.Authorization](ctx)
+	return hrt.Empty, h.service.DeclineSeek(authorization, seekID)
+}
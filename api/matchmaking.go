@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/gameserver"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/context"
+)
+
+type matchmakingServices struct {
+	*gameserver.Matchmaker
+}
+
+type matchmakingHandler struct {
+	service matchmakingServices
+}
+
+func mountMatchmakingHandler(r chi.Router, service matchmakingServices) {
+	h := &matchmakingHandler{service: service}
+	r.Post("/matchmaking", h.enterQueue)
+}
+
+type matchmakingRequest struct {
+	TimeLimit   gameserver.Duration `json:"time_limit"`
+	Increment   gameserver.Duration `json:"increment"`
+	Rated       bool                `json:"rated"`
+	RatingRange float64             `json:"rating_range"`
+}
+
+// enterQueue puts the user in the matchmaking queue and streams matchmaking
+// events as Server-Sent Events. The user leaves the queue when the connection
+// is closed.
+func (h *matchmakingHandler) enterQueue(w http.ResponseWriter, r *http.Request) {
+	if _, ok := w.(http.Flusher); !ok {
+		errorWriter.WriteError(w, errNoFlusher)
+		return
+	}
+
+	var req matchmakingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorWriter.WriteError(w, hrt.WrapHTTPError(http.StatusBadRequest, err))
+		return
+	}
+
+	authorization := context.From[user.Authorization](r.Context())
+
+	events, stop, err := h.service.Enqueue(authorization, gameserver.MatchmakingOptions{
+		TimeLimit:   req.TimeLimit,
+		Increment:   req.Increment,
+		Rated:       req.Rated,
+		RatingRange: req.RatingRange,
+	})
+	if err != nil {
+		errorWriter.WriteError(w, err)
+		return
+	}
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, event)
+		}
+	}
+}
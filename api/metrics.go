@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"libdb.so/scouts-server/api/user"
+)
+
+// sessionCache is implemented by session storages that count their cache hits,
+// such as user.CachedSessionStorage.
+type sessionCache interface {
+	CacheStats() user.SessionCacheStats
+}
+
+// registerMetrics registers the metrics of the handler and returns the
+// middleware that records the latency of each request by route.
+func (h *Handler) registerMetrics(reg prometheus.Registerer) func(http.Handler) http.Handler {
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "scouts",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+	reg.MustRegister(duration)
+
+	if cache, ok := h.service.SessionStorage.(sessionCache); ok {
+		reg.MustRegister(
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace: "scouts",
+				Name:      "session_cache_hits_total",
+				Help:      "Number of session queries answered from the cache.",
+			}, func() float64 { return float64(cache.CacheStats().Hits) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace: "scouts",
+				Name:      "session_cache_misses_total",
+				Help:      "Number of session queries that missed the cache.",
+			}, func() float64 { return float64(cache.CacheStats().Misses) }),
+		)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			// The route is only known once the request has been routed.
+			route := chi.RouteContext(r.Context()).RoutePattern()
+			if route == "" {
+				route = "unmatched"
+			}
+
+			duration.
+				WithLabelValues(route, r.Method, strconv.Itoa(ww.Status())).
+				Observe(time.Since(start).Seconds())
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"libdb.so/persist"
+	"libdb.so/persist/driver/badgerdb"
+	"libdb.so/scouts-server/api/gameserver"
+)
+
+// GameArchive is the game archive service.
+type GameArchive struct {
+	m *persist.Map[gameserver.GameID, gameserver.ArchivedGame]
+}
+
+var _ gameserver.GameArchive = (*GameArchive)(nil)
+
+func newGameArchive(manager *StorageManager) (*GameArchive, error) {
+	path, err := manager.pathFor("games")
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := badgerdb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Games are stored as JSON, since their moves can only be decoded from
+	// their text form.
+	m := persist.NewMapFromEncoders(driver, persist.EncoderPair[gameserver.GameID, gameserver.ArchivedGame]{
+		Key:   persist.CBOREncoder[gameserver.GameID](),
+		Value: jsonEncoder[gameserver.ArchivedGame]{},
+	})
+
+	return &GameArchive{m: m}, nil
+}
+
+func (s *GameArchive) ArchiveGame(game gameserver.ArchivedGame) error {
+	return s.m.Store(game.GameID, game)
+}
+
+func (s *GameArchive) QueryArchivedGame(id gameserver.GameID) (gameserver.ArchivedGame, error) {
+	game, ok, err := s.m.Load(id)
+	if err != nil {
+		return gameserver.ArchivedGame{}, err
+	}
+	if !ok {
+		return gameserver.ArchivedGame{}, gameserver.ErrNotFound
+	}
+	return game, nil
+}
+
+// jsonEncoder is a persist.Encoder that encodes values as JSON.
+type jsonEncoder[T any] struct{}
+
+func (jsonEncoder[T]) Encode(v T, buf []byte) ([]byte, error) {
+	bbuf := bytes.NewBuffer(buf[:0])
+	if err := json.NewEncoder(bbuf).Encode(v); err != nil {
+		return nil, err
+	}
+	return bbuf.Bytes(), nil
+}
+
+func (jsonEncoder[T]) Decode(buf []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(buf, &v)
+	return v, err
+}
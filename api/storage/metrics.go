@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var storageSizeDesc = prometheus.NewDesc(
+	"scouts_storage_size_bytes",
+	"Disk space used by the files of each store.",
+	[]string{"store"}, nil,
+)
+
+// Collector returns a Prometheus collector that reports the size of each store
+// on disk. The sizes are measured whenever the metrics are collected.
+func (m *StorageManager) Collector() prometheus.Collector {
+	return storageCollector{m}
+}
+
+type storageCollector struct {
+	manager *StorageManager
+}
+
+func (c storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- storageSizeDesc
+}
+
+func (c storageCollector) Collect(ch chan<- prometheus.Metric) {
+	root := filepath.Join(c.manager.path, "v1")
+
+	stores, err := os.ReadDir(root)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(storageSizeDesc, err)
+		return
+	}
+
+	for _, store := range stores {
+		if !store.IsDir() {
+			continue
+		}
+
+		size, err := dirSize(filepath.Join(root, store.Name()))
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(storageSizeDesc, err)
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			storageSizeDesc, prometheus.GaugeValue, float64(size), store.Name())
+	}
+}
+
+// dirSize returns the disk space used by the files within the directory.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += diskUsage(info)
+		return nil
+	})
+	return size, err
+}
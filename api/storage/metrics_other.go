@@ -0,0 +1,11 @@
+//go:build !unix
+
+package storage
+
+import "io/fs"
+
+// diskUsage returns the size of the file, which may be more than the disk space
+// that it uses.
+func diskUsage(info fs.FileInfo) int64 {
+	return info.Size()
+}
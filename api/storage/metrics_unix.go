@@ -0,0 +1,17 @@
+//go:build unix
+
+package storage
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// diskUsage returns the disk space used by the file. Badger preallocates its
+// value log files, so their sizes are much larger than the space they use.
+func diskUsage(info fs.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Blocks * 512
+	}
+	return info.Size()
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"sync"
+
+	"libdb.so/persist"
+	"libdb.so/persist/driver/badgerdb"
+	"libdb.so/scouts-server/api/gameserver"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/glicko2"
+)
+
+// RatingStorage is the rating storage service.
+type RatingStorage struct {
+	m  persist.Map[user.UserID, gameserver.UserRatings]
+	mu sync.Mutex // guards read-modify-write cycles
+}
+
+var _ gameserver.RatingStorage = (*RatingStorage)(nil)
+
+func newRatingStorage(manager *StorageManager) (*RatingStorage, error) {
+	path, err := manager.pathFor("ratings")
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := persist.NewMap[user.UserID, gameserver.UserRatings](badgerdb.Open, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RatingStorage{m: m}, nil
+}
+
+func (s *RatingStorage) QueryRatings(userID user.UserID) (gameserver.UserRatings, error) {
+	ratings, ok, err := s.m.Load(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return gameserver.UserRatings{}, nil
+	}
+	return ratings, nil
+}
+
+func (s *RatingStorage) UpdateRating(userID user.UserID, category gameserver.RatingCategory, update func(gameserver.Rating) gameserver.RatingHistoryEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ratings, ok, err := s.m.Load(userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		ratings = gameserver.UserRatings{}
+	}
+
+	rating, ok := ratings[category]
+	if !ok {
+		rating.Current = glicko2.Default
+	}
+	entry := update(rating.Current)
+	rating.Current = entry.Rating
+	rating.History = append(rating.History, entry)
+	ratings[category] = rating
+
+	return s.m.Store(userID, ratings)
+}
@@ -8,9 +8,13 @@ import (
 	"libdb.so/scouts-server/api/user"
 )
 
+// sessionMetadata is the value stored for each session. Its fields must be
+// exported, or else they are not encoded. Sessions stored before the fields
+// were exported were encoded without their user ID and expiry, so they are
+// treated as expired and their users have to log in again.
 type sessionMetadata struct {
-	userID *user.UserID
-	expiry int64
+	UserID *user.UserID `cbor:"user_id"`
+	Expiry int64        `cbor:"expiry"`
 }
 
 // SessionStorage is the session storage service.
@@ -18,7 +22,7 @@ type SessionStorage struct {
 	m persist.Map[user.SessionToken, sessionMetadata]
 }
 
-var _ user.SessionStorage = (*SessionStorage)(nil)
+var _ user.SessionAdminStorage = (*SessionStorage)(nil)
 
 func newSessionStorage(manager *StorageManager) (*SessionStorage, error) {
 	path, err := manager.pathFor("sessions")
@@ -38,7 +42,7 @@ func (s *SessionStorage) CreateSession() (user.SessionToken, error) {
 	for {
 		token := user.GenerateSessionToken()
 		_, exists, err := s.m.LoadOrStore(token, sessionMetadata{
-			expiry: time.Now().Add(user.SessionTTL).Unix(),
+			Expiry: time.Now().Add(user.SessionTTL).Unix(),
 		})
 		if err != nil {
 			return user.SessionToken{}, err
@@ -58,7 +62,7 @@ func (s *SessionStorage) ChangeSession(token user.SessionToken, userID *user.Use
 	if !ok {
 		return user.ErrSessionNotFound
 	}
-	value.userID = userID
+	value.UserID = userID
 	return s.m.Store(token, value)
 }
 
@@ -67,8 +71,52 @@ func (s *SessionStorage) QuerySession(token user.SessionToken) (*user.UserID, er
 	if err != nil {
 		return nil, err
 	}
-	if !ok || value.expiry < time.Now().Unix() {
+	if !ok || value.Expiry < time.Now().Unix() {
 		return nil, user.ErrSessionNotFound
 	}
-	return value.userID, nil
+	return value.UserID, nil
+}
+
+func (s *SessionStorage) ListSessions() ([]user.Session, error) {
+	now := time.Now().Unix()
+
+	var sessions []user.Session
+	s.m.All()(func(token user.SessionToken, value sessionMetadata) bool {
+		if value.Expiry >= now {
+			sessions = append(sessions, user.Session{
+				Handle:    token.Handle(),
+				UserID:    value.UserID,
+				ExpiresAt: time.Unix(value.Expiry, 0),
+			})
+		}
+		return true
+	})
+
+	return sessions, nil
+}
+
+func (s *SessionStorage) RevokeSession(handle user.SessionHandle) error {
+	// Tokens are only stored as keys, so the session has to be looked up by
+	// its handle. Revoking sessions is rare enough for this to be fine.
+	var token user.SessionToken
+	var found bool
+	s.m.Keys()(func(t user.SessionToken) bool {
+		if t.Handle() == handle {
+			token, found = t, true
+			return false
+		}
+		return true
+	})
+	if !found {
+		return user.ErrSessionNotFound
+	}
+
+	_, ok, err := s.m.LoadAndDelete(token)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return user.ErrSessionNotFound
+	}
+	return nil
 }
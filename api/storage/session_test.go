@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"libdb.so/scouts-server/api/user"
+)
+
+func TestSessionStorageReload(t *testing.T) {
+	manager := NewStorageManager(t.TempDir())
+
+	sessions, err := manager.OpenSessionStorage()
+	assert.NoError(t, err)
+
+	token, err := sessions.CreateSession()
+	assert.NoError(t, err)
+
+	userID := user.UserID(42)
+	assert.NoError(t, sessions.ChangeSession(token, &userID))
+	assert.NoError(t, sessions.m.Close())
+
+	sessions, err = manager.OpenSessionStorage()
+	assert.NoError(t, err)
+	t.Cleanup(func() { sessions.m.Close() })
+
+	queried, err := sessions.QuerySession(token)
+	assert.NoError(t, err, "session should still be valid after reloading")
+	assert.Equal(t, &userID, queried)
+
+	list, err := sessions.ListSessions()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, token.Handle(), list[0].Handle)
+	assert.False(t, list[0].ExpiresAt.IsZero(), "expiry should be stored")
+}
+
+func TestSessionStorageRevoke(t *testing.T) {
+	sessions, err := NewStorageManager(t.TempDir()).OpenSessionStorage()
+	assert.NoError(t, err)
+	t.Cleanup(func() { sessions.m.Close() })
+
+	token1, err := sessions.CreateSession()
+	assert.NoError(t, err)
+	token2, err := sessions.CreateSession()
+	assert.NoError(t, err)
+
+	assert.NoError(t, sessions.RevokeSession(token1.Handle()))
+	assert.IsError(t, sessions.RevokeSession(token1.Handle()), user.ErrSessionNotFound)
+
+	_, err = sessions.QuerySession(token1)
+	assert.IsError(t, err, user.ErrSessionNotFound, "revoked session should be gone")
+	_, err = sessions.QuerySession(token2)
+	assert.NoError(t, err, "other sessions should be kept")
+}
@@ -26,3 +26,18 @@ func (m *StorageManager) pathFor(name string) (string, error) {
 func (m *StorageManager) OpenSessionStorage() (*SessionStorage, error) {
 	return newSessionStorage(m)
 }
+
+// OpenRatingStorage opens a rating storage service.
+func (m *StorageManager) OpenRatingStorage() (*RatingStorage, error) {
+	return newRatingStorage(m)
+}
+
+// OpenGameArchive opens a game archive service.
+func (m *StorageManager) OpenGameArchive() (*GameArchive, error) {
+	return newGameArchive(m)
+}
+
+// OpenWebhookStorage opens a webhook storage service.
+func (m *StorageManager) OpenWebhookStorage() (*WebhookStorage, error) {
+	return newWebhookStorage(m)
+}
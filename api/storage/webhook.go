@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+
+	"libdb.so/persist"
+	"libdb.so/persist/driver/badgerdb"
+	"libdb.so/scouts-server/api/webhook"
+)
+
+// MaxDeadLetters is the number of dead letters that WebhookStorage keeps.
+// Once there are more, the oldest ones are deleted.
+const MaxDeadLetters = 10000
+
+// WebhookStorage is the webhook storage service.
+type WebhookStorage struct {
+	webhooks    *persist.Map[webhook.ID, webhook.Webhook]
+	deadLetters *persist.Map[webhook.ID, webhook.DeadLetter]
+
+	// deadLettersMu guards numDeadLetters, which counts the stored dead
+	// letters so that the oldest can be deleted once there are more than
+	// maxDeadLetters.
+	deadLettersMu  sync.Mutex
+	numDeadLetters int
+	maxDeadLetters int
+}
+
+var _ webhook.Storage = (*WebhookStorage)(nil)
+
+func newWebhookStorage(manager *StorageManager) (*WebhookStorage, error) {
+	webhooks, err := openJSONMap[webhook.ID, webhook.Webhook](manager, "webhooks")
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters, err := openJSONMap[webhook.ID, webhook.DeadLetter](manager, "webhook_dead_letters")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &WebhookStorage{
+		webhooks:       webhooks,
+		deadLetters:    deadLetters,
+		maxDeadLetters: MaxDeadLetters,
+	}
+	deadLetters.Keys()(func(webhook.ID) bool {
+		s.numDeadLetters++
+		return true
+	})
+
+	return s, nil
+}
+
+// openJSONMap opens a map whose values are stored as JSON.
+func openJSONMap[K comparable, V any](manager *StorageManager, name string) (*persist.Map[K, V], error) {
+	path, err := manager.pathFor(name)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := badgerdb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return persist.NewMapFromEncoders(driver, persist.EncoderPair[K, V]{
+		Key:   persist.CBOREncoder[K](),
+		Value: jsonEncoder[V]{},
+	}), nil
+}
+
+func (s *WebhookStorage) StoreWebhook(w webhook.Webhook) error {
+	return s.webhooks.Store(w.ID, w)
+}
+
+func (s *WebhookStorage) DeleteWebhook(id webhook.ID) error {
+	_, ok, err := s.webhooks.LoadAndDelete(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return webhook.ErrNotFound
+	}
+	return nil
+}
+
+func (s *WebhookStorage) ListWebhooks() ([]webhook.Webhook, error) {
+	var webhooks []webhook.Webhook
+	s.webhooks.All()(func(_ webhook.ID, w webhook.Webhook) bool {
+		webhooks = append(webhooks, w)
+		return true
+	})
+	return webhooks, nil
+}
+
+func (s *WebhookStorage) AppendDeadLetter(letter webhook.DeadLetter) error {
+	s.deadLettersMu.Lock()
+	defer s.deadLettersMu.Unlock()
+
+	if err := s.deadLetters.Store(letter.DeliveryID, letter); err != nil {
+		return err
+	}
+	s.numDeadLetters++
+
+	// Delivery IDs are ULIDs, which are stored in order, so the first keys
+	// are the oldest dead letters.
+	var oldest []webhook.ID
+	s.deadLetters.Keys()(func(id webhook.ID) bool {
+		if len(oldest) >= s.numDeadLetters-s.maxDeadLetters {
+			return false
+		}
+		oldest = append(oldest, id)
+		return true
+	})
+	for _, id := range oldest {
+		if err := s.deadLetters.Delete(id); err != nil {
+			return err
+		}
+		s.numDeadLetters--
+	}
+
+	return nil
+}
+
+func (s *WebhookStorage) ListDeadLetters() ([]webhook.DeadLetter, error) {
+	var letters []webhook.DeadLetter
+	s.deadLetters.All()(func(_ webhook.ID, letter webhook.DeadLetter) bool {
+		letters = append(letters, letter)
+		return true
+	})
+
+	// Delivery IDs are ULIDs, so they sort by the time that the delivery was
+	// first queued.
+	slices.SortFunc(letters, func(a, b webhook.DeadLetter) int {
+		return bytes.Compare(a.DeliveryID[:], b.DeliveryID[:])
+	})
+
+	return letters, nil
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/oklog/ulid/v2"
+	"libdb.so/scouts-server/api/webhook"
+)
+
+func TestWebhookStorageDeadLetterRetention(t *testing.T) {
+	storage, err := NewStorageManager(t.TempDir()).OpenWebhookStorage()
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		storage.webhooks.Close()
+		storage.deadLetters.Close()
+	})
+	storage.maxDeadLetters = 3
+
+	// IDs are generated a millisecond apart so that they sort in order.
+	var ids []webhook.ID
+	for i := 0; i < 5; i++ {
+		id := webhook.ID(ulid.MustNew(uint64(i+1), rand.Reader))
+		ids = append(ids, id)
+		assert.NoError(t, storage.AppendDeadLetter(webhook.DeadLetter{DeliveryID: id}))
+	}
+
+	letters, err := storage.ListDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(letters), "only the newest dead letters should be kept")
+	for i, letter := range letters {
+		assert.Equal(t, ids[i+2], letter.DeliveryID)
+	}
+}
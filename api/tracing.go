@@ -0,0 +1,76 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("libdb.so/scouts-server/api")
+
+// traceRequests starts a span for each request, which continues the trace of
+// the client if the request carries one. The span is named after the route
+// once the request has been routed.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path)))
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if route := chi.RouteContext(r.Context()).RoutePattern(); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+
+		status := responseStatus(ww)
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// logRequests logs each request once it has been served. Unlike
+// middleware.Logger, the records carry the context of the request, so that
+// they can be tied to its trace.
+func logRequests(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			logger.InfoContext(r.Context(),
+				fmt.Sprintf("%s %s", r.Method, r.URL.Path),
+				"status", responseStatus(ww),
+				"bytes", ww.BytesWritten(),
+				"duration", time.Since(start),
+				"remote_addr", r.RemoteAddr)
+		})
+	}
+}
+
+// responseStatus returns the status code of the response, which is 200 if the
+// handler never wrote the header.
+func responseStatus(w middleware.WrapResponseWriter) int {
+	if status := w.Status(); status != 0 {
+		return status
+	}
+	return http.StatusOK
+}
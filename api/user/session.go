@@ -2,8 +2,11 @@ package user
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/puzpuzpuz/xsync/v3"
@@ -46,6 +49,42 @@ func (t *SessionToken) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// Handle returns the handle of the session token.
+func (t SessionToken) Handle() SessionHandle {
+	sum := sha256.Sum256(t[:])
+	var handle SessionHandle
+	copy(handle[:], sum[:])
+	return handle
+}
+
+// SessionHandle identifies a session without revealing its token, so that
+// sessions can be listed and revoked without handing out their tokens. It is
+// a truncated SHA-256 hash of the token.
+type SessionHandle [8]byte
+
+// String returns the string representation of the session handle.
+func (h SessionHandle) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// MarshalText marshals the session handle into text.
+func (h SessionHandle) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText unmarshals the session handle from text.
+func (h *SessionHandle) UnmarshalText(text []byte) error {
+	data, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	if len(data) != len(h) {
+		return fmt.Errorf("session handle must be %d bytes long", len(h))
+	}
+	copy(h[:], data)
+	return nil
+}
+
 // SessionTTL is the time-to-live of a session.
 // Storages don't need to enforce this TTL, but they should be able to handle
 // sessions that have exceeded this TTL.
@@ -63,14 +102,44 @@ type SessionStorage interface {
 	QuerySession(SessionToken) (*UserID, error)
 }
 
+// Session is a session as listed by SessionAdminStorage.
+type Session struct {
+	// Handle identifies the session. The token itself is not listed, since
+	// it would let anyone who sees the list act as the session's user.
+	Handle SessionHandle `json:"handle"`
+	// UserID is the user that the session is associated with.
+	// If nil, then the session is anonymous.
+	UserID *UserID `json:"user_id,omitempty"`
+	// ExpiresAt is the time that the session expires.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SessionAdminStorage is a SessionStorage that also lets operators list and
+// revoke sessions.
+type SessionAdminStorage interface {
+	SessionStorage
+	// ListSessions lists all sessions that have not expired.
+	ListSessions() ([]Session, error)
+	// RevokeSession deletes the session with the given handle, so that it
+	// can no longer be used. ErrSessionNotFound is returned if there is no
+	// such session.
+	RevokeSession(SessionHandle) error
+}
+
+// ErrSessionAdminUnsupported is an error that indicates that a session storage
+// cannot list or revoke sessions.
+var ErrSessionAdminUnsupported = hrt.NewHTTPError(501, "session storage cannot list or revoke sessions")
+
 // CachedSessionStorage is a session storage that caches session data.
 // Sessions wrapped by this storage are cached for about 5 minutes.
 type CachedSessionStorage struct {
 	storage SessionStorage
 	cache   xsync.MapOf[SessionToken, cachedSession]
+	hits    atomic.Uint64
+	misses  atomic.Uint64
 }
 
-var _ SessionStorage = (*CachedSessionStorage)(nil)
+var _ SessionAdminStorage = (*CachedSessionStorage)(nil)
 
 // SessionCacheTTL is the time-to-live of a session cache entry.
 // This should be less than SessionTTL.
@@ -122,10 +191,12 @@ func (s *CachedSessionStorage) ChangeSession(token SessionToken, userID *UserID)
 func (s *CachedSessionStorage) QuerySession(token SessionToken) (*UserID, error) {
 	if session, ok := s.cache.Load(token); ok {
 		if session.expiry.After(time.Now()) {
+			s.hits.Add(1)
 			return session.userID, nil
 		}
 		s.cache.Delete(token)
 	}
+	s.misses.Add(1)
 	userID, err := s.storage.QuerySession(token)
 	if err != nil {
 		return userID, err
@@ -136,3 +207,49 @@ func (s *CachedSessionStorage) QuerySession(token SessionToken) (*UserID, error)
 	})
 	return userID, nil
 }
+
+// ListSessions lists all sessions of the underlying storage. If it is not a
+// SessionAdminStorage, then ErrSessionAdminUnsupported is returned.
+func (s *CachedSessionStorage) ListSessions() ([]Session, error) {
+	storage, ok := s.storage.(SessionAdminStorage)
+	if !ok {
+		return nil, ErrSessionAdminUnsupported
+	}
+	return storage.ListSessions()
+}
+
+// RevokeSession revokes the session in the underlying storage and drops it
+// from the cache. If the underlying storage is not a SessionAdminStorage, then
+// ErrSessionAdminUnsupported is returned.
+func (s *CachedSessionStorage) RevokeSession(handle SessionHandle) error {
+	storage, ok := s.storage.(SessionAdminStorage)
+	if !ok {
+		return ErrSessionAdminUnsupported
+	}
+	err := storage.RevokeSession(handle)
+	// Drop the session from the cache even if revoking it failed, so that
+	// the next query asks the storage about it.
+	s.cache.Range(func(token SessionToken, _ cachedSession) bool {
+		if token.Handle() == handle {
+			s.cache.Delete(token)
+		}
+		return true
+	})
+	return err
+}
+
+// SessionCacheStats counts the queries answered by a CachedSessionStorage.
+type SessionCacheStats struct {
+	// Hits is the number of queries that were answered from the cache.
+	Hits uint64
+	// Misses is the number of queries that went to the underlying storage.
+	Misses uint64
+}
+
+// CacheStats returns how many session queries hit and missed the cache.
+func (s *CachedSessionStorage) CacheStats() SessionCacheStats {
+	return SessionCacheStats{
+		Hits:   s.hits.Load(),
+		Misses: s.misses.Load(),
+	}
+}
@@ -109,3 +109,13 @@ func AuthorizationEq(a, b *Authorization) bool {
 func (u Authorization) Eq(other Authorization) bool {
 	return u.session == other.session
 }
+
+// SameUser returns true if both authorized users are the same person: either
+// they are logged in as the same user, possibly on different sessions, or they
+// share the same session.
+func (u Authorization) SameUser(other Authorization) bool {
+	if u.UserID != nil && other.UserID != nil {
+		return *u.UserID == *other.UserID
+	}
+	return u.Eq(other)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/gameserver"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/internal/context"
+	"libdb.so/scouts-server/internal/unmarshal"
+)
+
+type userServices struct {
+	*gameserver.GameManager
+}
+
+type userHandler struct {
+	service userServices
+}
+
+func mountUserHandler(r chi.Router, service userServices) {
+	h := &userHandler{service: service}
+	r.Route("/users/{id}", func(r chi.Router) {
+		r.Use(h.parseUserID)
+		r.Get("/rating", hrt.Wrap(h.userRating))
+	})
+}
+
+func (h *userHandler) parseUserID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idstr := chi.URLParam(r, "id")
+		if idstr == "" {
+			err := errors.New("missing user ID")
+			errorWriter.WriteError(w, hrt.WrapHTTPError(http.StatusBadRequest, err))
+			return
+		}
+
+		id, err := unmarshal.Text[*user.UserID](idstr)
+		if err != nil {
+			errorWriter.WriteError(w, hrt.WrapHTTPError(http.StatusBadRequest, err))
+			return
+		}
+
+		ctx := context.With(r.Context(), *id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *userHandler) userRating(ctx context.Context, _ hrt.None) (gameserver.UserRatings, error) {
+	userID := context.From[user.UserID](ctx)
+	return h.service.QueryRatings(userID)
+}
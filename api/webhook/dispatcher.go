@@ -0,0 +1,618 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"libdb.so/scouts-server/api/gameserver"
+	"libdb.so/scouts-server/internal/clock"
+	"libdb.so/scouts-server/internal/pubsub"
+)
+
+// DispatcherOptions configures the delivery of webhooks.
+type DispatcherOptions struct {
+	// MaxAttempts is the number of times that a delivery is attempted before
+	// it is dead-lettered.
+	MaxAttempts int
+	// Backoff is how long to wait before the first retry. The wait doubles
+	// after every retry, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout is how long each attempt may take.
+	Timeout time.Duration
+	// Workers is the number of deliveries that are attempted at once.
+	Workers int
+	// MaxQueued is the number of deliveries that may wait to be attempted.
+	// Deliveries over it are dead-lettered right away, so that a slow or
+	// unreachable webhook cannot grow the queue without bound.
+	MaxQueued int
+	// Client is used to make deliveries. If nil, then a client that does not
+	// follow redirects is used.
+	Client *http.Client
+	// Clock is used to time retries and to timestamp deliveries. If nil, then
+	// the real clock is used.
+	Clock clock.Clock
+}
+
+// DefaultDispatcherOptions are the defaults of any zero DispatcherOptions
+// field.
+var DefaultDispatcherOptions = DispatcherOptions{
+	MaxAttempts: 5,
+	Backoff:     5 * time.Second,
+	MaxBackoff:  5 * time.Minute,
+	Timeout:     10 * time.Second,
+	Workers:     4,
+	MaxQueued:   1000,
+}
+
+func (o DispatcherOptions) withDefaults() DispatcherOptions {
+	if o.MaxAttempts == 0 {
+		o.MaxAttempts = DefaultDispatcherOptions.MaxAttempts
+	}
+	if o.Backoff == 0 {
+		o.Backoff = DefaultDispatcherOptions.Backoff
+	}
+	if o.MaxBackoff == 0 {
+		o.MaxBackoff = DefaultDispatcherOptions.MaxBackoff
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DefaultDispatcherOptions.Timeout
+	}
+	if o.Workers == 0 {
+		o.Workers = DefaultDispatcherOptions.Workers
+	}
+	if o.MaxQueued == 0 {
+		o.MaxQueued = DefaultDispatcherOptions.MaxQueued
+	}
+	if o.Client == nil {
+		o.Client = &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	return o
+}
+
+// errDispatcherStopped is the reason given to deliveries that were still
+// pending when the dispatcher was stopped.
+var errDispatcherStopped = errors.New("dispatcher stopped before delivery")
+
+// errQueueFull is the reason given to deliveries that were dropped because
+// too many deliveries were already queued.
+var errQueueFull = errors.New("too many deliveries queued")
+
+// statusError is returned when a webhook responds with a non-2xx status.
+type statusError struct {
+	code int
+}
+
+func (err statusError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d %s", err.code, http.StatusText(err.code))
+}
+
+// temporary returns true if the delivery may succeed if it is retried.
+func (err statusError) temporary() bool {
+	switch {
+	case err.code == http.StatusRequestTimeout, err.code == http.StatusTooManyRequests:
+		return true
+	default:
+		return err.code >= 500
+	}
+}
+
+// delivery is a payload to be POSTed to a single webhook.
+type delivery struct {
+	id        ID
+	webhook   Webhook
+	eventType EventType
+	body      []byte
+	attempts  int
+	// err is why the last attempt failed.
+	err error
+	// failedAt is the time that the delivery was dead-lettered.
+	failedAt time.Time
+}
+
+// Dispatcher delivers the lifecycle events of games to the registered
+// webhooks. It implements gameserver.GameObserver, so that it is fed the
+// events that games publish to their subscribers.
+//
+// Each delivery is signed with the secret of its webhook and retried with
+// exponential backoff. Deliveries that fail too many times, that don't fit in
+// the queue or that are still pending once the dispatcher is stopped are
+// recorded as dead letters. Dead letters are written to storage on their own
+// goroutine, so that games are never held up by storage.
+type Dispatcher struct {
+	storage Storage
+	opts    DispatcherOptions
+	clock   clock.Clock
+	logger  *slog.Logger
+
+	webhooksMu sync.RWMutex
+	webhooks   []Webhook
+
+	queue *pubsub.ConcurrentQueue[*delivery]
+	// queued is the number of deliveries in the queue.
+	queued atomic.Int64
+	// deadLetters is the buffer of the goroutine that writes dead letters,
+	// which closes writerDone once it has written them all.
+	deadLetters chan *delivery
+	writerDone  chan struct{}
+	// queueMu guards sending to the queue and to deadLetters against them
+	// being closed.
+	queueMu       sync.RWMutex
+	queueClosed   bool
+	writerStopped bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var _ gameserver.GameObserver = (*Dispatcher)(nil)
+
+// NewDispatcher creates a new dispatcher for the webhooks in storage. Events
+// are queued until BeginDelivery is called.
+func NewDispatcher(storage Storage, logger *slog.Logger, opts DispatcherOptions) (*Dispatcher, error) {
+	webhooks, err := storage.ListWebhooks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opts = opts.withDefaults()
+
+	d := &Dispatcher{
+		storage:     storage,
+		opts:        opts,
+		clock:       clock.Or(opts.Clock),
+		logger:      logger.With("component", "api/webhook/dispatcher"),
+		webhooks:    webhooks,
+		queue:       pubsub.NewConcurrentQueue[*delivery](nil),
+		deadLetters: make(chan *delivery, opts.MaxQueued),
+		writerDone:  make(chan struct{}),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	d.queue.Start()
+	go d.writeDeadLetters()
+
+	return d, nil
+}
+
+// RegisterWebhook registers a new webhook that is notified of the given types
+// of events, or of all of them if none are given. The returned webhook
+// contains its secret.
+func (d *Dispatcher) RegisterWebhook(url string, events []EventType) (Webhook, error) {
+	if err := validateURL(url); err != nil {
+		return Webhook{}, err
+	}
+	for _, t := range events {
+		if err := t.Validate(); err != nil {
+			return Webhook{}, err
+		}
+	}
+
+	webhook := Webhook{
+		ID:        GenerateID(),
+		URL:       url,
+		Secret:    generateSecret(),
+		Events:    slices.Clone(events),
+		CreatedAt: d.clock.Now(),
+	}
+
+	d.webhooksMu.Lock()
+	defer d.webhooksMu.Unlock()
+
+	if err := d.storage.StoreWebhook(webhook); err != nil {
+		return Webhook{}, err
+	}
+	d.webhooks = append(d.webhooks, webhook)
+
+	d.logger.Info(
+		"webhook has been registered",
+		"webhook_id", webhook.ID,
+		"url", webhook.URL,
+		"events", webhook.Events)
+
+	return webhook, nil
+}
+
+// DeleteWebhook deletes the webhook with the given ID. Its pending
+// deliveries are dropped.
+func (d *Dispatcher) DeleteWebhook(id ID) error {
+	d.webhooksMu.Lock()
+	defer d.webhooksMu.Unlock()
+
+	if err := d.storage.DeleteWebhook(id); err != nil {
+		return err
+	}
+	d.webhooks = slices.DeleteFunc(d.webhooks, func(w Webhook) bool { return w.ID == id })
+
+	d.logger.Info(
+		"webhook has been deleted",
+		"webhook_id", id)
+
+	return nil
+}
+
+// ListWebhooks lists all webhooks without their secrets, oldest first.
+func (d *Dispatcher) ListWebhooks() []Webhook {
+	d.webhooksMu.RLock()
+	defer d.webhooksMu.RUnlock()
+
+	webhooks := make([]Webhook, len(d.webhooks))
+	for i, w := range d.webhooks {
+		w.Secret = ""
+		webhooks[i] = w
+	}
+	slices.SortFunc(webhooks, func(a, b Webhook) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return webhooks
+}
+
+// ListDeadLetters lists the deliveries that could not be made, oldest first.
+func (d *Dispatcher) ListDeadLetters() ([]DeadLetter, error) {
+	return d.storage.ListDeadLetters()
+}
+
+// hasWebhook returns true if the webhook with the given ID still exists.
+func (d *Dispatcher) hasWebhook(id ID) bool {
+	d.webhooksMu.RLock()
+	defer d.webhooksMu.RUnlock()
+
+	return slices.ContainsFunc(d.webhooks, func(w Webhook) bool { return w.ID == id })
+}
+
+// wants returns true if any webhook is notified of events of type t.
+func (d *Dispatcher) wants(t EventType) bool {
+	d.webhooksMu.RLock()
+	defer d.webhooksMu.RUnlock()
+
+	return slices.ContainsFunc(d.webhooks, func(w Webhook) bool { return w.wants(t) })
+}
+
+// GameCreated implements gameserver.GameObserver.
+func (d *Dispatcher) GameCreated(state gameserver.GameState) {
+	if !d.wants(GameCreated) {
+		return
+	}
+	d.dispatch(Payload{
+		Type:   GameCreated,
+		GameID: state.GameID,
+		Time:   state.CreatedAt,
+		Game:   &state,
+	})
+}
+
+// GameBegan implements gameserver.GameObserver.
+func (d *Dispatcher) GameBegan(state gameserver.GameState, turn gameserver.TurnBeginEvent) {
+	if !d.wants(GameBegan) {
+		return
+	}
+	d.dispatch(Payload{
+		Type:   GameBegan,
+		GameID: state.GameID,
+		Time:   *state.BeganAt,
+		Game:   &state,
+		Event:  turn,
+	})
+}
+
+// GameEvents implements gameserver.GameObserver.
+func (d *Dispatcher) GameEvents(id gameserver.GameID, events []gameserver.GameEvent, state func() gameserver.GameState) {
+	var snapshot *gameserver.GameState
+	getState := func() *gameserver.GameState {
+		if snapshot == nil {
+			s := state()
+			snapshot = &s
+		}
+		return snapshot
+	}
+
+	for _, ev := range events {
+		payload := Payload{
+			GameID: id,
+			Time:   ev.Header().ServerTime,
+			Event:  ev,
+		}
+
+		switch ev.(type) {
+		case gameserver.MoveMadeEvent:
+			if !d.wants(MoveMade) {
+				continue
+			}
+			payload.Type = MoveMade
+		case gameserver.GameEndEvent:
+			if !d.wants(GameEnded) {
+				continue
+			}
+			payload.Type = GameEnded
+			payload.Game = getState()
+		default:
+			continue
+		}
+
+		d.dispatch(payload)
+	}
+}
+
+// dispatch queues a delivery of the payload to every webhook that wants it.
+func (d *Dispatcher) dispatch(payload Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		d.logger.Error(
+			"failed to marshal webhook payload",
+			"type", payload.Type,
+			"game_id", payload.GameID,
+			"err", err)
+		return
+	}
+
+	d.webhooksMu.RLock()
+	defer d.webhooksMu.RUnlock()
+
+	for _, w := range d.webhooks {
+		if w.wants(payload.Type) {
+			d.enqueue(&delivery{
+				id:        GenerateID(),
+				webhook:   w,
+				eventType: payload.Type,
+				body:      body,
+			})
+		}
+	}
+}
+
+// enqueue queues a delivery to be attempted, or dead-letters it if the
+// dispatcher has been stopped or if the queue is full.
+func (d *Dispatcher) enqueue(dl *delivery) {
+	d.queueMu.RLock()
+	defer d.queueMu.RUnlock()
+
+	switch {
+	case d.queueClosed:
+		if dl.err == nil {
+			dl.err = errDispatcherStopped
+		}
+	case d.queued.Add(1) > int64(d.opts.MaxQueued):
+		d.queued.Add(-1)
+		if dl.err == nil {
+			dl.err = errQueueFull
+		}
+	default:
+		d.queue.In() <- dl
+		return
+	}
+
+	d.sendDeadLetter(dl, false)
+}
+
+// BeginDelivery starts delivering the queued events. Calling the returned
+// function stops the delivery, aborts the attempts in progress and records
+// every delivery that has yet to be made as a dead letter. Deliveries queued
+// after that are dropped.
+func (d *Dispatcher) BeginDelivery() (stop func()) {
+	for i := 0; i < d.opts.Workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for dl := range d.queue.Out() {
+				d.queued.Add(-1)
+				d.attempt(dl)
+			}
+		}()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.queueMu.Lock()
+			d.queueClosed = true
+			d.queue.Close()
+			d.queueMu.Unlock()
+
+			d.cancel()
+			d.wg.Wait()
+
+			// Nothing else can be dead-lettered once the workers and
+			// retries are done, except for new deliveries.
+			d.queueMu.Lock()
+			d.writerStopped = true
+			close(d.deadLetters)
+			d.queueMu.Unlock()
+
+			<-d.writerDone
+		})
+	}
+}
+
+// attempt attempts a delivery, and schedules a retry if it fails.
+func (d *Dispatcher) attempt(dl *delivery) {
+	if !d.hasWebhook(dl.webhook.ID) {
+		d.logger.Debug(
+			"dropping delivery of deleted webhook",
+			"webhook_id", dl.webhook.ID,
+			"delivery_id", dl.id)
+		return
+	}
+
+	if d.ctx.Err() != nil {
+		if dl.err == nil {
+			dl.err = errDispatcherStopped
+		}
+		d.deadLetter(dl)
+		return
+	}
+
+	dl.attempts++
+	dl.err = d.post(dl)
+	if dl.err == nil {
+		d.logger.Debug(
+			"webhook has been delivered",
+			"webhook_id", dl.webhook.ID,
+			"delivery_id", dl.id,
+			"type", dl.eventType,
+			"attempts", dl.attempts)
+		return
+	}
+
+	var statusErr statusError
+	if errors.As(dl.err, &statusErr) && !statusErr.temporary() {
+		d.deadLetter(dl)
+		return
+	}
+	if dl.attempts >= d.opts.MaxAttempts || d.ctx.Err() != nil {
+		d.deadLetter(dl)
+		return
+	}
+
+	backoff := d.backoff(dl.attempts)
+	d.logger.Warn(
+		"webhook delivery failed, retrying",
+		"webhook_id", dl.webhook.ID,
+		"delivery_id", dl.id,
+		"attempts", dl.attempts,
+		"backoff", backoff,
+		"err", dl.err)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		timer := d.clock.NewTimer(backoff)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C():
+			d.enqueue(dl)
+		case <-d.ctx.Done():
+			d.deadLetter(dl)
+		}
+	}()
+}
+
+// backoff returns how long to wait before retrying a delivery that has been
+// attempted the given number of times.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.opts.Backoff
+	for i := 1; i < attempts && backoff < d.opts.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, d.opts.MaxBackoff)
+}
+
+// post makes a single attempt at a delivery.
+func (d *Dispatcher) post(dl *delivery) error {
+	ctx, cancel := context.WithTimeout(d.ctx, d.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.webhook.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := d.clock.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "scouts-server-webhook")
+	req.Header.Set(EventHeader, string(dl.eventType))
+	req.Header.Set(DeliveryHeader, dl.id.String())
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(dl.webhook.Secret, timestamp, dl.body))
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drain some of the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError{resp.StatusCode}
+	}
+	return nil
+}
+
+// deadLetter records a delivery that could not be made. It waits for the
+// goroutine that writes dead letters to catch up, so it must not be called on
+// the goroutine of a game.
+func (d *Dispatcher) deadLetter(dl *delivery) {
+	d.queueMu.RLock()
+	defer d.queueMu.RUnlock()
+
+	d.sendDeadLetter(dl, true)
+}
+
+// sendDeadLetter hands a delivery that could not be made to the goroutine
+// that writes dead letters. Unless wait is true, the delivery is dropped if
+// that goroutine is behind, since this may be called on the goroutine of a
+// game. queueMu must be held.
+func (d *Dispatcher) sendDeadLetter(dl *delivery, wait bool) {
+	dl.failedAt = d.clock.Now()
+
+	d.logger.Warn(
+		"webhook delivery failed, recording it as a dead letter",
+		"webhook_id", dl.webhook.ID,
+		"delivery_id", dl.id,
+		"type", dl.eventType,
+		"attempts", dl.attempts,
+		"err", dl.err)
+
+	switch {
+	case d.writerStopped:
+	case wait:
+		d.deadLetters <- dl
+		return
+	default:
+		select {
+		case d.deadLetters <- dl:
+			return
+		default:
+		}
+	}
+
+	d.logger.Error(
+		"failed to record dead letter, dropping it",
+		"delivery_id", dl.id,
+		"stopped", d.writerStopped)
+}
+
+// writeDeadLetters writes the dead letters that are sent to it to storage
+// until the dispatcher is stopped.
+func (d *Dispatcher) writeDeadLetters() {
+	defer close(d.writerDone)
+
+	for dl := range d.deadLetters {
+		err := d.storage.AppendDeadLetter(DeadLetter{
+			DeliveryID: dl.id,
+			WebhookID:  dl.webhook.ID,
+			URL:        dl.webhook.URL,
+			Type:       dl.eventType,
+			Payload:    dl.body,
+			Attempts:   dl.attempts,
+			Error:      dl.err.Error(),
+			FailedAt:   dl.failedAt,
+		})
+		if err != nil {
+			d.logger.Error(
+				"failed to record dead letter",
+				"delivery_id", dl.id,
+				"err", err)
+		}
+	}
+}
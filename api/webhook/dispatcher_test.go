@@ -0,0 +1,331 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/neilotoole/slogt"
+	"libdb.so/scouts-server/api/gameserver"
+	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/scouts"
+)
+
+func TestDispatcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Record each delivery once its response has been received, so that
+	// stopping the dispatcher doesn't abort any attempt.
+	deliveries := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		deliveries <- r
+		bodies <- body
+		return resp, err
+	})}
+
+	storage := newMemoryStorage()
+	d, err := NewDispatcher(storage, slogt.New(t), DispatcherOptions{Client: client})
+	assert.NoError(t, err)
+	stop := d.BeginDelivery()
+	defer stop()
+
+	_, err = d.RegisterWebhook("ftp://example.com", nil)
+	assert.IsError(t, err, ErrInvalidURL)
+	_, err = d.RegisterWebhook(server.URL, []EventType{"game_paused"})
+	assert.Error(t, err)
+
+	w, err := d.RegisterWebhook(server.URL, []EventType{GameCreated, GameBegan, GameEnded})
+	assert.NoError(t, err)
+	assert.NotZero(t, w.Secret)
+	assert.Equal(t, []Webhook{{ID: w.ID, URL: w.URL, Events: w.Events, CreatedAt: w.CreatedAt}}, d.ListWebhooks())
+
+	games := gameserver.NewGameManager(slogt.New(t), gameserver.GameManagerOptions{Observer: d})
+
+	user1 := user.NewAuthorized(user.GenerateSessionToken(), 1)
+	user2 := user.NewAuthorized(user.GenerateSessionToken(), 2)
+
+	id, err := games.CreateGame(user1, gameserver.CreateGameOptions{AutoJoin: true, Side: gameserver.SideA})
+	assert.NoError(t, err)
+	_, err = games.JoinGame(user2, id, "")
+	assert.NoError(t, err)
+	assert.NoError(t, games.AdjudicateGame(id, scouts.PlayerB))
+
+	// Deliveries are made concurrently, so they may arrive out of order.
+	type payload struct {
+		Type   EventType         `json:"type"`
+		GameID gameserver.GameID `json:"game_id"`
+		Time   time.Time         `json:"time"`
+		Game   *struct {
+			BeganAt *time.Time    `json:"began_at"`
+			Winner  scouts.Player `json:"winner"`
+		} `json:"game"`
+	}
+	payloads := map[EventType]payload{}
+	for i := 0; i < 3; i++ {
+		r := <-deliveries
+		body := <-bodies
+
+		assert.NotZero(t, r.Header.Get(DeliveryHeader))
+		assert.True(t, Verify(w.Secret, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body))
+		assert.False(t, Verify("wrong", r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body))
+
+		var p payload
+		assert.NoError(t, json.Unmarshal(body, &p))
+		assert.Equal(t, string(p.Type), r.Header.Get(EventHeader))
+		assert.Equal(t, id, p.GameID)
+		assert.NotZero(t, p.Game)
+		payloads[p.Type] = p
+	}
+
+	assert.Equal(t, 3, len(payloads))
+	assert.Zero(t, payloads[GameCreated].Game.BeganAt)
+	assert.NotZero(t, payloads[GameBegan].Game.BeganAt)
+	assert.True(t, payloads[GameBegan].Game.BeganAt.Equal(payloads[GameBegan].Time))
+	assert.Equal(t, scouts.PlayerB, payloads[GameEnded].Game.Winner)
+
+	assert.NoError(t, d.DeleteWebhook(w.ID))
+	assert.IsError(t, d.DeleteWebhook(w.ID), ErrNotFound)
+	assert.Equal(t, 0, len(d.ListWebhooks()))
+
+	_, err = games.CreateGame(user1, gameserver.CreateGameOptions{})
+	assert.NoError(t, err)
+
+	stop()
+	assert.Equal(t, 0, len(deliveries))
+
+	letters, err := d.ListDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(letters))
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	statuses := map[string][]int{
+		"/flaky":     {http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+		"/down":      {http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+		"/forbidden": {http.StatusForbidden},
+	}
+	var mu sync.Mutex
+	attempts := map[string]int{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		status := statuses[r.URL.Path][attempts[r.URL.Path]]
+		attempts[r.URL.Path]++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	// Signal once each response has been received, so that stopping the
+	// dispatcher doesn't abort any attempt.
+	done := make(chan struct{}, 10)
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		defer func() { done <- struct{}{} }()
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+
+	storage := newMemoryStorage()
+	d, err := NewDispatcher(storage, slogt.New(t), DispatcherOptions{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		Client:      client,
+	})
+	assert.NoError(t, err)
+	stop := d.BeginDelivery()
+	defer stop()
+
+	for _, path := range []string{"/flaky", "/down", "/forbidden"} {
+		_, err := d.RegisterWebhook(server.URL+path, []EventType{MoveMade})
+		assert.NoError(t, err)
+	}
+
+	d.GameEvents(gameserver.GenerateGameID(), []gameserver.GameEvent{
+		gameserver.TurnBeginEvent{},
+		gameserver.MoveMadeEvent{
+			Move:       &scouts.PlaceScoutMove{ScoutPosition: scouts.Point{X: 0, Y: 9}},
+			PlayerSide: scouts.PlayerA,
+		},
+	}, func() gameserver.GameState {
+		t.Error("state is not needed for move_made")
+		return gameserver.GameState{}
+	})
+
+	for i := 0; i < 3+3+1; i++ {
+		<-done
+	}
+
+	stop()
+	assert.Equal(t, map[string]int{"/flaky": 3, "/down": 3, "/forbidden": 1}, attempts)
+
+	letters, err := d.ListDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(letters))
+
+	byURL := map[string]DeadLetter{}
+	for _, letter := range letters {
+		byURL[letter.URL] = letter
+	}
+
+	down := byURL[server.URL+"/down"]
+	assert.Equal(t, MoveMade, down.Type)
+	assert.Equal(t, 3, down.Attempts)
+	assert.Contains(t, down.Error, "502")
+
+	forbidden := byURL[server.URL+"/forbidden"]
+	assert.Equal(t, 1, forbidden.Attempts)
+	assert.Contains(t, forbidden.Error, "403")
+	assert.Equal(t, string(down.Payload), string(forbidden.Payload))
+}
+
+func TestDispatcherStop(t *testing.T) {
+	storage := newMemoryStorage()
+	d, err := NewDispatcher(storage, slogt.New(t), DispatcherOptions{})
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	_, err = d.RegisterWebhook(server.URL, nil)
+	assert.NoError(t, err)
+
+	// The webhook never responds, so stopping the dispatcher dead-letters
+	// the delivery whether or not it has been attempted yet.
+	d.GameCreated(gameserver.GameState{GameID: gameserver.GenerateGameID()})
+	d.BeginDelivery()()
+
+	letters, err := d.ListDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, GameCreated, letters[0].Type)
+
+	// Events after the dispatcher has stopped are dropped, since dead letters
+	// are no longer written.
+	d.GameCreated(gameserver.GameState{GameID: gameserver.GenerateGameID()})
+
+	letters, err = d.ListDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(letters))
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	// Dead letters are not written until release is closed, so the events
+	// below would hold up the caller if it wrote them.
+	release := make(chan struct{})
+	storage := &blockingStorage{memoryStorage: newMemoryStorage(), release: release}
+
+	d, err := NewDispatcher(storage, slogt.New(t), DispatcherOptions{MaxQueued: 3})
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	_, err = d.RegisterWebhook(server.URL, nil)
+	assert.NoError(t, err)
+
+	// Delivery hasn't begun, so the first three deliveries wait in the queue
+	// and the rest are dead-lettered right away.
+	for i := 0; i < 6; i++ {
+		d.GameCreated(gameserver.GameState{GameID: gameserver.GenerateGameID()})
+	}
+
+	close(release)
+	d.BeginDelivery()()
+
+	letters, err := d.ListDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(letters))
+
+	var full int
+	for _, letter := range letters {
+		if letter.Error == errQueueFull.Error() {
+			full++
+		}
+	}
+	assert.Equal(t, 3, full, "deliveries over the limit should be dead-lettered")
+}
+
+type memoryStorage struct {
+	mu          sync.Mutex
+	webhooks    map[ID]Webhook
+	deadLetters []DeadLetter
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{webhooks: make(map[ID]Webhook)}
+}
+
+func (s *memoryStorage) StoreWebhook(w Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks[w.ID] = w
+	return nil
+}
+
+func (s *memoryStorage) DeleteWebhook(id ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.webhooks, id)
+	return nil
+}
+
+func (s *memoryStorage) ListWebhooks() ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var webhooks []Webhook
+	for _, w := range s.webhooks {
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+func (s *memoryStorage) AppendDeadLetter(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadLetters = append(s.deadLetters, letter)
+	return nil
+}
+
+func (s *memoryStorage) ListDeadLetters() ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]DeadLetter(nil), s.deadLetters...), nil
+}
+
+// blockingStorage is a memoryStorage whose AppendDeadLetter blocks until
+// release is closed.
+type blockingStorage struct {
+	*memoryStorage
+	release chan struct{}
+}
+
+func (s *blockingStorage) AppendDeadLetter(letter DeadLetter) error {
+	<-s.release
+	return s.memoryStorage.AppendDeadLetter(letter)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
@@ -0,0 +1,211 @@
+// Package webhook notifies other services of the lifecycle of games by
+// POSTing signed events to the URLs that operators have registered.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"libdb.so/hrt"
+	"libdb.so/scouts-server/api/gameserver"
+)
+
+var (
+	// ErrNotFound is returned when a webhook does not exist.
+	ErrNotFound = hrt.NewHTTPError(404, "webhook not found")
+	// ErrInvalidURL is returned when a webhook is registered with a URL that
+	// is not an absolute HTTP or HTTPS URL.
+	ErrInvalidURL = hrt.NewHTTPError(400, "webhook URL must be an absolute http or https URL")
+)
+
+// ID is the ID of a webhook or of a delivery.
+type ID ulid.ULID
+
+// GenerateID generates a new ID.
+func GenerateID() ID {
+	return ID(ulid.MustNew(ulid.Now(), rand.Reader))
+}
+
+// String returns the string representation of the ID.
+func (id ID) String() string {
+	return ulid.ULID(id).String()
+}
+
+// MarshalText marshals the ID into text.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText unmarshals the ID from text.
+func (id *ID) UnmarshalText(text []byte) error {
+	u, err := ulid.Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*id = ID(u)
+	return nil
+}
+
+// EventType is the type of event that a webhook is notified of.
+type EventType string
+
+const (
+	// GameCreated is sent once a game has been created.
+	GameCreated EventType = "game_created"
+	// GameBegan is sent once both players have joined a game and its first
+	// turn begins.
+	GameBegan EventType = "game_began"
+	// MoveMade is sent for every move made in a game.
+	MoveMade EventType = "move_made"
+	// GameEnded is sent once a game has ended.
+	GameEnded EventType = "game_ended"
+)
+
+// EventTypes lists all event types.
+var EventTypes = []EventType{GameCreated, GameBegan, MoveMade, GameEnded}
+
+// Validate returns an error if the event type is unknown.
+func (t EventType) Validate() error {
+	switch t {
+	case GameCreated, GameBegan, MoveMade, GameEnded:
+		return nil
+	default:
+		return hrt.NewHTTPError(400, fmt.Sprintf("unknown webhook event type %q", t))
+	}
+}
+
+// Webhook is a URL that events are POSTed to.
+type Webhook struct {
+	ID  ID     `json:"id"`
+	URL string `json:"url"`
+	// Secret is the key that deliveries are signed with. It is only given
+	// back when the webhook is registered.
+	Secret string `json:"secret,omitempty"`
+	// Events are the types of events that the webhook is notified of. If
+	// empty, then it is notified of all events.
+	Events    []EventType `json:"events"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// wants returns true if the webhook is notified of events of type t.
+func (w Webhook) wants(t EventType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// validateURL returns ErrInvalidURL if rawURL cannot be delivered to.
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+// generateSecret generates a new webhook secret.
+func generateSecret() string {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	Type   EventType         `json:"type"`
+	GameID gameserver.GameID `json:"game_id"`
+	// Time is when the event happened.
+	Time time.Time `json:"time"`
+	// Game is the state of the game right after the event. It is omitted
+	// for MoveMade.
+	Game *gameserver.GameState `json:"game,omitempty"`
+	// Event is the game event that caused the delivery, which is a
+	// TurnBeginEvent for GameBegan, a MoveMadeEvent for MoveMade and a
+	// GameEndEvent for GameEnded. It is omitted for GameCreated.
+	Event gameserver.GameEvent `json:"event,omitempty"`
+}
+
+// DeadLetter is a delivery that could not be made.
+type DeadLetter struct {
+	// DeliveryID is the ID of the delivery, which was sent as the
+	// X-Scouts-Delivery header.
+	DeliveryID ID        `json:"delivery_id"`
+	WebhookID  ID        `json:"webhook_id"`
+	URL        string    `json:"url"`
+	Type       EventType `json:"type"`
+	// Payload is the body of the delivery.
+	Payload json.RawMessage `json:"payload"`
+	// Attempts is the number of times that the delivery was attempted.
+	Attempts int `json:"attempts"`
+	// Error is the reason that the last attempt failed.
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Storage persists webhooks and dead letters.
+type Storage interface {
+	// StoreWebhook stores a webhook, replacing any with the same ID.
+	StoreWebhook(Webhook) error
+	// DeleteWebhook deletes a webhook. ErrNotFound is returned if it does not
+	// exist.
+	DeleteWebhook(ID) error
+	// ListWebhooks lists all webhooks.
+	ListWebhooks() ([]Webhook, error)
+	// AppendDeadLetter records a delivery that could not be made. Storages
+	// may delete the oldest dead letters to bound their size.
+	AppendDeadLetter(DeadLetter) error
+	// ListDeadLetters lists all dead letters, oldest first.
+	ListDeadLetters() ([]DeadLetter, error)
+}
+
+// Headers of every delivery.
+const (
+	// EventHeader is the type of the event.
+	EventHeader = "X-Scouts-Event"
+	// DeliveryHeader is the ID of the delivery, which stays the same across
+	// retries.
+	DeliveryHeader = "X-Scouts-Delivery"
+	// TimestampHeader is the Unix time in seconds that the delivery was
+	// attempted at.
+	TimestampHeader = "X-Scouts-Timestamp"
+	// SignatureHeader is the signature of the delivery, as returned by Sign.
+	SignatureHeader = "X-Scouts-Signature"
+)
+
+// Sign returns the signature of a delivery made at the given timestamp, which
+// is "sha256=" followed by the hex-encoded HMAC-SHA256 of the timestamp in
+// Unix seconds, a period and the body, keyed with the secret of the webhook.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return signTimestamp(secret, strconv.FormatInt(timestamp.Unix(), 10), body)
+}
+
+// Verify returns true if signature is the signature of body with the given
+// secret and the timestamp taken from TimestampHeader. Receivers should also
+// reject timestamps that are too old, so that deliveries cannot be replayed.
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	return hmac.Equal([]byte(signature), []byte(signTimestamp(secret, timestamp, body)))
+}
+
+func signTimestamp(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
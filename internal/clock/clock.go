@@ -0,0 +1,78 @@
+// Package clock abstracts over the system clock, so that code that depends on
+// the passing of time can be tested without waiting.
+package clock
+
+import "time"
+
+// Clock tells the time and creates timers and tickers.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer creates a timer that fires once after d.
+	NewTimer(d time.Duration) Timer
+	// NewTicker creates a ticker that fires every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is sent when the timer fires.
+	C() <-chan time.Time
+	// Stop stops the timer. False is returned if the timer had already fired
+	// or been stopped.
+	Stop() bool
+	// Reset changes the timer to fire after d. False is returned if the timer
+	// had already fired or been stopped.
+	Reset(d time.Duration) bool
+}
+
+// Ticker is like time.Ticker.
+type Ticker interface {
+	// C returns the channel on which the time is sent when the ticker fires.
+	C() <-chan time.Time
+	// Stop stops the ticker.
+	Stop()
+	// Reset stops the ticker and changes it to fire every d.
+	Reset(d time.Duration)
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+// Or returns c, or Real if c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time        { return t.timer.C }
+func (t realTimer) Stop() bool                 { return t.timer.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.timer.Reset(d) }
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time   { return t.ticker.C }
+func (t realTicker) Stop()                 { t.ticker.Stop() }
+func (t realTicker) Reset(d time.Duration) { t.ticker.Reset(d) }
@@ -0,0 +1,188 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only changes when it is set. Its timers and
+// tickers fire as soon as the time is set past their deadlines.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+	// timers are the timers and tickers that have yet to fire.
+	timers []*fakeTimer
+}
+
+var _ Clock = (*Fake)(nil)
+
+// NewFake creates a new fake clock that starts at now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now implements Clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the time forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(f.now.Add(d))
+}
+
+// Set sets the time to t. Timers and tickers with deadlines up to t fire in
+// the order of their deadlines.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(t)
+}
+
+func (f *Fake) set(t time.Time) {
+	for {
+		// Fire the timer with the earliest deadline first.
+		i := -1
+		for j, timer := range f.timers {
+			if timer.deadline.After(t) {
+				continue
+			}
+			if i == -1 || timer.deadline.Before(f.timers[i].deadline) {
+				i = j
+			}
+		}
+		if i == -1 {
+			break
+		}
+
+		timer := f.timers[i]
+		f.now = timer.deadline
+		timer.fire()
+
+		if timer.period > 0 {
+			timer.deadline = timer.deadline.Add(timer.period)
+		} else {
+			f.timers = slices.Delete(f.timers, i, i+1)
+		}
+	}
+
+	f.now = t
+}
+
+// Timers returns the number of timers and tickers that have yet to fire.
+func (f *Fake) Timers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// NewTimer implements Clock.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.newTimer(d, 0)
+}
+
+// NewTicker implements Clock.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.newTimer(d, d)}
+}
+
+func (f *Fake) newTimer(d, period time.Duration) *fakeTimer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{
+		clock:  f,
+		c:      make(chan time.Time, 1),
+		period: period,
+	}
+	f.schedule(t, d)
+	return t
+}
+
+// schedule makes the timer fire after d. f.mu must be held.
+func (f *Fake) schedule(t *fakeTimer, d time.Duration) {
+	t.deadline = f.now.Add(d)
+	if !slices.Contains(f.timers, t) {
+		f.timers = append(f.timers, t)
+	}
+	if d <= 0 {
+		f.set(f.now)
+	}
+}
+
+// unschedule stops the timer. False is returned if it had already fired or
+// been stopped. f.mu must be held.
+func (f *Fake) unschedule(t *fakeTimer) bool {
+	i := slices.Index(f.timers, t)
+	if i == -1 {
+		return false
+	}
+	f.timers = slices.Delete(f.timers, i, i+1)
+	return true
+}
+
+// fakeTimer is the Timer of a Fake clock. It also backs fakeTicker, in which
+// case it has a period.
+type fakeTimer struct {
+	clock    *Fake
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// fire sends the deadline to the channel, unless the previous time hasn't been
+// received yet, like the timers of the time package.
+func (t *fakeTimer) fire() {
+	select {
+	case t.c <- t.deadline:
+	default:
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	if t.period > 0 {
+		// Tickers keep firing every d from now on.
+		t.period = d
+	}
+
+	active := slices.Contains(t.clock.timers, t)
+	t.clock.schedule(t, d)
+	return active
+}
+
+// fakeTicker is the Ticker of a Fake clock.
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("clock: non-positive interval for Ticker.Reset")
+	}
+	t.fakeTimer.Reset(d)
+}
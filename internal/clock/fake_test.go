@@ -0,0 +1,58 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFake(start)
+
+	timer := clock.NewTimer(2 * time.Second)
+	ticker := clock.NewTicker(time.Second)
+
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(500*time.Millisecond), clock.Now())
+	assertNotFired(t, timer.C())
+	assertNotFired(t, ticker.C())
+
+	clock.Advance(time.Second)
+	assertFired(t, ticker.C(), start.Add(time.Second))
+	assertNotFired(t, timer.C())
+
+	clock.Advance(time.Second)
+	assertFired(t, timer.C(), start.Add(2*time.Second))
+	assertFired(t, ticker.C(), start.Add(2*time.Second))
+	assert.False(t, timer.Stop(), "fired timers cannot be stopped")
+
+	assert.False(t, timer.Reset(time.Second), "fired timers are not active")
+	ticker.Stop()
+	assert.Equal(t, 1, clock.Timers())
+
+	clock.Advance(time.Hour)
+	assertFired(t, timer.C(), start.Add(3500*time.Millisecond))
+	assertNotFired(t, ticker.C())
+	assert.Equal(t, 0, clock.Timers())
+}
+
+func assertFired(t *testing.T, c <-chan time.Time, at time.Time) {
+	t.Helper()
+	select {
+	case fired := <-c:
+		assert.Equal(t, at, fired)
+	default:
+		t.Fatal("expected timer to fire")
+	}
+}
+
+func assertNotFired(t *testing.T, c <-chan time.Time) {
+	t.Helper()
+	select {
+	case <-c:
+		t.Fatal("expected timer not to fire")
+	default:
+	}
+}
@@ -8,6 +8,10 @@ import (
 // Context is an alias for context.Context.
 type Context = context.Context
 
+// DeadlineExceeded is the error returned by Context.Err when the context's
+// deadline passes.
+var DeadlineExceeded = context.DeadlineExceeded
+
 type key[T any] struct{}
 
 // With returns a copy of parent that contains the given value which can be
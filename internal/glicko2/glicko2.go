@@ -0,0 +1,143 @@
+// Package glicko2 implements the Glicko-2 rating system as described by Mark
+// Glickman in http://www.glicko.net/glicko/glicko2.pdf.
+package glicko2
+
+import "math"
+
+// glickoScale is the factor used to convert between the Glicko and the
+// Glicko-2 scales.
+const glickoScale = 173.7178
+
+// Tau constrains the change in volatility over time. Reasonable values are
+// between 0.3 and 1.2.
+const Tau = 0.5
+
+// convergence is the tolerance used when iterating for the new volatility.
+const convergence = 0.000001
+
+// Rating is a Glicko-2 rating expressed on the original Glicko scale.
+type Rating struct {
+	// Rating is the rating of the player.
+	Rating float64 `json:"rating"`
+	// Deviation is the rating deviation (RD) of the player.
+	Deviation float64 `json:"deviation"`
+	// Volatility is the degree of expected fluctuation in the player's rating.
+	Volatility float64 `json:"volatility"`
+}
+
+// Default is the rating given to an unrated player.
+var Default = Rating{
+	Rating:     1500,
+	Deviation:  350,
+	Volatility: 0.06,
+}
+
+// Score is the outcome of a game from the perspective of one player.
+type Score float64
+
+const (
+	Loss Score = 0
+	Draw Score = 0.5
+	Win  Score = 1
+)
+
+// Result is the result of a game against an opponent.
+type Result struct {
+	// Opponent is the rating of the opponent before the game.
+	Opponent Rating
+	// Score is the score of the player against the opponent.
+	Score Score
+}
+
+// Update returns the new rating of a player after playing the given games
+// within a single rating period. If no games were played, then only the
+// deviation of the rating grows.
+func Update(r Rating, results ...Result) Rating {
+	mu := (r.Rating - 1500) / glickoScale
+	phi := r.Deviation / glickoScale
+	sigma := r.Volatility
+
+	if len(results) == 0 {
+		phi = math.Sqrt(phi*phi + sigma*sigma)
+		return Rating{
+			Rating:     r.Rating,
+			Deviation:  phi * glickoScale,
+			Volatility: sigma,
+		}
+	}
+
+	var vInv, deltaSum float64
+	for _, result := range results {
+		muj := (result.Opponent.Rating - 1500) / glickoScale
+		phij := result.Opponent.Deviation / glickoScale
+
+		gj := g(phij)
+		ej := e(mu, muj, phij)
+
+		vInv += gj * gj * ej * (1 - ej)
+		deltaSum += gj * (float64(result.Score) - ej)
+	}
+
+	v := 1 / vInv
+	delta := v * deltaSum
+
+	sigma = newVolatility(phi, sigma, v, delta)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu = mu + phi*phi*deltaSum
+
+	return Rating{
+		Rating:     mu*glickoScale + 1500,
+		Deviation:  phi * glickoScale,
+		Volatility: sigma,
+	}
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func e(mu, muj, phij float64) float64 {
+	return 1 / (1 + math.Exp(-g(phij)*(mu-muj)))
+}
+
+// newVolatility computes the new volatility using the Illinois algorithm, as
+// described in step 5 of the Glicko-2 paper.
+func newVolatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-d)/(2*d*d) - (x-a)/(Tau*Tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*Tau) < 0 {
+			k++
+		}
+		B = a - k*Tau
+	}
+
+	fA := f(A)
+	fB := f(B)
+	for math.Abs(B-A) > convergence {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A = B
+			fA = fB
+		} else {
+			fA /= 2
+		}
+		B = C
+		fB = fC
+	}
+
+	return math.Exp(A / 2)
+}
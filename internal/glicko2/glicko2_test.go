@@ -0,0 +1,33 @@
+package glicko2
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	// Example taken from the Glicko-2 paper.
+	player := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	updated := Update(player,
+		Result{Opponent: Rating{Rating: 1400, Deviation: 30}, Score: Win},
+		Result{Opponent: Rating{Rating: 1550, Deviation: 100}, Score: Loss},
+		Result{Opponent: Rating{Rating: 1700, Deviation: 300}, Score: Loss},
+	)
+
+	assertAbout(t, "rating", 1464.06, updated.Rating, 0.01)
+	assertAbout(t, "deviation", 151.52, updated.Deviation, 0.01)
+	assertAbout(t, "volatility", 0.05999, updated.Volatility, 0.00001)
+}
+
+func TestUpdateNoGames(t *testing.T) {
+	updated := Update(Rating{Rating: 1500, Deviation: 200, Volatility: 0.06})
+	assertAbout(t, "rating", 1500, updated.Rating, 0)
+	assertAbout(t, "deviation", 200.27, updated.Deviation, 0.01)
+}
+
+func assertAbout(t *testing.T, name string, expected, actual, tolerance float64) {
+	t.Helper()
+	if math.Abs(expected-actual) > tolerance {
+		t.Errorf("expected %s to be about %v, got %v", name, expected, actual)
+	}
+}
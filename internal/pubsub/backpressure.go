@@ -0,0 +1,113 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Backpressure decides what a Publisher does with a subscriber that has gone
+// over its Limits.
+type Backpressure int
+
+const (
+	// BackpressureBlock makes Publish wait until the subscriber is back
+	// within its limits.
+	BackpressureBlock Backpressure = iota
+	// BackpressureDrop unsubscribes the subscriber.
+	BackpressureDrop
+	// BackpressureCoalesce unsubscribes the subscriber, so that the events
+	// that it has missed can be replaced by a snapshot.
+	BackpressureCoalesce
+)
+
+// String implements fmt.Stringer.
+func (b Backpressure) String() string {
+	switch b {
+	case BackpressureBlock:
+		return "block"
+	case BackpressureDrop:
+		return "drop"
+	case BackpressureCoalesce:
+		return "coalesce"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (b Backpressure) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (b *Backpressure) UnmarshalText(text []byte) error {
+	for _, v := range []Backpressure{BackpressureBlock, BackpressureDrop, BackpressureCoalesce} {
+		if string(text) == v.String() {
+			*b = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown backpressure %q", text)
+}
+
+// Limits limits how far a subscriber can fall behind its Publisher.
+type Limits struct {
+	// MaxQueued is the maximum number of events waiting to be received by the
+	// subscriber. If zero, then there is no limit.
+	MaxQueued int
+	// MaxLag is the maximum time that an event can wait to be received by the
+	// subscriber. If zero, then there is no limit.
+	MaxLag time.Duration
+	// Backpressure is the action taken once the subscriber goes over either
+	// limit.
+	Backpressure Backpressure
+	// Counters counts the actions taken. It may be nil.
+	Counters *BackpressureCounters
+}
+
+// exceeded returns true if a subscriber with the given number of waiting
+// events, the oldest of which has waited for lag, is over the limits.
+func (l Limits) exceeded(queued int, lag time.Duration) bool {
+	return (l.MaxQueued > 0 && queued >= l.MaxQueued) ||
+		(l.MaxLag > 0 && lag >= l.MaxLag)
+}
+
+// count counts the action as taken.
+func (l Limits) count() {
+	if l.Counters == nil {
+		return
+	}
+	switch l.Backpressure {
+	case BackpressureBlock:
+		l.Counters.blocked.Add(1)
+	case BackpressureDrop:
+		l.Counters.dropped.Add(1)
+	case BackpressureCoalesce:
+		l.Counters.coalesced.Add(1)
+	}
+}
+
+// BackpressureCounters counts how often each Backpressure action has been
+// taken. It is safe for concurrent use, and may be shared by many publishers.
+type BackpressureCounters struct {
+	blocked   atomic.Uint64
+	dropped   atomic.Uint64
+	coalesced atomic.Uint64
+}
+
+// BackpressureCounts is a snapshot of BackpressureCounters.
+type BackpressureCounts struct {
+	Blocked   uint64 `json:"blocked"`
+	Dropped   uint64 `json:"dropped"`
+	Coalesced uint64 `json:"coalesced"`
+}
+
+// Counts returns the current counts.
+func (c *BackpressureCounters) Counts() BackpressureCounts {
+	return BackpressureCounts{
+		Blocked:   c.blocked.Load(),
+		Dropped:   c.dropped.Load(),
+		Coalesced: c.coalesced.Load(),
+	}
+}
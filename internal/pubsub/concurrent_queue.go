@@ -3,6 +3,10 @@ package pubsub
 import (
 	"container/list"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"libdb.so/scouts-server/internal/clock"
 )
 
 // https://github.com/lightningnetwork/lnd/blob/master/queue/queue.go
@@ -15,8 +19,18 @@ import (
 type ConcurrentQueue[T any] struct {
 	chanIn   chan T
 	chanOut  chan T
+	chanSet  chan []T
 	overflow *list.List
 
+	// len is the number of items in the overflow queue, and oldest is the
+	// time in Unix nanoseconds that its front item was pushed at, or 0 if it
+	// is empty. taken is signaled whenever an item leaves the overflow
+	// queue.
+	len    atomic.Int64
+	oldest atomic.Int64
+	taken  chan struct{}
+	clock  clock.Clock
+
 	started sync.Once
 	stopped sync.Once
 	closed  sync.Once
@@ -25,13 +39,23 @@ type ConcurrentQueue[T any] struct {
 	quit chan struct{}
 }
 
-// NewConcurrentQueue constructs a ConcurrentQueue.
-func NewConcurrentQueue[T any]() *ConcurrentQueue[T] {
+// queuedItem is an item in the overflow queue.
+type queuedItem[T any] struct {
+	value T
+	at    time.Time
+}
+
+// NewConcurrentQueue constructs a ConcurrentQueue. The clock is used to measure
+// its Lag. If it is nil, then the real clock is used.
+func NewConcurrentQueue[T any](clk clock.Clock) *ConcurrentQueue[T] {
 	return &ConcurrentQueue[T]{
 		chanIn:   make(chan T),
 		chanOut:  make(chan T),
+		chanSet:  make(chan []T),
 		overflow: list.New(),
+		taken:    make(chan struct{}, 1),
 		quit:     make(chan struct{}),
+		clock:    clock.Or(clk),
 	}
 }
 
@@ -45,6 +69,37 @@ func (cq *ConcurrentQueue[T]) Out() <-chan T {
 	return cq.chanOut
 }
 
+// Len returns the number of items that are waiting to be popped from the
+// queue.
+func (cq *ConcurrentQueue[T]) Len() int {
+	return int(cq.len.Load())
+}
+
+// Lag returns how long the oldest item in the queue has been waiting to be
+// popped, or 0 if no item is waiting.
+func (cq *ConcurrentQueue[T]) Lag() time.Duration {
+	oldest := cq.oldest.Load()
+	if oldest == 0 {
+		return 0
+	}
+	return cq.clock.Now().Sub(time.Unix(0, oldest))
+}
+
+// Taken returns a channel that is signaled whenever a waiting item is popped
+// from the queue.
+func (cq *ConcurrentQueue[T]) Taken() <-chan struct{} {
+	return cq.taken
+}
+
+// Reset replaces all items that are waiting to be popped with the given
+// items. It must not be called after Close.
+func (cq *ConcurrentQueue[T]) Reset(items ...T) {
+	select {
+	case cq.chanSet <- items:
+	case <-cq.quit:
+	}
+}
+
 // Start begins a goroutine that manages moving items from the in channel to the
 // out channel. The queue tries to move items directly to the out channel
 // minimize overhead, but if the out channel is full it pushes items to an
@@ -74,8 +129,10 @@ func (cq *ConcurrentQueue[T]) start() {
 					case cq.chanOut <- item:
 						// Optimistically push directly to chanOut
 					default:
-						cq.overflow.PushBack(item)
+						cq.push(item)
 					}
+				case items := <-cq.chanSet:
+					cq.set(items)
 				case <-cq.quit:
 					return
 				}
@@ -87,9 +144,11 @@ func (cq *ConcurrentQueue[T]) start() {
 					if !ok {
 						break readLoop
 					}
-					cq.overflow.PushBack(item)
-				case cq.chanOut <- nextElement.Value.(T):
-					cq.overflow.Remove(nextElement)
+					cq.push(item)
+				case cq.chanOut <- nextElement.Value.(queuedItem[T]).value:
+					cq.remove(nextElement)
+				case items := <-cq.chanSet:
+					cq.set(items)
 				case <-cq.quit:
 					return
 				}
@@ -101,8 +160,8 @@ func (cq *ConcurrentQueue[T]) start() {
 		nextElement := cq.overflow.Front()
 		for nextElement != nil {
 			select {
-			case cq.chanOut <- nextElement.Value.(T):
-				cq.overflow.Remove(nextElement)
+			case cq.chanOut <- nextElement.Value.(queuedItem[T]).value:
+				cq.remove(nextElement)
 			case <-cq.quit:
 				return
 			}
@@ -114,6 +173,41 @@ func (cq *ConcurrentQueue[T]) start() {
 	}()
 }
 
+// push pushes an item to the back of the overflow queue.
+func (cq *ConcurrentQueue[T]) push(item T) {
+	cq.overflow.PushBack(queuedItem[T]{value: item, at: cq.clock.Now()})
+	cq.updateStats()
+}
+
+// remove removes an item from the overflow queue.
+func (cq *ConcurrentQueue[T]) remove(e *list.Element) {
+	cq.overflow.Remove(e)
+	cq.updateStats()
+
+	select {
+	case cq.taken <- struct{}{}:
+	default:
+	}
+}
+
+// set replaces the overflow queue with the given items.
+func (cq *ConcurrentQueue[T]) set(items []T) {
+	cq.overflow.Init()
+	for _, item := range items {
+		cq.overflow.PushBack(queuedItem[T]{value: item, at: cq.clock.Now()})
+	}
+	cq.updateStats()
+}
+
+func (cq *ConcurrentQueue[T]) updateStats() {
+	cq.len.Store(int64(cq.overflow.Len()))
+	if front := cq.overflow.Front(); front != nil {
+		cq.oldest.Store(front.Value.(queuedItem[T]).at.UnixNano())
+	} else {
+		cq.oldest.Store(0)
+	}
+}
+
 // Stop interrupts the background goroutine immediately and waits for it to
 // return.
 func (cq *ConcurrentQueue[T]) Stop() {
@@ -0,0 +1,207 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+)
+
+// ErrLagged is returned by a LogReader that has fallen so far behind its Log
+// that the events it has yet to read have been overwritten.
+var ErrLagged = errors.New("pubsub: reader fell behind the log")
+
+// SlowReaderPolicy decides what happens to a LogReader that has fallen so far
+// behind its Log that the events it has yet to read have been overwritten.
+type SlowReaderPolicy int
+
+const (
+	// DisconnectSlowReaders makes slow readers fail with ErrLagged.
+	DisconnectSlowReaders SlowReaderPolicy = iota
+	// SkipSlowReaders moves slow readers ahead to the oldest event that is
+	// still in the log. The overwritten events are never read.
+	SkipSlowReaders
+)
+
+// Log is an append-only event log that is shared by all of its readers. Only
+// the latest events are kept in a ring buffer of fixed capacity, so the memory
+// used by the log does not grow with the number of events or readers. Unlike
+// Publisher, readers follow the log with their own cursors and need no
+// goroutine of their own.
+type Log[T any] struct {
+	mu   sync.RWMutex
+	ring []T
+	// head is the sequence number of the next event to be appended. The ring
+	// holds the events from max(head-len(ring), 0) up to head.
+	head uint64
+	// wake is closed and replaced whenever events are appended or the log is
+	// closed.
+	wake    chan struct{}
+	closed  bool
+	policy  SlowReaderPolicy
+	metrics *Metrics
+}
+
+// NewLog creates a new log that keeps the latest capacity events.
+func NewLog[T any](capacity int, policy SlowReaderPolicy) *Log[T] {
+	if capacity < 1 {
+		panic("pubsub: log capacity must be positive")
+	}
+	return &Log[T]{
+		ring:   make([]T, capacity),
+		wake:   make(chan struct{}),
+		policy: policy,
+	}
+}
+
+// Instrument makes the log count its events in m. It must be called before the
+// log is used.
+func (l *Log[T]) Instrument(m *Metrics) {
+	l.metrics = m
+}
+
+// Append appends events to the log, overwriting the oldest events once the log
+// is full. It never blocks on readers. Events appended after Close are
+// dropped.
+func (l *Log[T]) Append(events ...T) {
+	if len(events) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	for _, ev := range events {
+		l.ring[l.head%uint64(len(l.ring))] = ev
+		l.head++
+	}
+
+	l.metrics.publish(len(events))
+
+	l.wakeReaders()
+}
+
+// Close closes the log. Readers still receive the events left in the log, and
+// then io.EOF.
+func (l *Log[T]) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.closed {
+		l.closed = true
+		l.wakeReaders()
+	}
+}
+
+// wakeReaders wakes up all readers waiting for events. l.mu must be held.
+func (l *Log[T]) wakeReaders() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// tail returns the sequence number of the oldest event in the log. l.mu must be
+// held.
+func (l *Log[T]) tail() uint64 {
+	if size := uint64(len(l.ring)); l.head > size {
+		return l.head - size
+	}
+	return 0
+}
+
+// Follow returns a reader that first reads the given playback events and then
+// the events appended to the log from now on. Only appended events for which
+// filter returns true are read. A nil filter accepts all events.
+func (l *Log[T]) Follow(playback []T, filter func(T) bool) *LogReader[T] {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return &LogReader[T]{
+		log:      l,
+		playback: slices.Clone(playback),
+		filter:   filter,
+		cursor:   l.head,
+	}
+}
+
+// LogReader reads the events of a Log from its own cursor. A LogReader must not
+// be used concurrently.
+type LogReader[T any] struct {
+	log      *Log[T]
+	playback []T
+	filter   func(T) bool
+	cursor   uint64
+	skipped  uint64
+	// lagged is true once the reader has been disconnected for falling
+	// behind.
+	lagged bool
+}
+
+// Next returns the next event, waiting until one is appended or ctx is done.
+// io.EOF is returned once the log is closed and all of its events have been
+// read. ErrLagged is returned if the reader has fallen behind and the log
+// disconnects slow readers.
+func (r *LogReader[T]) Next(ctx context.Context) (T, error) {
+	var zero T
+
+	if len(r.playback) > 0 {
+		ev := r.playback[0]
+		r.playback[0] = zero
+		r.playback = r.playback[1:]
+		return ev, nil
+	}
+
+	l := r.log
+	for {
+		l.mu.RLock()
+
+		if tail := l.tail(); r.cursor < tail {
+			if l.policy != SkipSlowReaders {
+				if !r.lagged {
+					r.lagged = true
+					l.metrics.drop(int(l.head - r.cursor))
+				}
+				l.mu.RUnlock()
+				return zero, ErrLagged
+			}
+			l.metrics.drop(int(tail - r.cursor))
+			r.skipped += tail - r.cursor
+			r.cursor = tail
+		}
+
+		if r.cursor < l.head {
+			ev := l.ring[r.cursor%uint64(len(l.ring))]
+			r.cursor++
+			l.mu.RUnlock()
+
+			if r.filter != nil && !r.filter(ev) {
+				continue
+			}
+			return ev, nil
+		}
+
+		closed := l.closed
+		wake := l.wake
+		l.mu.RUnlock()
+
+		if closed {
+			return zero, io.EOF
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// Skipped returns the number of events that the reader has missed because it
+// fell behind.
+func (r *LogReader[T]) Skipped() uint64 {
+	return r.skipped
+}
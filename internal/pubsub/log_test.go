@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestLog(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	log := NewLog[int](4, DisconnectSlowReaders)
+	log.Append(1, 2)
+
+	odd := log.Follow([]int{-1}, func(v int) bool { return v%2 != 0 })
+	all := log.Follow(nil, nil)
+
+	go func() {
+		log.Append(3, 4, 5)
+		log.Close()
+		log.Append(6)
+	}()
+
+	assert.Equal(t, []int{-1, 3, 5}, readAll(t, ctx, odd),
+		"reader should read the playback and then the filtered events")
+	assert.Equal(t, []int{3, 4, 5}, readAll(t, ctx, all),
+		"reader should only read the events appended after it followed the log")
+}
+
+func TestLogSlowReaders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	disconnected := NewLog[int](2, DisconnectSlowReaders)
+	reader := disconnected.Follow(nil, nil)
+	disconnected.Append(1, 2, 3)
+
+	_, err := reader.Next(ctx)
+	assert.IsError(t, err, ErrLagged)
+
+	skipped := NewLog[int](2, SkipSlowReaders)
+	reader = skipped.Follow(nil, nil)
+	skipped.Append(1, 2, 3)
+	skipped.Close()
+
+	assert.Equal(t, []int{2, 3}, readAll(t, ctx, reader))
+	assert.Equal(t, uint64(1), reader.Skipped())
+}
+
+func readAll(t *testing.T, ctx context.Context, r *LogReader[int]) []int {
+	var values []int
+	for {
+		v, err := r.Next(ctx)
+		if err == io.EOF {
+			return values
+		}
+		assert.NoError(t, err)
+		values = append(values, v)
+	}
+}
@@ -0,0 +1,55 @@
+package pubsub
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics counts the events of publishers and logs as Prometheus metrics. Like
+// BackpressureCounters, it can be shared by any number of them.
+type Metrics struct {
+	published prometheus.Counter
+	dropped   prometheus.Counter
+}
+
+var _ prometheus.Collector = (*Metrics)(nil)
+
+// NewMetrics creates metrics named after the given namespace and subsystem,
+// e.g. scouts_game_events_published_total.
+func NewMetrics(namespace, subsystem string) *Metrics {
+	return &Metrics{
+		published: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "events_published_total",
+			Help:      "Number of events published.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "events_dropped_total",
+			Help:      "Number of events that subscribers missed because they fell behind.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.published.Describe(ch)
+	m.dropped.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.published.Collect(ch)
+	m.dropped.Collect(ch)
+}
+
+func (m *Metrics) publish(n int) {
+	if m != nil {
+		m.published.Add(float64(n))
+	}
+}
+
+func (m *Metrics) drop(n int) {
+	if m != nil && n > 0 {
+		m.dropped.Add(float64(n))
+	}
+}
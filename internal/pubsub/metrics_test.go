@@ -0,0 +1,53 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("log", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		metrics := NewMetrics("test", "")
+
+		log := NewLog[int](2, DisconnectSlowReaders)
+		log.Instrument(metrics)
+		reader := log.Follow(nil, nil)
+		log.Append(1, 2, 3)
+
+		for i := 0; i < 2; i++ {
+			_, err := reader.Next(ctx)
+			assert.IsError(t, err, ErrLagged)
+		}
+
+		assert.Equal(t, 3.0, testutil.ToFloat64(metrics.published))
+		assert.Equal(t, 3.0, testutil.ToFloat64(metrics.dropped),
+			"unread events should be counted once")
+	})
+
+	t.Run("publisher", func(t *testing.T) {
+		metrics := NewMetrics("test", "")
+
+		publisher := NewPublisher[int]()
+		publisher.Instrument(metrics)
+
+		queue := NewConcurrentQueue[int](nil)
+		queue.Start()
+		defer queue.Stop()
+
+		publisher.SubscribeWith(queue, SubscribeOptions[int]{
+			Limits: Limits{MaxQueued: 2, Backpressure: BackpressureDrop},
+		})
+		publisher.Publish(1, 2, 3, 4)
+
+		assert.Equal(t, 4.0, testutil.ToFloat64(metrics.published))
+		assert.Equal(t, 4.0, testutil.ToFloat64(metrics.dropped),
+			"queued and pending events of dropped subscribers should be counted")
+	})
+}
@@ -6,34 +6,75 @@ import (
 
 // Publisher allows you to publish events to multiple subscribers.
 type Publisher[T any] struct {
-	subscribers *xsync.MapOf[*ConcurrentQueue[T], chan struct{}]
+	subscribers *xsync.MapOf[*ConcurrentQueue[T], subscriber[T]]
+	metrics     *Metrics
+}
+
+type subscriber[T any] struct {
+	stop chan struct{}
+	opts SubscribeOptions[T]
+}
+
+// SubscribeOptions contains options for a subscriber.
+type SubscribeOptions[T any] struct {
+	// Filter returns true for the events that should be sent to the
+	// subscriber. If nil, then all events are sent.
+	Filter func(T) bool
+	// Limits limits how far the subscriber can fall behind. By default, there
+	// are no limits.
+	Limits Limits
+	// OnOverflow is called once the subscriber goes over its limits and has
+	// been unsubscribed, unless its Backpressure is BackpressureBlock. It is
+	// called during Publish with the subscriber's queue, which is still open.
+	OnOverflow func(cq *ConcurrentQueue[T])
 }
 
 // NewPublisher creates a new publisher.
 func NewPublisher[T any]() *Publisher[T] {
 	return &Publisher[T]{
-		subscribers: xsync.NewMapOf[*ConcurrentQueue[T], chan struct{}](),
+		subscribers: xsync.NewMapOf[*ConcurrentQueue[T], subscriber[T]](),
 	}
 }
 
+// Instrument makes the publisher count its events in m. It must be called
+// before the publisher is used.
+func (p *Publisher[T]) Instrument(m *Metrics) {
+	p.metrics = m
+}
+
 // Subscribe subscribes to the publisher.
 func (p *Publisher[T]) Subscribe(cq *ConcurrentQueue[T]) {
-	p.subscribers.Store(cq, make(chan struct{}))
+	p.SubscribeFiltered(cq, nil)
+}
+
+// SubscribeFiltered subscribes to the publisher, but only events for which
+// filter returns true are sent to the subscriber. A nil filter accepts all
+// events.
+func (p *Publisher[T]) SubscribeFiltered(cq *ConcurrentQueue[T], filter func(T) bool) {
+	p.SubscribeWith(cq, SubscribeOptions[T]{Filter: filter})
+}
+
+// SubscribeWith subscribes to the publisher with the given options.
+func (p *Publisher[T]) SubscribeWith(cq *ConcurrentQueue[T], opts SubscribeOptions[T]) {
+	p.subscribers.Store(cq, subscriber[T]{
+		stop: make(chan struct{}),
+		opts: opts,
+	})
 }
 
 // Unsubscribe unsubscribes from the publisher.
 // Any pending sends to the channel will be cancelled.
 func (p *Publisher[T]) Unsubscribe(cq *ConcurrentQueue[T]) {
-	stopper, ok := p.subscribers.LoadAndDelete(cq)
+	sub, ok := p.subscribers.LoadAndDelete(cq)
 	if ok {
-		close(stopper)
+		close(sub.stop)
 	}
 }
 
 // Subscribers returns a list of all subscribers.
 func (p *Publisher[T]) Subscribers() []*ConcurrentQueue[T] {
 	var subscribers []*ConcurrentQueue[T]
-	p.subscribers.Range(func(cq *ConcurrentQueue[T], stop chan struct{}) bool {
+	p.subscribers.Range(func(cq *ConcurrentQueue[T], _ subscriber[T]) bool {
 		subscribers = append(subscribers, cq)
 		return true
 	})
@@ -41,14 +82,24 @@ func (p *Publisher[T]) Subscribers() []*ConcurrentQueue[T] {
 }
 
 // Publish publishes an event to all subscribers.
-// It will block until all subscribers have received the event or the context
-// has been cancelled.
+// It will block until all subscribers have received the event or have been
+// unsubscribed. Subscribers that are over their limits are handled according
+// to their Backpressure first.
 func (p *Publisher[T]) Publish(events ...T) {
-	p.subscribers.Range(func(cq *ConcurrentQueue[T], stop chan struct{}) bool {
-		for _, ev := range events {
+	p.metrics.publish(len(events))
+	p.subscribers.Range(func(cq *ConcurrentQueue[T], sub subscriber[T]) bool {
+		for i, ev := range events {
+			if sub.opts.Filter != nil && !sub.opts.Filter(ev) {
+				continue
+			}
+			if !p.applyBackpressure(cq, sub, len(events)-i) {
+				return true
+			}
 			select {
-			case <-stop:
-				return false
+			case <-sub.stop:
+				// Only skip this subscriber; the others should still
+				// receive the events.
+				return true
 			case cq.In() <- ev:
 			}
 		}
@@ -56,6 +107,38 @@ func (p *Publisher[T]) Publish(events ...T) {
 	})
 }
 
+// applyBackpressure takes the subscriber's Backpressure action if it is over
+// its limits. False is returned if the subscriber is no longer subscribed, in
+// which case its queued events and the pending events of the current Publish
+// are counted as dropped.
+func (p *Publisher[T]) applyBackpressure(cq *ConcurrentQueue[T], sub subscriber[T], pending int) bool {
+	limits := sub.opts.Limits
+	if !limits.exceeded(cq.Len(), cq.Lag()) {
+		return true
+	}
+
+	limits.count()
+
+	if limits.Backpressure != BackpressureBlock {
+		p.metrics.drop(cq.Len() + pending)
+		p.Unsubscribe(cq)
+		if sub.opts.OnOverflow != nil {
+			sub.opts.OnOverflow(cq)
+		}
+		return false
+	}
+
+	for limits.exceeded(cq.Len(), cq.Lag()) {
+		select {
+		case <-sub.stop:
+			return false
+		case <-cq.Taken():
+		}
+	}
+
+	return true
+}
+
 // Send sends events to a channel.
 func Send[T any](ch chan<- T, events ...T) {
 	for _, event := range events {
@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LogHandler wraps a slog.Handler to add the trace_id and span_id of the span
+// within the context of each record, so that the logs of a request can be
+// found from its trace and the other way around. Records must be logged with
+// the context, e.g. using slog.Logger.InfoContext, for this to work.
+type LogHandler struct {
+	slog.Handler
+}
+
+// NewLogHandler creates a new LogHandler that wraps h.
+func NewLogHandler(h slog.Handler) *LogHandler {
+	return &LogHandler{h}
+}
+
+// Handle implements slog.Handler.
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r = r.Clone()
+		r.AddAttrs(
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &LogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	return &LogHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(slog.NewJSONHandler(&buf, nil))).With("a", 1)
+
+	type logRecord struct {
+		A       int    `json:"a"`
+		TraceID string `json:"trace_id"`
+		SpanID  string `json:"span_id"`
+	}
+
+	readRecord := func() logRecord {
+		t.Helper()
+		var record logRecord
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+		buf.Reset()
+		return record
+	}
+
+	logger.InfoContext(context.Background(), "no span")
+	record := readRecord()
+	assert.Equal(t, logRecord{A: 1}, record)
+
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background())
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+
+	logger.InfoContext(ctx, "in span")
+	record = readRecord()
+	assert.Equal(t, logRecord{
+		A:       1,
+		TraceID: span.SpanContext().TraceID().String(),
+		SpanID:  span.SpanContext().SpanID().String(),
+	}, record)
+}
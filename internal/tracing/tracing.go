@@ -0,0 +1,135 @@
+// Package tracing sets up OpenTelemetry tracing and ties the slog records that
+// are logged within a trace to it.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// Exporter is where the spans of traces are exported to.
+type Exporter int
+
+const (
+	// ExporterNone does not record traces at all.
+	ExporterNone Exporter = iota
+	// ExporterOTLP exports spans to an OTLP collector over HTTP.
+	ExporterOTLP
+	// ExporterStdout writes spans to stdout, which is meant for local
+	// debugging.
+	ExporterStdout
+)
+
+// String returns the name of the exporter.
+func (e Exporter) String() string {
+	switch e {
+	case ExporterNone:
+		return "none"
+	case ExporterOTLP:
+		return "otlp"
+	case ExporterStdout:
+		return "stdout"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (e Exporter) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (e *Exporter) UnmarshalText(text []byte) error {
+	for _, v := range []Exporter{ExporterNone, ExporterOTLP, ExporterStdout} {
+		if string(text) == v.String() {
+			*e = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown trace exporter %q", text)
+}
+
+// Options configures the tracing of the server.
+type Options struct {
+	// ServiceName is the name of the service that spans are reported for.
+	ServiceName string
+	// Exporter is where spans are exported to.
+	Exporter Exporter
+	// Endpoint is the host and port of the OTLP collector. If empty, then the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable is used, or
+	// localhost:4318 if that is not set either. It only applies to
+	// ExporterOTLP.
+	Endpoint string
+	// Insecure exports spans to the OTLP collector over plain HTTP instead of
+	// HTTPS.
+	Insecure bool
+}
+
+// Setup installs the global tracer provider and propagator of OpenTelemetry.
+// The returned function flushes the spans that have yet to be exported and
+// stops the exporter. With ExporterNone, the global tracer provider is left
+// alone, so that spans cost next to nothing, but trace contexts are still
+// propagated.
+func Setup(ctx context.Context, opts Options) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	switch opts.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var clientOpts []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, clientOpts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %v", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %v trace exporter: %w", opts.Exporter, err)
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(opts.ServiceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Keys of the span attributes that are shared by the spans of the server.
+const (
+	// GameIDKey is the ID of the game that the span acts on.
+	GameIDKey = attribute.Key("game_id")
+	// UserKey is the user that the span acts for, as formatted by
+	// user.Authorization.String, which truncates the session token.
+	UserKey = attribute.Key("user")
+)
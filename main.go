@@ -5,30 +5,70 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/lmittmann/tint"
 	"github.com/mattn/go-isatty"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"libdb.so/hserve"
 	"libdb.so/scouts-server/api"
 	"libdb.so/scouts-server/api/gameserver"
 	"libdb.so/scouts-server/api/storage"
 	"libdb.so/scouts-server/api/user"
+	"libdb.so/scouts-server/api/webhook"
+	"libdb.so/scouts-server/internal/pubsub"
+	"libdb.so/scouts-server/internal/tracing"
 )
 
 var (
 	httpAddr = "localhost:8080"
 	stateDir = "/tmp/scouts-server"
 	verbose  = false
+
+	shutdownTimeout = 10 * time.Second
+
+	eventLogSize    = 0
+	skipSlowReaders = false
+
+	backpressure gameserver.BackpressureOptions
+	gc           = gameserver.DefaultGCOptions
+
+	tracingOpts = tracing.Options{ServiceName: "scouts-server"}
+
+	adminToken = ""
 )
 
 func init() {
 	flag.StringVar(&httpAddr, "http", httpAddr, "HTTP address to listen on")
 	flag.StringVar(&stateDir, "state", stateDir, "state directory")
 	flag.BoolVar(&verbose, "verbose", verbose, "verbose logging")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "time to wait for games and connections to close on shutdown")
+	flag.IntVar(&eventLogSize, "event-log", eventLogSize, "if set, deliver game events through a shared log of this many events instead of a queue per subscriber")
+	flag.BoolVar(&skipSlowReaders, "skip-slow-readers", skipSlowReaders, "skip subscribers ahead instead of disconnecting them once they fall behind the event log")
+	flag.IntVar(&backpressure.MaxQueuedEvents, "max-queued-events", 0, "maximum number of events queued for a subscriber, or 0 for no limit")
+	flag.DurationVar(&backpressure.MaxLag, "max-subscriber-lag", 0, "maximum time that an event can be queued for a subscriber, or 0 for no limit")
+	flag.TextVar(&backpressure.Action, "backpressure", pubsub.BackpressureBlock, "action taken once a subscriber goes over its limits: block, drop or coalesce")
+	flag.DurationVar(&gc.Interval, "gc-interval", gc.Interval, "how often inactive games are evicted from memory")
+	flag.DurationVar(&gc.Unstarted, "unstarted-retention", gc.Unstarted, "how long games that have not begun are kept after they were created")
+	flag.DurationVar(&gc.Running, "running-retention", gc.Running, "how long running games are kept after their last move")
+	flag.DurationVar(&gc.Finished, "finished-retention", gc.Finished, "how long finished games are kept in memory before they are archived")
+	flag.TextVar(&tracingOpts.Exporter, "trace-exporter", tracing.ExporterNone, "where traces are exported to: none, otlp or stdout")
+	flag.StringVar(&tracingOpts.Endpoint, "otlp-endpoint", "", "host and port of the OTLP collector that traces are exported to over HTTP")
+	flag.BoolVar(&tracingOpts.Insecure, "otlp-insecure", false, "export traces to the OTLP collector over plain HTTP instead of HTTPS")
+	flag.StringVar(&adminToken, "admin-token", adminToken, "bearer token of the admin API at /admin, which is disabled if empty; $SCOUTS_ADMIN_TOKEN is used if not set")
 	flag.Parse()
+
+	// The token can also be given through the environment, since the flags of
+	// a process can be seen by other users.
+	if adminToken == "" {
+		adminToken = os.Getenv("SCOUTS_ADMIN_TOKEN")
+	}
 }
 
 func main() {
@@ -37,10 +77,10 @@ func main() {
 		level = slog.LevelDebug
 	}
 
-	logger := slog.New(tint.NewHandler(os.Stderr, &tint.Options{
+	logger := slog.New(tracing.NewLogHandler(tint.NewHandler(os.Stderr, &tint.Options{
 		Level:   level,
 		NoColor: !isatty.IsTerminal(os.Stderr.Fd()),
-	}))
+	})))
 	slog.SetDefault(logger)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
@@ -53,29 +93,139 @@ func main() {
 }
 
 func start(ctx context.Context, logger *slog.Logger) error {
+	shutdownTracing, err := tracing.Setup(ctx, tracingOpts)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		// Flush the spans of the last requests, which ctx can no longer be
+		// used for since it is done by now.
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Warn(
+				"failed to flush traces",
+				"err", err)
+		}
+	}()
+
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
 	storageManager := storage.NewStorageManager(stateDir)
+	metrics.MustRegister(storageManager.Collector())
 
 	sessionStorage, err := storageManager.OpenSessionStorage()
 	if err != nil {
 		return fmt.Errorf("failed to open session storage: %w", err)
 	}
 
-	gameManager := gameserver.NewGameManager(logger)
+	ratingStorage, err := storageManager.OpenRatingStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open rating storage: %w", err)
+	}
 
-	api := api.NewHandler(api.Services{
-		GameManager:    gameManager,
-		SessionStorage: user.NewCachedSessionStorage(sessionStorage),
-	})
+	gameArchive, err := storageManager.OpenGameArchive()
+	if err != nil {
+		return fmt.Errorf("failed to open game archive: %w", err)
+	}
+
+	webhookStorage, err := storageManager.OpenWebhookStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open webhook storage: %w", err)
+	}
+
+	webhooks, err := webhook.NewDispatcher(webhookStorage, logger, webhook.DispatcherOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create webhook dispatcher: %w", err)
+	}
+
+	// Deliveries that are still pending once the games have been shut down
+	// are recorded as dead letters.
+	stopDelivery := webhooks.BeginDelivery()
+	defer stopDelivery()
+
+	gameOpts := gameserver.GameManagerOptions{
+		RatingStorage: ratingStorage,
+		GameArchive:   gameArchive,
+		Backpressure:  backpressure,
+		GC:            gc,
+		Metrics:       metrics,
+		Observer:      webhooks,
+	}
+	if eventLogSize > 0 {
+		gameOpts.EventLog = &gameserver.EventLogOptions{Capacity: eventLogSize}
+		if skipSlowReaders {
+			gameOpts.EventLog.SlowReaders = pubsub.SkipSlowReaders
+		}
+	}
+
+	gameManager := gameserver.NewGameManager(logger, gameOpts)
+
+	stopGC := gameManager.BeginGC()
+	defer stopGC()
+
+	matchmaker := gameserver.NewMatchmaker(gameManager, logger)
+	stopMatching := matchmaker.BeginMatching()
+	defer stopMatching()
+
+	cachedSessionStorage := user.NewCachedSessionStorage(sessionStorage)
 
 	r := chi.NewMux()
-	r.Mount("/api/v1", api)
+	r.Mount("/api/v1", api.NewHandler(api.Services{
+		GameManager:    gameManager,
+		Matchmaker:     matchmaker,
+		Lobby:          gameserver.NewLobby(gameManager, logger),
+		SessionStorage: cachedSessionStorage,
+	}, logger, metrics))
+	r.Handle("/metrics", promhttp.HandlerFor(metrics, promhttp.HandlerOpts{}))
+
+	if adminToken != "" {
+		r.Mount("/admin", api.NewAdminHandler(api.AdminServices{
+			GameManager:         gameManager,
+			SessionAdminStorage: cachedSessionStorage,
+			Dispatcher:          webhooks,
+		}, logger, adminToken))
+	}
+
+	l, err := hserve.Listen(ctx, httpAddr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
 
 	logger.Info(
 		"starting server",
 		"addr", httpAddr)
 
-	if err := hserve.ListenAndServe(ctx, httpAddr, r); err != nil {
-		return fmt.Errorf("failed to listen and serve: %w", err)
+	server := &http.Server{Handler: r}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(l) }()
+
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Stop the games first, so that their event streams are told to go away
+	// and end before the HTTP server waits for them.
+	if err := gameManager.Shutdown(shutdownCtx); err != nil {
+		logger.Warn(
+			"failed to shut down games gracefully",
+			"err", err)
+	}
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down gracefully: %w", err)
 	}
 
 	return nil
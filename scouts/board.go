@@ -46,11 +46,12 @@ func (b *Board) Bounds() image.Rectangle {
 	return BoardBounds
 }
 
-// Pieces returns the pieces on the board.
+// Pieces returns copies of the pieces on the board. The copies are not
+// affected by moves made after this call.
 func (b *Board) Pieces() []Piece {
-	pieces := make([]Piece, 0, len(b.positions))
+	pieces := make([]Piece, 0, len(b.pieces))
 	for piece := range b.pieces {
-		pieces = append(pieces, piece)
+		pieces = append(pieces, piece.clone())
 	}
 	return pieces
 }
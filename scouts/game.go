@@ -2,6 +2,7 @@ package scouts
 
 import (
 	"fmt"
+	"reflect"
 	"slices"
 )
 
@@ -29,6 +30,20 @@ func (s gameState) String() string {
 	}
 }
 
+// Phase is the phase that a game is in.
+type Phase string
+
+const (
+	// PhasePlaceScouts is the phase where players take turns placing their
+	// scouts on their base.
+	PhasePlaceScouts Phase = "place_scouts"
+	// PhasePlay is the phase where players move their scouts and place
+	// boulders.
+	PhasePlay Phase = "play"
+	// PhaseEnded is the phase after a player has won.
+	PhaseEnded Phase = "ended"
+)
+
 // Game is a game instance.
 type Game struct {
 	board          *Board
@@ -67,6 +82,27 @@ func NewGameFromPastTurns(turns []PastTurn) (*Game, error) {
 	return g, nil
 }
 
+// Clone returns a deep copy of the game. Moves applied to the copy do not
+// affect the original game.
+func (g *Game) Clone() *Game {
+	turns := g.PastTurns()
+	if len(g.currentTurn.Moves) > 0 {
+		turns = append(turns, PastTurn{
+			Moves:  g.currentTurn.Moves,
+			Player: g.currentTurn.Player,
+		})
+	}
+
+	clone, err := NewGameFromPastTurns(turns)
+	if err != nil {
+		// The moves were already applied to this game, so this can only
+		// happen if the game is somehow not deterministic.
+		panic(fmt.Sprintf("scouts: cannot clone game: %v", err))
+	}
+
+	return clone
+}
+
 // MakeMove is an alias for [Apply].
 func (g *Game) MakeMove(p Player, move Move) error {
 	return g.Apply(p, move)
@@ -84,6 +120,48 @@ func (g *Game) Apply(p Player, move Move) error {
 	return nil
 }
 
+// PieceChange describes how a single piece changed after a move.
+type PieceChange struct {
+	// Before is a copy of the piece before the move.
+	// If nil, then the piece was added by the move.
+	Before Piece
+	// After is a copy of the piece after the move.
+	// If nil, then the piece was removed by the move.
+	After Piece
+}
+
+// ApplyDiff applies the given move to the game like Apply and returns the
+// pieces that the move changed.
+func (g *Game) ApplyDiff(p Player, move Move) ([]PieceChange, error) {
+	before := make(map[Piece]Piece, len(g.board.pieces))
+	for piece := range g.board.pieces {
+		before[piece] = piece.clone()
+	}
+
+	if err := g.Apply(p, move); err != nil {
+		return nil, err
+	}
+
+	var changes []PieceChange
+	for piece := range g.board.pieces {
+		old, ok := before[piece]
+		if ok && reflect.DeepEqual(old, piece) {
+			continue
+		}
+		changes = append(changes, PieceChange{
+			Before: old,
+			After:  piece.clone(),
+		})
+	}
+	for piece, old := range before {
+		if _, ok := g.board.pieces[piece]; !ok {
+			changes = append(changes, PieceChange{Before: old})
+		}
+	}
+
+	return changes, nil
+}
+
 // Board returns the board.
 func (g *Game) Board() *Board {
 	return g.board
@@ -119,6 +197,18 @@ func (g *Game) Ended() (Player, bool) {
 	}
 }
 
+// Phase returns the current phase of the game.
+func (g *Game) Phase() Phase {
+	switch g.currentState {
+	case gameStatePlaceScouts:
+		return PhasePlaceScouts
+	case gameStatePlay:
+		return PhasePlay
+	default:
+		return PhaseEnded
+	}
+}
+
 // CurrentTurn returns the current turn.
 func (g *Game) CurrentTurn() CurrentTurn {
 	return g.currentTurn
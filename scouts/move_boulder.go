@@ -93,7 +93,7 @@ func (m *BoulderMove) apply(game *Game) {
 		position: boulderPiecePosition(m.TopLeft),
 	}
 
-	game.board.updatePiece(boulderPiece)
+	game.board.addPiece(boulderPiece)
 	game.playerPlaceBoulder(game.currentTurn.Player)
 	game.addMove(m, 1)
 }
@@ -15,6 +15,8 @@ type Piece interface {
 	Player() Player
 	// Position returns the position of the piece, which may be multiple points.
 	Position() []Point
+
+	clone() Piece
 }
 
 // ScoutPiece is a type that represents a scout piece on the board.
@@ -62,6 +64,11 @@ func (p *ScoutPiece) MarshalJSON() ([]byte, error) {
 	})
 }
 
+func (p *ScoutPiece) clone() Piece {
+	clone := *p
+	return &clone
+}
+
 func (p *ScoutPiece) winsGame() bool {
 	return p.returning && IsPlayerBase(p.player, p.position)
 }
@@ -112,6 +119,11 @@ func (p *BoulderPiece) MarshalJSON() ([]byte, error) {
 	})
 }
 
+func (p *BoulderPiece) clone() Piece {
+	clone := *p
+	return &clone
+}
+
 // PieceKind is a type that can either be ScoutPiece or BoulderPiece.
 type PieceKind string
 
@@ -12,6 +12,9 @@ type PossibleMoves struct {
 	Moves Moves `json:"moves"`
 	// CanPlaceBoulder is whether or not the player can place a boulder.
 	CanPlaceBoulder bool `json:"can_place_boulder"`
+	// BoulderSquares is the list of top-left points where the player can
+	// place a boulder. It is empty if CanPlaceBoulder is false.
+	BoulderSquares []Point `json:"boulder_squares"`
 }
 
 func (m PossibleMoves) String() string {
@@ -56,6 +59,7 @@ func calculatePossibleMoves(g *Game, p Player) PossibleMoves {
 
 		if !g.playerPlacedBoulder(p) {
 			moves.CanPlaceBoulder = true
+			moves.BoulderSquares = possibleBoulderSquares(g)
 		}
 
 		if (*SkipMove)(nil).validate(g) == nil {
@@ -90,6 +94,19 @@ func calculatePossibleMoves(g *Game, p Player) PossibleMoves {
 	}
 }
 
+func possibleBoulderSquares(g *Game) []Point {
+	var squares []Point
+	for y := BoardBounds.Min.Y; y < BoardBounds.Max.Y-1; y++ {
+		for x := BoardBounds.Min.X; x < BoardBounds.Max.X-1; x++ {
+			move := &BoulderMove{TopLeft: Pt(x, y)}
+			if move.validate(g) == nil {
+				squares = append(squares, move.TopLeft)
+			}
+		}
+	}
+	return squares
+}
+
 func generateAllDashMoves(scoutPosition Point) []*DashMove {
 	return []*DashMove{
 		{ScoutPosition: scoutPosition, Destination: scoutPosition.Add(Pt(+1, 0))},
@@ -172,3 +172,76 @@ func deepContains[T any](slice []T, v T) bool {
 		return reflect.DeepEqual(e, v)
 	})
 }
+
+func TestGameClone(t *testing.T) {
+	g := NewGame()
+	for i := 0; i < PlaceScoutTurns; i++ {
+		mustApply(t, g, PlayerA, fmt.Sprintf("place_scout %d,9", i))
+		mustApply(t, g, PlayerB, fmt.Sprintf("place_scout %d,0", i))
+	}
+
+	clone := g.Clone()
+	if FormatBoard(g.Board()) != FormatBoard(clone.Board()) {
+		t.Fatal("clone has a different board than the original game")
+	}
+	if !reflect.DeepEqual(g.PastTurns(), clone.PastTurns()) {
+		t.Fatal("clone has different past turns than the original game")
+	}
+
+	mustApply(t, clone, PlayerA, "dash 0,9 1,8")
+
+	if FormatBoard(g.Board()) == FormatBoard(clone.Board()) {
+		t.Fatal("applying a move to the clone should not affect the original game")
+	}
+	if g.CurrentTurn().Player != PlayerA {
+		t.Fatal("original game should still be player A's turn")
+	}
+	if !g.Board().PointIsPiece(Point{0, 9}, ScoutPieceKind) {
+		t.Fatal("original game should still have the scout at 0,9")
+	}
+}
+
+func mustApply(t *testing.T, g *Game, player Player, move string) {
+	t.Helper()
+	m, err := ParseMove(move)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Apply(player, m); err != nil {
+		t.Fatalf("cannot apply move %q for player %s: %v", move, player, err)
+	}
+}
+
+func TestPossibleMovesBoulderSquares(t *testing.T) {
+	g := NewGame()
+	if g.Phase() != PhasePlaceScouts {
+		t.Fatalf("expected phase %q, got %q", PhasePlaceScouts, g.Phase())
+	}
+
+	for i := 0; i < PlaceScoutTurns; i++ {
+		mustApply(t, g, PlayerA, fmt.Sprintf("place_scout %d,9", i))
+		mustApply(t, g, PlayerB, fmt.Sprintf("place_scout %d,0", i))
+	}
+
+	if g.Phase() != PhasePlay {
+		t.Fatalf("expected phase %q, got %q", PhasePlay, g.Phase())
+	}
+
+	moves := g.PossibleMoves(PlayerA)
+	if !moves.CanPlaceBoulder {
+		t.Fatal("expected player A to be able to place a boulder")
+	}
+
+	// There are 7x9 top-left squares on the board, and the scouts on both
+	// bases block 5 of them on each side.
+	if len(moves.BoulderSquares) != 7*9-2*5 {
+		t.Fatalf("expected %d boulder squares, got %d", 7*9-2*5, len(moves.BoulderSquares))
+	}
+
+	for _, square := range moves.BoulderSquares {
+		clone := g.Clone()
+		if err := clone.Apply(PlayerA, &BoulderMove{TopLeft: square}); err != nil {
+			t.Fatalf("boulder square %v is not legal: %v", square, err)
+		}
+	}
+}